@@ -0,0 +1,1124 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"monkey/parser"
+)
+
+// The result of type checking a program
+type Info struct {
+	// The type of every expression in the program. Generic bindings are
+	// recorded at the type they were instantiated with at each use.
+	Types map[parser.Expression]Type
+
+	// The symbol declared by each declaration, keyed by the declaring node
+	// (e.g. a *parser.AssignStmt or a *parser.FunctionParameter)
+	Defs map[any]*Symbol
+
+	// The symbol each identifier refers to
+	Uses map[*parser.IdentExpr]*Symbol
+
+	// The scope introduced by each block, lambda and loop, keyed by the node
+	// that introduces it
+	Scopes map[any]*Scope
+
+	// The variant constructed by each constructor call, or by a variant name
+	// without a payload
+	Variants map[parser.Expression]string
+
+	// The builtin function called by each call to len or push, unless a
+	// binding of the same name shadows it
+	Builtins map[*parser.CallExpr]string
+
+	// The top level scope of the program
+	Global *Scope
+}
+
+// Returns the fully resolved type of an expression
+func (info *Info) TypeOf(expression parser.Expression) Type {
+	return Resolve(info.Types[expression])
+}
+
+// Returns the binding a program exits with, which is the int or bool bound by
+// its last statement. Every other program exits with 0, and so does every
+// backend.
+func (info *Info) ExitBinding(program parser.Program) *Symbol {
+	if len(program.Statements) == 0 {
+		return nil
+	}
+	assign, ok := program.Statements[len(program.Statements)-1].(*parser.AssignStmt)
+	if !ok {
+		return nil
+	}
+	symbol := info.Defs[assign]
+	if tipe, ok := Resolve(symbol.Scheme.Type).(*TCon); ok && (tipe.Name == T_INT.Name || tipe.Name == T_BOOL.Name) {
+		return symbol
+	}
+	return nil
+}
+
+type CheckError struct {
+	Error    error
+	Position int
+}
+
+func (e *CheckError) ToError(source string) error {
+	return errors.New(fmt.Sprint("[type err]: ", e.Error, "\nCulprit:\n>>> ", source))
+}
+
+// An error raised inside a statement, which knows the position of that
+// statement. Nested statements report the innermost position.
+type positionedError struct {
+	err      error
+	position int
+}
+
+func (e *positionedError) Error() string {
+	return e.err.Error()
+}
+
+type checker struct {
+	info     *Info
+	nextVars int
+
+	// The position of the statement being checked
+	position int
+
+	// Every type a generic variable was instantiated with, checked once the
+	// whole program has been seen
+	instantiations []instantiation
+
+	// Every type variable created, so that a session can undo the bindings
+	// made to them by a piece that fails to check
+	vars []*TVar
+}
+
+type instantiation struct {
+	tipe     Type
+	position int
+}
+
+// Checks the program, resolving every name and inferring every type
+func Check(program parser.Program) (*Info, *CheckError) {
+	session, err := NewSession().Check(program.Statements)
+	if err != nil {
+		return nil, err
+	}
+	return session.Info, nil
+}
+
+// Checks a program one piece at a time, each piece can use the bindings and
+// types declared by the pieces before it. Every piece adds to the same Info.
+type Session struct {
+	Info *Info
+
+	c   *checker
+	env *Env
+}
+
+func NewSession() *Session {
+	c := &checker{info: newInfo()}
+	env := NewEnv()
+	c.info.Global = env.scope
+	return &Session{Info: c.info, c: c, env: env}
+}
+
+func newInfo() *Info {
+	return &Info{
+		Types:    map[parser.Expression]Type{},
+		Defs:     map[any]*Symbol{},
+		Uses:     map[*parser.IdentExpr]*Symbol{},
+		Scopes:   map[any]*Scope{},
+		Variants: map[parser.Expression]string{},
+		Builtins: map[*parser.CallExpr]string{},
+	}
+}
+
+// Checks more statements of the program. Returns a session in which their
+// declarations are visible, the receiver is left as it was.
+func (s *Session) Check(statements []parser.Statement) (*Session, *CheckError) {
+	env := s.env
+	err := s.piece(func() *CheckError {
+		for _, statement := range statements {
+			var err error
+			env, err = s.c.checkStatement(statement, env)
+			if err != nil {
+				return toCheckError(err)
+			}
+		}
+		return s.c.checkInstantiations()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Session{Info: s.Info, c: s.c, env: env}, nil
+}
+
+// Infers the type of an expression on its own, as if it was the right hand
+// side of a new binding
+func (s *Session) CheckExpression(expression parser.Expression) (Type, *CheckError) {
+	var tipe Type
+	err := s.piece(func() *CheckError {
+		var err error
+		if tipe, err = s.c.inferExpression(expression, s.env); err != nil {
+			return toCheckError(err)
+		}
+		return s.c.checkInstantiations()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return Resolve(tipe), nil
+}
+
+// Checks a piece, which only adds to Info and binds the type variables of
+// earlier pieces if it succeeds. Otherwise a binding could be unified with
+// the type of a value the failed piece never gave it.
+func (s *Session) piece(check func() *CheckError) *CheckError {
+	instances := make([]Type, len(s.c.vars))
+	for i, v := range s.c.vars {
+		instances[i] = v.Instance
+	}
+	pending := newInfo()
+	s.c.info = pending
+	defer func() { s.c.info = s.Info }()
+
+	if err := check(); err != nil {
+		for i, instance := range instances {
+			s.c.vars[i].Instance = instance
+		}
+		s.c.vars = s.c.vars[:len(instances)]
+		s.c.instantiations = nil
+		return err
+	}
+
+	merge(s.Info.Types, pending.Types)
+	merge(s.Info.Defs, pending.Defs)
+	merge(s.Info.Uses, pending.Uses)
+	merge(s.Info.Scopes, pending.Scopes)
+	merge(s.Info.Variants, pending.Variants)
+	merge(s.Info.Builtins, pending.Builtins)
+	return nil
+}
+
+func merge[K comparable, V any](into map[K]V, from map[K]V) {
+	for key, value := range from {
+		into[key] = value
+	}
+}
+
+// Errors outside of any statement are reported at the start of the input
+func toCheckError(err error) *CheckError {
+	var positioned *positionedError
+	if errors.As(err, &positioned) {
+		return &CheckError{positioned.err, positioned.position}
+	}
+	return &CheckError{err, 0}
+}
+
+// Generic code is compiled once for all instantiations, which only works if
+// every value it handles is the same size. The instantiations are checked once
+// all the code that could resolve them has been seen.
+func (c *checker) checkInstantiations() *CheckError {
+	instantiations := c.instantiations
+	c.instantiations = nil
+	for _, inst := range instantiations {
+		if !isScalar(inst.tipe) {
+			err := fmt.Sprint("generic functions can not be used with values of type ", Resolve(inst.tipe).Render())
+			return &CheckError{errors.New(err), inst.position}
+		}
+	}
+	return nil
+}
+
+// Whether values of the type fit in a single machine word. A list does, but
+// the garbage collector has to know it is a pointer wherever it is stored.
+func isScalar(t Type) bool {
+	switch t := Prune(t).(type) {
+	case *TArray, *TTuple, *TStruct, *TUnion:
+		return false
+	case *TCon:
+		return t.Name != "list" && t.Name != "option"
+	}
+	return true
+}
+
+func (c *checker) fresh() *TVar {
+	c.nextVars++
+	v := &TVar{Id: c.nextVars}
+	c.vars = append(c.vars, v)
+	return v
+}
+
+// Replaces the generic variables of a scheme with fresh type variables
+func (c *checker) instantiate(scheme Scheme) Type {
+	mapping := map[*TVar]Type{}
+	for _, v := range scheme.Vars {
+		fresh := c.fresh()
+		mapping[v] = fresh
+		c.instantiations = append(c.instantiations, instantiation{fresh, c.position})
+	}
+	return substitute(scheme.Type, mapping)
+}
+
+// Whether an expression is a value that can be generalised, because
+// evaluating it can't create a list. A list is mutable, so a generic one
+// could have values of different types pushed into it.
+func (c *checker) isValue(expression parser.Expression) bool {
+	switch expression := expression.(type) {
+	case *parser.IdentExpr, *parser.IntExpr, *parser.BoolExpr, *parser.LambdaExpr:
+		return true
+	case *parser.TupleExpr:
+		return c.allValues(expression.Elements)
+	case *parser.ArrayExpr:
+		return c.allValues(expression.Elements)
+	case *parser.StructExpr:
+		for _, field := range expression.Fields {
+			if !c.isValue(field.Value) {
+				return false
+			}
+		}
+		return true
+	case *parser.CallExpr:
+		_, constructor := c.info.Variants[expression]
+		return constructor && c.allValues(expression.Arguments)
+	}
+	return false
+}
+
+func (c *checker) allValues(expressions []parser.Expression) bool {
+	for _, expression := range expressions {
+		if !c.isValue(expression) {
+			return false
+		}
+	}
+	return true
+}
+
+// Generalises over the type variables that are not constrained by the environment
+func generalize(t Type, env *Env) Scheme {
+	envVars := env.freeVars()
+	var vars []*TVar
+	for _, v := range freeVars(t, nil) {
+		if !containsVar(envVars, v) {
+			vars = append(vars, v)
+		}
+	}
+	return Scheme{Vars: vars, Type: t}
+}
+
+func unify(a, b Type) error {
+	a, b = Prune(a), Prune(b)
+
+	if v, ok := a.(*TVar); ok {
+		if a == b {
+			return nil
+		}
+		if occursIn(v, b) {
+			return errors.New(fmt.Sprint("infinite type: ", v.Render(), " occurs in ", b.Render()))
+		}
+		v.Instance = b
+		return nil
+	}
+	if _, ok := b.(*TVar); ok {
+		return unify(b, a)
+	}
+
+	switch a := a.(type) {
+	case *TCon:
+		if b, ok := b.(*TCon); ok && a.Name == b.Name && len(a.Args) == len(b.Args) {
+			for i := range a.Args {
+				if err := unify(a.Args[i], b.Args[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case *TArrow:
+		if b, ok := b.(*TArrow); ok && len(a.Params) == len(b.Params) {
+			for i := range a.Params {
+				if err := unify(a.Params[i], b.Params[i]); err != nil {
+					return err
+				}
+			}
+			return unify(a.Returns, b.Returns)
+		}
+	case *TArray:
+		if b, ok := b.(*TArray); ok && a.Length == b.Length {
+			return unify(a.Elem, b.Elem)
+		}
+	case *TTuple:
+		if b, ok := b.(*TTuple); ok && len(a.Elems) == len(b.Elems) {
+			for i := range a.Elems {
+				if err := unify(a.Elems[i], b.Elems[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case *TStruct, *TUnion:
+		if a == b {
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprint("cannot unify ", a.Render(), " with ", b.Render()))
+}
+
+// Unifies the type that was found with the type that was expected, reporting both if they conflict
+func expect(expected Type, found Type) error {
+	expectedRepr, foundRepr := expected.Render(), found.Render()
+	if err := unify(expected, found); err != nil {
+		return errors.New(fmt.Sprint("type mismatch: expected ", expectedRepr, ", found ", foundRepr, " (", err, ")"))
+	}
+	return nil
+}
+
+func (c *checker) checkStatement(statement parser.Statement, env *Env) (*Env, error) {
+	outer := c.position
+	c.position = statement.Position()
+	defer func() { c.position = outer }()
+
+	var err error
+	switch statement := statement.(type) {
+	case *parser.AssignStmt:
+		env, err = c.checkAssignStmt(statement, env)
+	case *parser.DestructureStmt:
+		env, err = c.checkDestructureStmt(statement, env)
+	case *parser.ReassignStmt:
+		err = c.checkReassignStmt(statement, env)
+	case *parser.TypeDeclStmt:
+		env, err = c.checkTypeDeclStmt(statement, env)
+	case *parser.WhileStmt:
+		err = c.checkWhileStmt(statement, env)
+	case *parser.ForStmt:
+		err = c.checkForStmt(statement, env)
+	case *parser.BreakStmt, *parser.ContinueStmt:
+		if !env.inLoop {
+			err = errors.New("break and continue can only be used inside a loop")
+		}
+	default:
+		err = errors.New("unexpected statement type")
+	}
+
+	var positioned *positionedError
+	if err != nil && !errors.As(err, &positioned) {
+		err = &positionedError{err, statement.Position()}
+	}
+	return env, err
+}
+
+func (c *checker) checkAssignStmt(statement *parser.AssignStmt, env *Env) (*Env, error) {
+	var annotated Type
+	if statement.Tipe != nil {
+		var err error
+		annotated, err = env.lookupType(statement.Tipe)
+		if err != nil {
+			return env, err
+		}
+	}
+
+	tipe, err := c.inferExpression(statement.Rhs, env)
+	if err != nil {
+		return env, err
+	}
+
+	if annotated != nil {
+		if err := expect(annotated, tipe); err != nil {
+			return env, err
+		}
+	}
+
+	// Mutable bindings can not be generic, otherwise values of different
+	// types could be written to them. Neither can the results of anything
+	// but a value, which might be a list that values are pushed into.
+	scheme := Scheme{Type: tipe}
+	if !statement.Mutable && c.isValue(statement.Rhs) {
+		scheme = generalize(tipe, env)
+	}
+
+	env, symbol := env.addBinding(statement.Lhs, scheme)
+	symbol.Mutable = statement.Mutable
+	c.info.Defs[statement] = symbol
+	return env, nil
+}
+
+// Each name is bound to one element of a tuple, as if by its own assignment
+func (c *checker) checkDestructureStmt(statement *parser.DestructureStmt, env *Env) (*Env, error) {
+	var annotated Type
+	if statement.Tipe != nil {
+		var err error
+		annotated, err = env.lookupType(statement.Tipe)
+		if err != nil {
+			return env, err
+		}
+	}
+
+	tipe, err := c.inferExpression(statement.Rhs, env)
+	if err != nil {
+		return env, err
+	}
+
+	if annotated != nil {
+		if err := expect(annotated, tipe); err != nil {
+			return env, err
+		}
+	}
+
+	// A value of unknown type is assumed to be a tuple of the right length
+	if v, ok := Prune(tipe).(*TVar); ok {
+		elems := make([]Type, 0, len(statement.Lhs))
+		for range statement.Lhs {
+			elems = append(elems, c.fresh())
+		}
+		if err := unify(v, &TTuple{Elems: elems}); err != nil {
+			return env, err
+		}
+	}
+
+	tuple, ok := Prune(tipe).(*TTuple)
+	if !ok {
+		return env, errors.New(fmt.Sprint("cannot destructure a value of type ", tipe.Render()))
+	}
+	if len(tuple.Elems) != len(statement.Lhs) {
+		err := fmt.Sprint("cannot destructure ", tuple.Render(), " into ", len(statement.Lhs), " names")
+		return env, errors.New(err)
+	}
+
+	outer := env
+	for i := range statement.Lhs {
+		name := &statement.Lhs[i]
+		for _, other := range statement.Lhs[:i] {
+			if other.Name == name.Name {
+				return env, errors.New(fmt.Sprint(name.Name, " is bound more than once"))
+			}
+		}
+
+		scheme := Scheme{Type: tuple.Elems[i]}
+		if !statement.Mutable && c.isValue(statement.Rhs) {
+			scheme = generalize(tuple.Elems[i], outer)
+		}
+
+		var symbol *Symbol
+		env, symbol = env.addBinding(name.Name, scheme)
+		symbol.Mutable = statement.Mutable
+		c.info.Defs[name] = symbol
+	}
+	return env, nil
+}
+
+// Struct and union types get a fresh nominal type, anything else is an alias
+func (c *checker) checkTypeDeclStmt(statement *parser.TypeDeclStmt, env *Env) (*Env, error) {
+	if _, ok := env.types[statement.Name]; ok {
+		return env, errors.New(fmt.Sprint("type ", statement.Name, " is already declared"))
+	}
+
+	if unionType, ok := statement.Tipe.(*parser.UnionType); ok {
+		return c.checkUnionDecl(statement.Name, unionType, env)
+	}
+
+	structType, ok := statement.Tipe.(*parser.StructType)
+	if !ok {
+		tipe, err := env.lookupType(statement.Tipe)
+		if err != nil {
+			return env, err
+		}
+		return env.addType(statement.Name, tipe), nil
+	}
+
+	// The struct's own name is not visible yet, so it can't contain itself
+	tipe := &TStruct{Name: statement.Name}
+	for _, field := range structType.Fields {
+		if tipe.Field(field.Name) != nil {
+			return env, errors.New(fmt.Sprint("duplicate field ", field.Name, " in ", statement.Name))
+		}
+		fieldType, err := env.lookupType(field.Tipe)
+		if err != nil {
+			return env, err
+		}
+		tipe.Fields = append(tipe.Fields, Field{Name: field.Name, Type: fieldType})
+	}
+	return env.addType(statement.Name, tipe), nil
+}
+
+func (c *checker) checkUnionDecl(name string, unionType *parser.UnionType, env *Env) (*Env, error) {
+	// The union's own name is not visible yet, so it can't contain itself
+	tipe := &TUnion{Name: name}
+	for _, variant := range unionType.Variants {
+		if tipe.Variant(variant.Name) != nil {
+			return env, errors.New(fmt.Sprint("duplicate variant ", variant.Name, " in ", name))
+		}
+		// Otherwise some and none would construct different types depending
+		// on what has been declared
+		if variant.Name == "some" || variant.Name == "none" {
+			return env, errors.New(fmt.Sprint("variant ", variant.Name, " in ", name, " is reserved for options"))
+		}
+		payload := make([]Type, 0, len(variant.Payload))
+		for _, param := range variant.Payload {
+			t_param, err := env.lookupType(param)
+			if err != nil {
+				return env, err
+			}
+			payload = append(payload, t_param)
+		}
+		tipe.Variants = append(tipe.Variants, Variant{Name: variant.Name, Payload: payload})
+	}
+	return env.addType(name, tipe), nil
+}
+
+func (c *checker) checkWhileStmt(statement *parser.WhileStmt, env *Env) error {
+	cond, err := c.inferExpression(statement.Cond, env)
+	if err != nil {
+		return err
+	}
+	if err := expect(T_BOOL, cond); err != nil {
+		return err
+	}
+
+	bodyEnv := env.enter()
+	bodyEnv.inLoop = true
+	c.info.Scopes[statement] = bodyEnv.scope
+	return c.checkStatements(statement.Body, bodyEnv)
+}
+
+func (c *checker) checkForStmt(statement *parser.ForStmt, env *Env) error {
+	// The loop variable is an element of the list, or an integer in the range
+	var variable Type = T_INT
+	if statement.To == nil {
+		variable = c.fresh()
+		tipe, err := c.inferExpression(statement.From, env)
+		if err != nil {
+			return err
+		}
+		if err := expect(T_LIST(variable), tipe); err != nil {
+			return err
+		}
+	} else {
+		for _, bound := range []parser.Expression{statement.From, statement.To} {
+			tipe, err := c.inferExpression(bound, env)
+			if err != nil {
+				return err
+			}
+			if err := expect(T_INT, tipe); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The loop variable is only visible inside the body
+	bodyEnv := env.enter()
+	bodyEnv.inLoop = true
+	c.info.Scopes[statement] = bodyEnv.scope
+
+	bodyEnv, symbol := bodyEnv.addBinding(statement.Var, Scheme{Type: variable})
+	c.info.Defs[statement] = symbol
+	return c.checkStatements(statement.Body, bodyEnv)
+}
+
+// Checks a sequence of statements, the bindings they declare are dropped at the end
+func (c *checker) checkStatements(statements []parser.Statement, env *Env) error {
+	for _, statement := range statements {
+		var err error
+		env, err = c.checkStatement(statement, env)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkReassignStmt(statement *parser.ReassignStmt, env *Env) error {
+	target, err := c.inferAssignable(statement.Lhs, env)
+	if err != nil {
+		return err
+	}
+
+	tipe, err := c.inferExpression(statement.Rhs, env)
+	if err != nil {
+		return err
+	}
+
+	return expect(target, tipe)
+}
+
+// Infers the type of an expression that is written to
+func (c *checker) inferAssignable(expression parser.Expression, env *Env) (Type, error) {
+	switch expression := expression.(type) {
+	case *parser.IdentExpr:
+		symbol, err := env.lookup(expression.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !symbol.Mutable {
+			return nil, errors.New(fmt.Sprint("cannot assign to immutable binding ", symbol.Name))
+		}
+		c.info.Uses[expression] = symbol
+		c.info.Types[expression] = symbol.Scheme.Type
+		return symbol.Scheme.Type, nil
+	case *parser.IndexExpr:
+		// Elements can be assigned if the array itself can be
+		if _, err := c.inferAssignable(expression.Array, env); err != nil {
+			return nil, err
+		}
+		return c.inferExpression(expression, env)
+	case *parser.FieldExpr:
+		// Fields can be assigned if the struct itself can be
+		if _, err := c.inferAssignable(expression.Struct, env); err != nil {
+			return nil, err
+		}
+		return c.inferExpression(expression, env)
+	}
+	return nil, errors.New("cannot assign to expression")
+}
+
+func (c *checker) inferExpression(expression parser.Expression, env *Env) (Type, error) {
+	tipe, err := c.inferExpressionType(expression, env)
+	if err != nil {
+		return nil, err
+	}
+	c.info.Types[expression] = tipe
+	return tipe, nil
+}
+
+func (c *checker) inferExpressionType(expression parser.Expression, env *Env) (Type, error) {
+	switch expression := expression.(type) {
+	case *parser.IntExpr:
+		return T_INT, nil
+	case *parser.BoolExpr:
+		return T_BOOL, nil
+	case *parser.IdentExpr:
+		symbol, err := env.lookup(expression.Name)
+		if err != nil {
+			// Bindings shadow variants of the same name
+			if union, ok := c.constructs(expression.Name, env); ok {
+				return c.inferConstructor(expression, expression.Name, union, []parser.Expression{}, env)
+			}
+			return nil, err
+		}
+		c.info.Uses[expression] = symbol
+		return c.instantiate(symbol.Scheme), nil
+	case *parser.AddExpr:
+		return c.inferInfix(expression.Lhs, expression.Rhs, T_INT, env)
+	case *parser.SubExpr:
+		return c.inferInfix(expression.Lhs, expression.Rhs, T_INT, env)
+	case *parser.LessThanExpr:
+		return c.inferInfix(expression.Lhs, expression.Rhs, T_BOOL, env)
+	case *parser.GreaterThanExpr:
+		return c.inferInfix(expression.Lhs, expression.Rhs, T_BOOL, env)
+	case *parser.BlockBodyExpr:
+		// the stuff inside the block can't peek out
+		blockEnv := env.isolated()
+		c.info.Scopes[expression] = blockEnv.scope
+		return c.inferBlockBody(expression, blockEnv)
+	case *parser.LambdaExpr:
+		return c.inferLambda(expression, env)
+	case *parser.CallExpr:
+		return c.inferCall(expression, env)
+	case *parser.ArrayExpr:
+		return c.inferArray(expression, env)
+	case *parser.ListExpr:
+		var elem Type = c.fresh()
+		for _, element := range expression.Elements {
+			tipe, err := c.inferExpression(element, env)
+			if err != nil {
+				return nil, err
+			}
+			if err := expect(elem, tipe); err != nil {
+				return nil, err
+			}
+		}
+		return T_LIST(elem), nil
+	case *parser.TupleExpr:
+		elems := make([]Type, 0, len(expression.Elements))
+		for _, element := range expression.Elements {
+			tipe, err := c.inferExpression(element, env)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, tipe)
+		}
+		return &TTuple{Elems: elems}, nil
+	case *parser.IndexExpr:
+		return c.inferIndex(expression, env)
+	case *parser.StructExpr:
+		return c.inferStruct(expression, env)
+	case *parser.FieldExpr:
+		return c.inferField(expression, env)
+	case *parser.MatchExpr:
+		return c.inferMatch(expression, env)
+	}
+	return nil, errors.New("unexpected expression type")
+}
+
+// Every element of an array has the same type
+func (c *checker) inferArray(expression *parser.ArrayExpr, env *Env) (Type, error) {
+	var elem Type = c.fresh()
+	for _, element := range expression.Elements {
+		tipe, err := c.inferExpression(element, env)
+		if err != nil {
+			return nil, err
+		}
+		if err := expect(elem, tipe); err != nil {
+			return nil, err
+		}
+	}
+	return &TArray{Elem: elem, Length: len(expression.Elements)}, nil
+}
+
+// The length of an array is part of its type, so it must already be known
+// when the array is indexed. The length of a list is checked at runtime.
+func (c *checker) inferIndex(expression *parser.IndexExpr, env *Env) (Type, error) {
+	tipe, err := c.inferExpression(expression.Array, env)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := c.inferExpression(expression.Index, env)
+	if err != nil {
+		return nil, err
+	}
+	if err := expect(T_INT, index); err != nil {
+		return nil, err
+	}
+
+	switch tipe := Prune(tipe).(type) {
+	case *TArray:
+		return tipe.Elem, nil
+	case *TCon:
+		if tipe.Name == "list" {
+			return tipe.Args[0], nil
+		}
+	case *TVar:
+		return nil, errors.New("cannot index a value of unknown type, add a type annotation")
+	}
+	return nil, errors.New(fmt.Sprint("cannot index a value of type ", tipe.Render()))
+}
+
+// A struct literal initialises every field of the struct exactly once
+func (c *checker) inferStruct(expression *parser.StructExpr, env *Env) (Type, error) {
+	tipe, ok := env.types[expression.Name].(*TStruct)
+	if !ok {
+		return nil, errors.New(fmt.Sprint("not a struct type: ", expression.Name))
+	}
+
+	initialised := map[string]bool{}
+	for _, init := range expression.Fields {
+		field := tipe.Field(init.Name)
+		if field == nil {
+			return nil, errors.New(fmt.Sprint(tipe.Name, " has no field ", init.Name))
+		}
+		if initialised[init.Name] {
+			return nil, errors.New(fmt.Sprint("field ", init.Name, " is initialised more than once"))
+		}
+		initialised[init.Name] = true
+
+		value, err := c.inferExpression(init.Value, env)
+		if err != nil {
+			return nil, err
+		}
+		if err := expect(field.Type, value); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, field := range tipe.Fields {
+		if !initialised[field.Name] {
+			return nil, errors.New(fmt.Sprint("missing field ", field.Name, " in ", tipe.Name))
+		}
+	}
+	return tipe, nil
+}
+
+// Like indexing, the struct type must already be known to access a field
+func (c *checker) inferField(expression *parser.FieldExpr, env *Env) (Type, error) {
+	tipe, err := c.inferExpression(expression.Struct, env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tipe := Prune(tipe).(type) {
+	case *TStruct:
+		if field := tipe.Field(expression.Field); field != nil {
+			return field.Type, nil
+		}
+		return nil, errors.New(fmt.Sprint(tipe.Name, " has no field ", expression.Field))
+	case *TVar:
+		return nil, errors.New("cannot access a field of a value of unknown type, add a type annotation")
+	}
+	return nil, errors.New(fmt.Sprint("cannot access field ", expression.Field, " of a value of type ", tipe.Render()))
+}
+
+// Both operands of an infix operator are integers
+func (c *checker) inferInfix(lhs parser.Expression, rhs parser.Expression, result Type, env *Env) (Type, error) {
+	for _, operand := range []parser.Expression{lhs, rhs} {
+		tipe, err := c.inferExpression(operand, env)
+		if err != nil {
+			return nil, err
+		}
+		if err := expect(T_INT, tipe); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (c *checker) inferBlockBody(expression *parser.BlockBodyExpr, env *Env) (Type, error) {
+	for _, statement := range expression.Statements {
+		var err error
+		env, err = c.checkStatement(statement, env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.inferExpression(expression.Final, env)
+}
+
+func (c *checker) inferLambda(expression *parser.LambdaExpr, env *Env) (Type, error) {
+	// Lambdas can only see their own parameters
+	bodyEnv := env.isolated()
+	c.info.Scopes[expression] = bodyEnv.scope
+
+	params := make([]Type, 0, len(expression.Parameters))
+	for i := range expression.Parameters {
+		param := &expression.Parameters[i]
+		var tipe Type = c.fresh()
+		if param.Tipe != nil {
+			var err error
+			tipe, err = env.lookupType(param.Tipe)
+			if err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, tipe)
+		var symbol *Symbol
+		bodyEnv, symbol = bodyEnv.addBinding(param.Name.Name, Scheme{Type: tipe})
+		c.info.Defs[param] = symbol
+	}
+
+	returns, err := c.inferBlockBody(&expression.Body, bodyEnv)
+	if err != nil {
+		return nil, err
+	}
+	c.info.Types[&expression.Body] = returns
+
+	if expression.Returns != nil {
+		annotated, err := env.lookupType(expression.Returns)
+		if err != nil {
+			return nil, err
+		}
+		if err := expect(annotated, returns); err != nil {
+			return nil, err
+		}
+	}
+
+	return &TArrow{Params: params, Returns: returns}, nil
+}
+
+func (c *checker) inferCall(expression *parser.CallExpr, env *Env) (Type, error) {
+	if ident, ok := expression.Callee.(*parser.IdentExpr); ok {
+		if _, err := env.lookup(ident.Name); err != nil {
+			if union, ok := c.constructs(ident.Name, env); ok {
+				return c.inferConstructor(expression, ident.Name, union, expression.Arguments, env)
+			}
+			if builtin, ok := builtins[ident.Name]; ok {
+				return c.inferBuiltin(expression, ident.Name, builtin, env)
+			}
+		}
+	}
+
+	callee, err := c.inferExpression(expression.Callee, env)
+	if err != nil {
+		return nil, err
+	}
+
+	arguments := make([]Type, 0, len(expression.Arguments))
+	for _, argument := range expression.Arguments {
+		tipe, err := c.inferExpression(argument, env)
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, tipe)
+	}
+
+	switch callee := Prune(callee).(type) {
+	case *TVar:
+		returns := c.fresh()
+		if err := unify(callee, &TArrow{Params: arguments, Returns: returns}); err != nil {
+			return nil, err
+		}
+		return returns, nil
+	case *TArrow:
+		if len(callee.Params) != len(arguments) {
+			err := fmt.Sprint("expected ", len(callee.Params), " arguments, got ", len(arguments))
+			return nil, errors.New(err)
+		}
+		for i := range arguments {
+			if err := expect(callee.Params[i], arguments[i]); err != nil {
+				return nil, err
+			}
+		}
+		return callee.Returns, nil
+	}
+	return nil, errors.New(fmt.Sprint("cannot call a value of type ", callee.Render()))
+}
+
+// The types of the builtin functions, which are generic over their element
+// type. They are not values, so they can only be called.
+var builtins = map[string]func(elem Type) *TArrow{
+	// The number of elements in a list
+	"len": func(elem Type) *TArrow {
+		return &TArrow{Params: []Type{T_LIST(elem)}, Returns: T_INT}
+	},
+	// Appends an element to a list, returning the new length
+	"push": func(elem Type) *TArrow {
+		return &TArrow{Params: []Type{T_LIST(elem), elem}, Returns: T_INT}
+	},
+}
+
+func (c *checker) inferBuiltin(expression *parser.CallExpr, name string, builtin func(Type) *TArrow, env *Env) (Type, error) {
+	tipe := builtin(c.fresh())
+	if len(tipe.Params) != len(expression.Arguments) {
+		err := fmt.Sprint(name, " expects ", len(tipe.Params), " arguments, got ", len(expression.Arguments))
+		return nil, errors.New(err)
+	}
+
+	for i, argument := range expression.Arguments {
+		argType, err := c.inferExpression(argument, env)
+		if err != nil {
+			return nil, err
+		}
+		if err := expect(tipe.Params[i], argType); err != nil {
+			return nil, err
+		}
+	}
+
+	c.info.Builtins[expression] = name
+	return tipe.Returns, nil
+}
+
+// Returns the type constructed by a variant. Some and none construct an
+// option of a value that is not known yet.
+func (c *checker) constructs(name string, env *Env) (Type, bool) {
+	if name == "some" || name == "none" {
+		return T_OPTION(c.fresh()), true
+	}
+	if union, ok := env.variants[name]; ok {
+		return union, true
+	}
+	return nil, false
+}
+
+// Returns the variant of a union or an option with the given name, or nil if
+// there is none
+func variantOf(t Type, name string) *Variant {
+	variants := VariantsOf(t)
+	for i := range variants {
+		if variants[i].Name == name {
+			return &variants[i]
+		}
+	}
+	return nil
+}
+
+// Constructs a variant of a union or an option from its payload
+func (c *checker) inferConstructor(expression parser.Expression, name string, union Type, arguments []parser.Expression, env *Env) (Type, error) {
+	variant := variantOf(union, name)
+	if len(variant.Payload) != len(arguments) {
+		err := fmt.Sprint(name, " expects ", len(variant.Payload), " values, got ", len(arguments))
+		return nil, errors.New(err)
+	}
+
+	for i, argument := range arguments {
+		tipe, err := c.inferExpression(argument, env)
+		if err != nil {
+			return nil, err
+		}
+		if err := expect(variant.Payload[i], tipe); err != nil {
+			return nil, err
+		}
+	}
+
+	c.info.Variants[expression] = name
+	return union, nil
+}
+
+// Every arm of a match has the same type, and together the arms must cover
+// every variant of the union or option
+func (c *checker) inferMatch(expression *parser.MatchExpr, env *Env) (Type, error) {
+	value, err := c.inferExpression(expression.Value, env)
+	if err != nil {
+		return nil, err
+	}
+
+	// The union being matched on is known from the variants in the arms
+	for _, arm := range expression.Arms {
+		if arm.Variant == "_" {
+			continue
+		}
+		union, ok := c.constructs(arm.Variant, env)
+		if !ok {
+			return nil, errors.New(fmt.Sprint("unknown variant ", arm.Variant))
+		}
+		if err := expect(union, value); err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	var result Type = c.fresh()
+	matched := map[string]bool{}
+	wildcard := false
+	for i := range expression.Arms {
+		arm := &expression.Arms[i]
+		if wildcard {
+			return nil, errors.New("unreachable match arm after _")
+		}
+
+		// The bindings of an arm are only visible in its body
+		armEnv := env.enter()
+		c.info.Scopes[arm] = armEnv.scope
+
+		if arm.Variant == "_" {
+			if len(arm.Bindings) > 0 {
+				return nil, errors.New("the _ pattern can not bind values")
+			}
+			wildcard = true
+		} else {
+			variant := variantOf(value, arm.Variant)
+			if variant == nil {
+				return nil, errors.New(fmt.Sprint(arm.Variant, " is not a variant of ", Prune(value).Render()))
+			}
+			if matched[arm.Variant] {
+				return nil, errors.New(fmt.Sprint("variant ", arm.Variant, " is matched more than once"))
+			}
+			matched[arm.Variant] = true
+
+			if len(arm.Bindings) != len(variant.Payload) {
+				err := fmt.Sprint(arm.Variant, " has ", len(variant.Payload), " values, got ", len(arm.Bindings), " bindings")
+				return nil, errors.New(err)
+			}
+			for j := range arm.Bindings {
+				var symbol *Symbol
+				armEnv, symbol = armEnv.addBinding(arm.Bindings[j].Name, Scheme{Type: variant.Payload[j]})
+				c.info.Defs[&arm.Bindings[j]] = symbol
+			}
+		}
+
+		body, err := c.inferExpression(arm.Body, armEnv)
+		if err != nil {
+			return nil, err
+		}
+		if err := expect(result, body); err != nil {
+			return nil, err
+		}
+	}
+
+	if !wildcard {
+		for _, variant := range VariantsOf(value) {
+			if !matched[variant.Name] {
+				return nil, errors.New(fmt.Sprint("match is not exhaustive, missing ", variant.Name))
+			}
+		}
+	}
+	return result, nil
+}
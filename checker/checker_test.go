@@ -0,0 +1,412 @@
+package checker
+
+import (
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func parseHelper(t *testing.T, s string) parser.Program {
+	lexer := lexer.New(&s)
+	program, error := parser.ParseProgram(lexer)
+	if error != nil {
+		t.Fatal("Could not parse program: ", error)
+	}
+	return *program
+}
+
+func TestCheckGenericFunctions(t *testing.T) {
+	program := parseHelper(t, `
+		let id = def (x) { x }
+		let compose = def (f, g, x) { f(g(x)) }
+		let isSmall = def (n: int) { n < 10 }
+		let a = id(3)
+		let b = id(true)
+		let c = compose(isSmall, id, 4)
+	`)
+
+	info, err := Check(program)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+
+	var test = func(index int, expected string) {
+		statement := program.Statements[index].(*parser.AssignStmt)
+		if result := info.TypeOf(statement.Rhs).Render(); result != expected {
+			t.Fatalf("Expected \"%s\" to have type \"%s\", got \"%s\"", statement.Lhs, expected, result)
+		}
+	}
+
+	test(3, "int")
+	test(4, "bool")
+	test(5, "bool")
+
+	// The bindings should be generic over their type variables
+	id := info.Defs[program.Statements[0]].Scheme
+	if len(id.Vars) != 1 {
+		t.Fatalf("Expected id to be generic over 1 variable, got %d", len(id.Vars))
+	}
+	compose := info.Defs[program.Statements[1]].Scheme
+	if len(compose.Vars) != 3 {
+		t.Fatalf("Expected compose to be generic over 3 variables, got %d", len(compose.Vars))
+	}
+}
+
+func TestCheckResolvesNames(t *testing.T) {
+	program := parseHelper(t, `
+		let x = 1
+		let f = def (x) { x }
+		let y = x
+	`)
+
+	info, err := Check(program)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+
+	outer := info.Defs[program.Statements[0]]
+	lambda := program.Statements[1].(*parser.AssignStmt).Rhs.(*parser.LambdaExpr)
+	param := info.Defs[&lambda.Parameters[0]]
+
+	// The parameter shadows the outer binding inside the lambda
+	if used := info.Uses[lambda.Body.Final.(*parser.IdentExpr)]; used != param {
+		t.Fatalf("Expected x in the lambda body to resolve to the parameter, got %+v", used)
+	}
+	if used := info.Uses[program.Statements[2].(*parser.AssignStmt).Rhs.(*parser.IdentExpr)]; used != outer {
+		t.Fatalf("Expected x at the top level to resolve to the let binding, got %+v", used)
+	}
+
+	if param.Scope != info.Scopes[lambda] || outer.Scope != info.Global {
+		t.Fatal("Expected symbols to be declared in their enclosing scope")
+	}
+	if len(info.Global.Symbols) != 3 {
+		t.Fatalf("Expected 3 symbols in the global scope, got %d", len(info.Global.Symbols))
+	}
+}
+
+func TestCheckArrays(t *testing.T) {
+	program := parseHelper(t, `
+		var m = [[1, 2], [3, 4]]
+		m[0][1] = 5
+		let first = def (a: [[int; 2]; 2]) { a[0] }
+		let row = first(m)
+	`)
+
+	info, err := Check(program)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+	row := program.Statements[3].(*parser.AssignStmt)
+	if result := info.TypeOf(row.Rhs).Render(); result != "[int; 2]" {
+		t.Fatalf("Expected \"[int; 2]\", got \"%s\"", result)
+	}
+}
+
+func TestCheckStructs(t *testing.T) {
+	program := parseHelper(t, `
+		type Point = { x: int, y: int }
+		type Line = { from: Point, to: Point }
+		var l = Line { to: Point { x: 3, y: 4 }, from: Point { x: 1, y: 2 } }
+		l.to.x = 5
+		let length = def (l: Line) { l.to.x - l.from.x }
+		let end = l.to
+	`)
+
+	info, err := Check(program)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+	length := program.Statements[4].(*parser.AssignStmt)
+	if result := info.TypeOf(length.Rhs).Render(); result != "(Line) -> int" {
+		t.Fatalf("Expected \"(Line) -> int\", got \"%s\"", result)
+	}
+	end := program.Statements[5].(*parser.AssignStmt)
+	if result := info.TypeOf(end.Rhs).Render(); result != "Point" {
+		t.Fatalf("Expected \"Point\", got \"%s\"", result)
+	}
+}
+
+func TestCheckUnions(t *testing.T) {
+	program := parseHelper(t, `
+		type Shape = Circle(int) | Rect(int, int) | Empty
+		let area = def (s) { match s { Circle(r) => r, Rect(w, h) => w + h, Empty => 0 } }
+		let shapes = [Circle(1), Empty]
+		let Empty = true
+	`)
+
+	info, err := Check(program)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+	area := program.Statements[1].(*parser.AssignStmt)
+	if result := info.TypeOf(area.Rhs).Render(); result != "(Shape) -> int" {
+		t.Fatalf("Expected \"(Shape) -> int\", got \"%s\"", result)
+	}
+	shapes := program.Statements[2].(*parser.AssignStmt).Rhs.(*parser.ArrayExpr)
+	if result := info.TypeOf(shapes).Render(); result != "[Shape; 2]" {
+		t.Fatalf("Expected \"[Shape; 2]\", got \"%s\"", result)
+	}
+	if variant := info.Variants[shapes.Elements[0]]; variant != "Circle" {
+		t.Fatalf("Expected the first element to construct Circle, got \"%s\"", variant)
+	}
+}
+
+func TestCheckOptions(t *testing.T) {
+	program := parseHelper(t, `
+		let unwrap = def (o, default) { match o { some(v) => v, none => default } }
+		let a = unwrap(some(3), 0)
+		let b: option<bool> = none
+	`)
+
+	info, err := Check(program)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+	unwrap := program.Statements[0].(*parser.AssignStmt)
+	if result := info.TypeOf(unwrap.Rhs).Render(); result != "(option<t2>, t2) -> t2" {
+		t.Fatalf("Expected \"(option<t2>, t2) -> t2\", got \"%s\"", result)
+	}
+	a := program.Statements[1].(*parser.AssignStmt)
+	if result := info.TypeOf(a.Rhs).Render(); result != "int" {
+		t.Fatalf("Expected \"int\", got \"%s\"", result)
+	}
+}
+
+func TestCheckTuples(t *testing.T) {
+	program := parseHelper(t, `
+		let split = def (p) { let (a, b) = p b }
+		let (x, y) = (1, true)
+		let z = split((x, y))
+	`)
+
+	info, err := Check(program)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+	split := program.Statements[0].(*parser.AssignStmt)
+	if result := info.TypeOf(split.Rhs).Render(); result != "((t2, t3)) -> t3" {
+		t.Fatalf("Expected \"((t2, t3)) -> t3\", got \"%s\"", result)
+	}
+	z := program.Statements[2].(*parser.AssignStmt)
+	if result := info.TypeOf(z.Rhs).Render(); result != "bool" {
+		t.Fatalf("Expected \"bool\", got \"%s\"", result)
+	}
+}
+
+func TestCheckLists(t *testing.T) {
+	program := parseHelper(t, `
+		var xs = list[]
+		let n = push(xs, (1, true))
+		for x in xs { let (a, b) = x }
+		let len = def (x: int) -> int { x }
+		let m = len(n)
+	`)
+
+	info, err := Check(program)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+	xs := program.Statements[0].(*parser.AssignStmt)
+	if result := info.TypeOf(xs.Rhs).Render(); result != "list<(int, bool)>" {
+		t.Fatalf("Expected \"list<(int, bool)>\", got \"%s\"", result)
+	}
+	push := program.Statements[1].(*parser.AssignStmt).Rhs.(*parser.CallExpr)
+	if builtin := info.Builtins[push]; builtin != "push" {
+		t.Fatalf("Expected a call to the push builtin, got \"%s\"", builtin)
+	}
+
+	// Bindings shadow the builtins
+	m := program.Statements[4].(*parser.AssignStmt).Rhs.(*parser.CallExpr)
+	if builtin, ok := info.Builtins[m]; ok {
+		t.Fatalf("Expected len to be shadowed, got a call to the %s builtin", builtin)
+	}
+}
+
+func TestCheckAnnotations(t *testing.T) {
+	program := parseHelper(t, `
+		let id = def (x) { x }
+		let f: (int) -> int = id
+		let g = def (x: bool) -> bool { x }
+	`)
+
+	info, err := Check(program)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+	g := program.Statements[2].(*parser.AssignStmt)
+	if result := info.TypeOf(g.Rhs).Render(); result != "(bool) -> bool" {
+		t.Fatalf("Expected \"(bool) -> bool\", got \"%s\"", result)
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+
+	var test = func(input string, position int, contains ...string) {
+		_, err := Check(parseHelper(t, input))
+		if err == nil {
+			t.Fatalf("Expected \"%s\" to fail type checking", input)
+		}
+		if err.Position != position {
+			t.Fatalf("Expected error at position %d, got %d", position, err.Position)
+		}
+		for _, s := range contains {
+			if !strings.Contains(err.Error.Error(), s) {
+				t.Fatalf("Expected error \"%s\" to contain \"%s\"", err.Error, s)
+			}
+		}
+	}
+
+	test("let x: bool = 3", 0, "expected bool", "found int")
+	test("let x = 3 let y = x < true", 9, "expected int", "found bool")
+	test("let x: invalid = 2", 0, "type not found: invalid")
+	test("let f = def (x) { x(x) }", 0, "infinite type")
+	test("let f = def (x: int) { x } let y = f(1, 2)", 26, "expected 1 arguments, got 2")
+	test("let f = def (g) { g(1) } let y = f(def (b: bool) { b })", 24, "expected (int) -> t", "found (bool) -> bool")
+	test("let x = 3 let f = def (y) { x }", 9, "unbound variable x")
+	test("let id = def (x) { x } let a: bool = id(3)", 22, "expected bool", "found int")
+
+	test("let x = 1 x = 2", 9, "cannot assign to immutable binding x")
+	test("var x = 1 x = true", 9, "expected int", "found bool")
+	test("var id = def (x) { x } let y = id(1) let z = id(true)", 36, "expected int", "found bool")
+
+	test("while 1 { }", 0, "expected bool", "found int")
+	test("let x = 1 break", 9, "break and continue can only be used inside a loop")
+	test("while true { let f = def () { continue 1 } }", 29, "inside a loop")
+	test("while true { let y = 1 } let z = y", 24, "unbound variable y")
+
+	test("for i in 0..true { }", 0, "expected int", "found bool")
+	test("for i in 0..10 { i = 2 }", 16, "cannot assign to immutable binding i")
+	test("for i in 0..10 { } let j = i", 18, "unbound variable i")
+
+	test("let a = [1, true]", 0, "expected int", "found bool")
+	test("let a: [int; 3] = [1, 2]", 0, "expected [int; 3]", "found [int; 2]")
+	test("let f = def (a) { a[0] }", 0, "cannot index a value of unknown type")
+	test("let a = [1, 2] let x = a[true]", 14, "expected int", "found bool")
+	test("let a = [1, 2] a[0] = 3", 14, "cannot assign to immutable binding a")
+	test("var a = [[1], [2]] a[0][0] = true", 18, "expected int", "found bool")
+	test("let x = 1 let y = x[0]", 9, "cannot index a value of type int")
+	test("let id = def (x) { x } let y = 1 let a = id([1, 2])", 32, "generic functions can not be used with values of type [int; 2]")
+
+	test("type P = { x: int, x: bool }", 0, "duplicate field x in P")
+	test("type P = { p: P }", 0, "type not found: P")
+	test("type int = { x: int }", 0, "type int is already declared")
+	test("let p: { x: int } = 1", 0, "struct types must be declared with a name")
+	test("type P = { x: int } let p = P { x: 1, y: 2 }", 19, "P has no field y")
+	test("type P = { x: int, y: int } let p = P { x: 1 }", 27, "missing field y in P")
+	test("type P = { x: int } let p = P { x: 1, x: 2 }", 19, "field x is initialised more than once")
+	test("type P = { x: int } let p = P { x: true }", 19, "expected int", "found bool")
+	test("let p = Q { x: 1 }", 0, "not a struct type: Q")
+	test("type P = { x: int } let p = P { x: 1 } let y = p.y", 38, "P has no field y")
+	test("type P = { x: int } let p = P { x: 1 } p.x = 2", 38, "cannot assign to immutable binding p")
+	test("let f = def (p) { p.x }", 0, "cannot access a field of a value of unknown type")
+	test("let x = 1 let y = x.z", 9, "cannot access field z of a value of type int")
+	test("type P = { x: int } type Q = { x: int } let p: P = Q { x: 1 }", 39, "expected P", "found Q")
+	test("type P = { x: int } let id = def (x) { x } let y = 1 let a = id(P { x: 1 })", 52, "generic functions can not be used with values of type P")
+
+	test("type S = A | A", 0, "duplicate variant A in S")
+	test("type S = A(S) | B", 0, "type not found: S")
+	test("type S = A(int) | B let s = A(true)", 19, "expected int", "found bool")
+	test("type S = A(int) | B let s = A(1, 2)", 19, "A expects 1 values, got 2")
+	test("type S = A(int) | B let s = A", 19, "A expects 1 values, got 0")
+	test("type S = A | B let x = match A { A => 1 }", 14, "match is not exhaustive, missing B")
+	test("type S = A | B let x = match A { A => 1, A => 2, B => 3 }", 14, "variant A is matched more than once")
+	test("type S = A | B let x = match A { _ => 1, A => 2 }", 14, "unreachable match arm after _")
+	test("type S = A | B let x = match A { A => 1, B => true }", 14, "expected int", "found bool")
+	test("type S = A(int) | B let x = match B { A => 1, B => 2 }", 19, "A has 1 values, got 0 bindings")
+	test("type S = A | B let x = match 1 { A => 1, B => 2 }", 14, "expected S", "found int")
+	test("type S = A | B type T = C | D let x = match A { A => 1, C => 2 }", 29, "C is not a variant of S")
+	test("let x = match 1 { C => 2 }", 0, "unknown variant C")
+	test("type S = A | B let x = match A { A => 1, B => 2 } let y = match A { _(z) => z }", 49, "the _ pattern can not bind values")
+
+	test("let (a, b) = 1", 0, "cannot destructure a value of type int")
+	test("let (a, b) = (1, 2, 3)", 0, "cannot destructure (int, int, int) into 2 names")
+	test("let (a, a) = (1, 2)", 0, "a is bound more than once")
+	test("let (a, b): (int, int) = (1, true)", 0, "expected (int, int)", "found (int, bool)")
+	test("let (a, b) = (1, 2) a = 3", 19, "cannot assign to immutable binding a")
+	test("let id = def (x) { x } let y = 1 let a = id((1, 2))", 32, "generic functions can not be used with values of type (int, int)")
+
+	test("let xs = list[1, true]", 0, "expected int", "found bool")
+	test("let xs: list<bool> = list[1]", 0, "expected list<bool>", "found list<int>")
+	test("let xs = list[1] let x = xs[true]", 16, "expected int", "found bool")
+	test("let xs = list[1] xs[0] = 2", 16, "cannot assign to immutable binding xs")
+	test("let xs = list[1] let n = push(xs, true)", 16, "expected int", "found bool")
+	test("let xs = list[1] let n = push(xs)", 16, "push expects 2 arguments, got 1")
+	test("let n = len(1)", 0, "expected list<t1>", "found int")
+	test("let f = len", 0, "unbound variable len")
+	test("for x in 1 { }", 0, "expected list<t1>", "found int")
+	test("let id = def (x) { x } let y = 1 let a = id(list[1])", 32, "generic functions can not be used with values of type list<int>")
+	// An empty list isn't generic, so it can only hold one type of element
+	test("let xs = list[] let a = push(xs, true) let c = xs[0] + 1", 38, "expected int", "found bool")
+	test("let (xs, n) = (list[], 1) let a = push(xs, true) let b = push(xs, 1)", 48, "expected bool", "found int")
+
+	test("let x: int = some(1)", 0, "expected int", "found option<int>")
+	test("let o = some(1) let x = o + 1", 15, "expected int", "found option<int>")
+	test("let o: option<int> = some(true)", 0, "expected option<int>", "found option<bool>")
+	test("let o = some(1, 2)", 0, "some expects 1 values, got 2")
+	test("let x = match some(1) { some(v) => v }", 0, "match is not exhaustive, missing none")
+	test("let x = match some(1) { none => 0, some => 1 }", 0, "some has 1 values, got 0 bindings")
+	test("type S = A | B let x = match some(1) { some(v) => v, A => 0 }", 14, "A is not a variant of option<int>")
+	test("let id = def (x) { x } let y = 1 let a = id(none)", 32, "generic functions can not be used with values of type option<t")
+	test("type Maybe = some(int) | none", 0, "variant some in Maybe is reserved for options")
+	test("type S = A | none", 0, "variant none in S is reserved for options")
+
+	// Errors inside a block report the position of the innermost statement
+	test("let x = {\n let y: bool = 1\n y }", 9, "expected bool")
+}
+
+func TestCheckSession(t *testing.T) {
+	first := parseHelper(t, "type Shape = Circle(int) | Empty let id = def (x) { x }")
+	second := parseHelper(t, "let a = id(Circle(2))")
+	invalid := parseHelper(t, "let b = id(true) let c = b + 1")
+
+	session, err := NewSession().Check(first.Statements)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+
+	// A piece that fails to check leaves the session as it was
+	if _, err := session.Check(invalid.Statements); err == nil || err.Position != 16 {
+		t.Fatalf("Expected an error at position 16, got %v", err)
+	}
+	if _, err := session.Check(parseHelper(t, "let x = b").Statements); err == nil {
+		t.Fatal("Expected b to be unbound after a failed check")
+	}
+
+	// Generic bindings of earlier pieces are instantiated with the values of
+	// later pieces
+	if _, err := session.Check(second.Statements); err == nil {
+		t.Fatal("Expected generic functions to reject unions")
+	}
+	if _, err := session.Check(parseHelper(t, "let a = id(3)").Statements); err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+
+	expression := &parser.CallExpr{Callee: &parser.IdentExpr{Name: "id"}, Arguments: []parser.Expression{&parser.BoolExpr{Value: true}}}
+	tipe, err := session.CheckExpression(expression)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+	if tipe.Render() != "bool" || session.Info.TypeOf(expression).Render() != "bool" {
+		t.Fatalf("Expected id(true) to have type bool, got %s", tipe.Render())
+	}
+	if _, err := session.CheckExpression(&parser.IdentExpr{Name: "a"}); err == nil || err.Position != 0 {
+		t.Fatalf("Expected a to be unbound at position 0, got %v", err)
+	}
+
+	// The types a failed piece inferred for earlier bindings are undone
+	session, err = session.Check(parseHelper(t, "var xs = list[]").Statements)
+	if err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+	rejected := parseHelper(t, "let y: bool = push(xs, true)")
+	if _, err := session.Check(rejected.Statements); err == nil {
+		t.Fatal("Expected push to return an int")
+	}
+	if _, ok := session.Info.Defs[rejected.Statements[0]]; ok {
+		t.Fatal("Expected a failed piece to add nothing to the info")
+	}
+	if _, err := session.Check(parseHelper(t, "let n = push(xs, 1)").Statements); err != nil {
+		t.Fatalf("Failed to check: %s", err.ToError("foo"))
+	}
+}
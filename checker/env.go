@@ -0,0 +1,188 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"monkey/parser"
+)
+
+type Env struct {
+	bindings []*Symbol
+	types    map[string]Type
+	scope    *Scope
+
+	// The union each variant name constructs
+	variants map[string]*TUnion
+
+	// Whether break and continue are allowed
+	inLoop bool
+}
+
+func NewEnv() *Env {
+	return &Env{
+		bindings: []*Symbol{},
+		types: map[string]Type{
+			"int":  T_INT,
+			"bool": T_BOOL,
+		},
+		scope:    &Scope{},
+		variants: map[string]*TUnion{},
+	}
+}
+
+// Declares a new symbol in the current scope
+func (env *Env) addBinding(s string, scheme Scheme) (*Env, *Symbol) {
+	symbol := env.scope.declare(s, scheme)
+
+	bindings := make([]*Symbol, len(env.bindings), len(env.bindings)+1)
+	copy(bindings, env.bindings)
+
+	return &Env{
+		bindings: append(bindings, symbol),
+		types:    env.types,
+		scope:    env.scope,
+		variants: env.variants,
+		inLoop:   env.inLoop,
+	}, symbol
+}
+
+// Returns a copy of the environment in which name refers to the type, the
+// variants of a union can then be constructed by name
+func (env *Env) addType(name string, tipe Type) *Env {
+	types := make(map[string]Type, len(env.types)+1)
+	for k, v := range env.types {
+		types[k] = v
+	}
+	types[name] = tipe
+
+	variants := env.variants
+	if union, ok := tipe.(*TUnion); ok {
+		variants = make(map[string]*TUnion, len(env.variants)+len(union.Variants))
+		for k, v := range env.variants {
+			variants[k] = v
+		}
+		for _, variant := range union.Variants {
+			variants[variant.Name] = union
+		}
+	}
+
+	return &Env{
+		bindings: env.bindings,
+		types:    types,
+		scope:    env.scope,
+		variants: variants,
+		inLoop:   env.inLoop,
+	}
+}
+
+// Returns an environment with a new scope nested in the current one, the
+// bindings of the current scope are still visible
+func (env *Env) enter() *Env {
+	return &Env{
+		bindings: env.bindings,
+		types:    env.types,
+		scope:    &Scope{Parent: env.scope},
+		variants: env.variants,
+		inLoop:   env.inLoop,
+	}
+}
+
+// Returns an environment with the same types, but none of the bindings
+func (env *Env) isolated() *Env {
+	return &Env{
+		bindings: []*Symbol{},
+		types:    env.types,
+		scope:    &Scope{},
+		variants: env.variants,
+	}
+}
+
+func (env *Env) lookup(s string) (*Symbol, error) {
+	for i := len(env.bindings) - 1; i >= 0; i-- {
+		if s == env.bindings[i].Name {
+			return env.bindings[i], nil
+		}
+	}
+	return nil, errors.New(fmt.Sprint("unbound variable ", s))
+}
+
+// Converts a type written in the source code to a type
+func (env *Env) lookupType(tipe parser.TypeExpression) (Type, error) {
+	switch tipe := tipe.(type) {
+	case *parser.LiteralType:
+		if res, ok := env.types[tipe.Name]; ok {
+			return res, nil
+		}
+	case *parser.ArrowType:
+		params := make([]Type, 0, len(tipe.Parameters))
+		for _, param := range tipe.Parameters {
+			t_param, err := env.lookupType(param)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, t_param)
+		}
+		returns, err := env.lookupType(tipe.Returns)
+		if err != nil {
+			return nil, err
+		}
+		return &TArrow{Params: params, Returns: returns}, nil
+	case *parser.ArrayType:
+		elem, err := env.lookupType(tipe.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return &TArray{Elem: elem, Length: tipe.Length}, nil
+	case *parser.ListType:
+		elem, err := env.lookupType(tipe.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return T_LIST(elem), nil
+	case *parser.OptionType:
+		value, err := env.lookupType(tipe.Value)
+		if err != nil {
+			return nil, err
+		}
+		return T_OPTION(value), nil
+	case *parser.TupleType:
+		elems := make([]Type, 0, len(tipe.Elems))
+		for _, elem := range tipe.Elems {
+			t_elem, err := env.lookupType(elem)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, t_elem)
+		}
+		return &TTuple{Elems: elems}, nil
+	case *parser.StructType:
+		return nil, errors.New("struct types must be declared with a name")
+	case *parser.UnionType:
+		return nil, errors.New("union types must be declared with a name")
+	}
+	return nil, errors.New(fmt.Sprint("type not found: ", tipe.Render()))
+}
+
+// The type variables which are free in some binding, these can not be
+// generalised because they may still be constrained by that binding
+func (env *Env) freeVars() []*TVar {
+	var vars []*TVar
+	for _, symbol := range env.bindings {
+		bound := symbol.Scheme.Vars
+		for _, v := range freeVars(symbol.Scheme.Type, nil) {
+			if !containsVar(bound, v) {
+				vars = append(vars, v)
+			}
+		}
+	}
+	return vars
+}
+
+func containsVar(vars []*TVar, v *TVar) bool {
+	for _, other := range vars {
+		if other == v {
+			return true
+		}
+	}
+	return false
+}
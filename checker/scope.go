@@ -0,0 +1,23 @@
+package checker
+
+// A symbol is a name declared by the program, e.g. by a let binding or as a
+// function parameter. Every use of a name is resolved to its symbol.
+type Symbol struct {
+	Name    string
+	Scheme  Scheme
+	Scope   *Scope
+	Mutable bool
+}
+
+// A scope holds the symbols declared directly inside it. Scopes that can see
+// the symbols of an enclosing scope point to it with Parent.
+type Scope struct {
+	Parent  *Scope
+	Symbols []*Symbol
+}
+
+func (s *Scope) declare(name string, scheme Scheme) *Symbol {
+	symbol := &Symbol{Name: name, Scheme: scheme, Scope: s}
+	s.Symbols = append(s.Symbols, symbol)
+	return symbol
+}
@@ -0,0 +1,379 @@
+package checker
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// Types are produced by the checker for every expression
+	Type interface {
+		isType()
+		Render() string
+	}
+)
+
+// Type variables ---------------------
+// A type variable stands in for a type that is not known yet. Once it has
+// been unified with another type, Instance points to that type.
+type TVar struct {
+	Id       int
+	Instance Type
+}
+
+func (*TVar) isType() {}
+func (t *TVar) Render() string {
+	if t.Instance != nil {
+		return t.Instance.Render()
+	}
+	return fmt.Sprint("t", t.Id)
+}
+
+// Type constructors ------------------
+// Built-in types like int and bool, Args is only set for parameterised types
+type TCon struct {
+	Name string
+	Args []Type
+}
+
+func (*TCon) isType() {}
+func (t *TCon) Render() string {
+	if len(t.Args) == 0 {
+		return t.Name
+	}
+	var s = strings.Builder{}
+	s.WriteString(t.Name)
+	s.WriteString("<")
+	for i, arg := range t.Args {
+		s.WriteString(arg.Render())
+		if i < len(t.Args)-1 {
+			s.WriteString(", ")
+		}
+	}
+	s.WriteString(">")
+	return s.String()
+}
+
+// Function types ---------------------
+type TArrow struct {
+	Params  []Type
+	Returns Type
+}
+
+func (*TArrow) isType() {}
+func (t *TArrow) Render() string {
+	var s = strings.Builder{}
+	s.WriteString("(")
+	for i, param := range t.Params {
+		s.WriteString(param.Render())
+		if i < len(t.Params)-1 {
+			s.WriteString(", ")
+		}
+	}
+	s.WriteString(") -> ")
+	s.WriteString(t.Returns.Render())
+	return s.String()
+}
+
+// Array types ------------------------
+type TArray struct {
+	Elem   Type
+	Length int
+}
+
+func (*TArray) isType() {}
+func (t *TArray) Render() string {
+	return fmt.Sprint("[", t.Elem.Render(), "; ", t.Length, "]")
+}
+
+// Tuple types ------------------------
+type TTuple struct {
+	Elems []Type
+}
+
+func (*TTuple) isType() {}
+func (t *TTuple) Render() string {
+	var s = strings.Builder{}
+	s.WriteString("(")
+	for i, elem := range t.Elems {
+		s.WriteString(elem.Render())
+		if i < len(t.Elems)-1 {
+			s.WriteString(", ")
+		}
+	}
+	s.WriteString(")")
+	return s.String()
+}
+
+// Struct types -----------------------
+// Structs are nominal, two struct types are only equal if they come from the
+// same declaration
+type TStruct struct {
+	Name   string
+	Fields []Field
+}
+
+type Field struct {
+	Name string
+	Type Type
+}
+
+func (*TStruct) isType() {}
+func (t *TStruct) Render() string {
+	return t.Name
+}
+
+// Returns the field with the given name, or nil if there is none
+func (t *TStruct) Field(name string) *Field {
+	for i := range t.Fields {
+		if t.Fields[i].Name == name {
+			return &t.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Union types ------------------------
+// Like structs, unions are nominal. Values carry the index of their variant
+// as a tag.
+type TUnion struct {
+	Name     string
+	Variants []Variant
+}
+
+type Variant struct {
+	Name    string
+	Payload []Type
+}
+
+func (*TUnion) isType() {}
+func (t *TUnion) Render() string {
+	return t.Name
+}
+
+// Returns the variant with the given name, or nil if there is none
+func (t *TUnion) Variant(name string) *Variant {
+	for i := range t.Variants {
+		if t.Variants[i].Name == name {
+			return &t.Variants[i]
+		}
+	}
+	return nil
+}
+
+var T_INT = &TCon{Name: "int"}
+var T_BOOL = &TCon{Name: "bool"}
+
+// Lists are growable and live on the heap, so the element type is only a parameter
+func T_LIST(elem Type) *TCon {
+	return &TCon{Name: "list", Args: []Type{elem}}
+}
+
+// An option is either none or some value. It behaves like a union that is
+// generic over the type of the value.
+func T_OPTION(value Type) *TCon {
+	return &TCon{Name: "option", Args: []Type{value}}
+}
+
+// Returns the variants of a union or an option in the order of their tags,
+// or nil for any other type
+func VariantsOf(t Type) []Variant {
+	switch t := Prune(t).(type) {
+	case *TUnion:
+		return t.Variants
+	case *TCon:
+		if t.Name == "option" {
+			return []Variant{{Name: "none"}, {Name: "some", Payload: []Type{t.Args[0]}}}
+		}
+	}
+	return nil
+}
+
+// A scheme is a type that is generic over Vars, e.g. forall t0. (t0) -> t0
+type Scheme struct {
+	Vars []*TVar
+	Type Type
+}
+
+// Follows instantiated type variables until reaching a concrete type or an
+// unbound type variable
+func Prune(t Type) Type {
+	if v, ok := t.(*TVar); ok && v.Instance != nil {
+		v.Instance = Prune(v.Instance)
+		return v.Instance
+	}
+	return t
+}
+
+// Returns a copy of the type with every bound type variable replaced by its
+// instance, so it no longer changes as unification proceeds
+func Resolve(t Type) Type {
+	switch t := Prune(t).(type) {
+	case *TCon:
+		if len(t.Args) == 0 {
+			return t
+		}
+		args := make([]Type, len(t.Args))
+		for i, arg := range t.Args {
+			args[i] = Resolve(arg)
+		}
+		return &TCon{Name: t.Name, Args: args}
+	case *TArrow:
+		params := make([]Type, len(t.Params))
+		for i, param := range t.Params {
+			params[i] = Resolve(param)
+		}
+		return &TArrow{Params: params, Returns: Resolve(t.Returns)}
+	case *TArray:
+		return &TArray{Elem: Resolve(t.Elem), Length: t.Length}
+	case *TTuple:
+		elems := make([]Type, len(t.Elems))
+		for i, elem := range t.Elems {
+			elems[i] = Resolve(elem)
+		}
+		return &TTuple{Elems: elems}
+	default:
+		return t
+	}
+}
+
+// Structural equality of two types
+func IsEqual(a, b Type) bool {
+	a, b = Prune(a), Prune(b)
+	switch a := a.(type) {
+	case *TVar:
+		return a == b
+	case *TCon:
+		b, ok := b.(*TCon)
+		if !ok || a.Name != b.Name || len(a.Args) != len(b.Args) {
+			return false
+		}
+		for i := range a.Args {
+			if !IsEqual(a.Args[i], b.Args[i]) {
+				return false
+			}
+		}
+		return true
+	case *TArrow:
+		b, ok := b.(*TArrow)
+		if !ok || len(a.Params) != len(b.Params) {
+			return false
+		}
+		for i := range a.Params {
+			if !IsEqual(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return IsEqual(a.Returns, b.Returns)
+	case *TArray:
+		b, ok := b.(*TArray)
+		return ok && a.Length == b.Length && IsEqual(a.Elem, b.Elem)
+	case *TTuple:
+		b, ok := b.(*TTuple)
+		if !ok || len(a.Elems) != len(b.Elems) {
+			return false
+		}
+		for i := range a.Elems {
+			if !IsEqual(a.Elems[i], b.Elems[i]) {
+				return false
+			}
+		}
+		return true
+	case *TStruct, *TUnion:
+		return a == b
+	}
+	return false
+}
+
+func occursIn(v *TVar, t Type) bool {
+	switch t := Prune(t).(type) {
+	case *TVar:
+		return v == t
+	case *TCon:
+		for _, arg := range t.Args {
+			if occursIn(v, arg) {
+				return true
+			}
+		}
+	case *TArrow:
+		for _, param := range t.Params {
+			if occursIn(v, param) {
+				return true
+			}
+		}
+		return occursIn(v, t.Returns)
+	case *TArray:
+		return occursIn(v, t.Elem)
+	case *TTuple:
+		for _, elem := range t.Elems {
+			if occursIn(v, elem) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Collects the unbound type variables of a type, in order of appearance
+func freeVars(t Type, into []*TVar) []*TVar {
+	switch t := Prune(t).(type) {
+	case *TVar:
+		for _, v := range into {
+			if v == t {
+				return into
+			}
+		}
+		return append(into, t)
+	case *TCon:
+		for _, arg := range t.Args {
+			into = freeVars(arg, into)
+		}
+	case *TArrow:
+		for _, param := range t.Params {
+			into = freeVars(param, into)
+		}
+		into = freeVars(t.Returns, into)
+	case *TArray:
+		into = freeVars(t.Elem, into)
+	case *TTuple:
+		for _, elem := range t.Elems {
+			into = freeVars(elem, into)
+		}
+	}
+	return into
+}
+
+// Replaces type variables according to the mapping
+func substitute(t Type, mapping map[*TVar]Type) Type {
+	switch t := Prune(t).(type) {
+	case *TVar:
+		if replacement, ok := mapping[t]; ok {
+			return replacement
+		}
+		return t
+	case *TCon:
+		if len(t.Args) == 0 {
+			return t
+		}
+		args := make([]Type, len(t.Args))
+		for i, arg := range t.Args {
+			args[i] = substitute(arg, mapping)
+		}
+		return &TCon{Name: t.Name, Args: args}
+	case *TArrow:
+		params := make([]Type, len(t.Params))
+		for i, param := range t.Params {
+			params[i] = substitute(param, mapping)
+		}
+		return &TArrow{Params: params, Returns: substitute(t.Returns, mapping)}
+	case *TArray:
+		return &TArray{Elem: substitute(t.Elem, mapping), Length: t.Length}
+	case *TTuple:
+		elems := make([]Type, len(t.Elems))
+		for i, elem := range t.Elems {
+			elems[i] = substitute(elem, mapping)
+		}
+		return &TTuple{Elems: elems}
+	}
+	return t
+}
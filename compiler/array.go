@@ -0,0 +1,204 @@
+package compiler
+
+import (
+	"errors"
+	"monkey/parser"
+)
+
+// The elements are pushed from last to first, so that the first element ends
+// up at the lowest address
+func compileArrayExpression(expression *parser.ArrayExpr, env *Env) ([]Instruction, Tipe, error) {
+	tipe := env.tipeOf(expression)
+	output := []Instruction{}
+	tmpEnv := env
+
+	for i := len(expression.Elements) - 1; i >= 0; i-- {
+		element, elemTipe, err := compilePushed(expression.Elements[i], tmpEnv)
+		if err != nil {
+			return []Instruction{}, T_NEVER(0), err
+		}
+		output = append(output, element...)
+		tmpEnv = tmpEnv.addTemp(elemTipe)
+	}
+
+	if tipe.InRegister() {
+		output = append(output, POP(RAX))
+	}
+	return output, tipe, nil
+}
+
+func compileIndexExpression(expression *parser.IndexExpr, env *Env) ([]Instruction, Tipe, error) {
+	if env.tipeOf(expression.Array).Name == "list" {
+		return compileListIndexExpression(expression, env)
+	}
+	if ident, ok := expression.Array.(*parser.IdentExpr); ok {
+		return compileIndexBinding(expression, ident, env)
+	}
+
+	// Any other array is evaluated onto the stack first
+	output, arrayTipe, err := compilePushed(expression.Array, env)
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+	tmpEnv := env.addTemp(arrayTipe)
+
+	index, err := compileIndex(expression.Index, arrayTipe, tmpEnv)
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+	output = append(output, index...)
+
+	elem := *arrayTipe.Elem
+	if elem.InRegister() {
+		output = append(output, MOV(RAX, Indexed(RSP, RAX, 0)))
+		return append(output, pop(arrayTipe.Size)...), elem, nil
+	}
+
+	// Move the element down to where the array ends, then pop the rest
+	output = append(output, LEA(RCX, Indexed(RSP, RAX, 0)))
+	output = append(output, copyWords(elem.Size, Mem(RCX, 0), Mem(RSP, arrayTipe.Size-elem.Size))...)
+	return append(output, pop(arrayTipe.Size-elem.Size)...), elem, nil
+}
+
+// Reads an element straight out of the stack slot of a binding, without
+// copying the whole array
+func compileIndexBinding(expression *parser.IndexExpr, ident *parser.IdentExpr, env *Env) ([]Instruction, Tipe, error) {
+	address, err := env.lexicalAddress(env.info.Uses[ident])
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+	arrayTipe := env.tipeOf(ident)
+
+	output, err := compileIndex(expression.Index, arrayTipe, env)
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+
+	elem := *arrayTipe.Elem
+	if elem.InRegister() {
+		return append(output, MOV(RAX, Indexed(RSP, RAX, address))), elem, nil
+	}
+
+	output = append(output, LEA(RCX, Indexed(RSP, RAX, address)))
+	for offset := elem.Size - 8; offset >= 0; offset -= 8 {
+		output = append(output, PUSH(Mem(RCX, offset).Qword()))
+	}
+	return output, elem, nil
+}
+
+// Evaluates an index into rax and checks it is in bounds, then scales it to
+// the byte offset of the element
+func compileIndex(index parser.Expression, arrayTipe Tipe, env *Env) ([]Instruction, error) {
+	output, _, err := compileExpression(index, env)
+	if err != nil {
+		return []Instruction{}, err
+	}
+	output = append(output, env.runtime.boundsCheck(Immediate(arrayTipe.Length))...)
+	return append(output, IMUL(RAX, RAX, Immediate(arrayTipe.Elem.Size))), nil
+}
+
+/*
+Writes to an element of an array or a field of a struct binding, which may be
+nested like a[i].x[j]. The byte offset of the element is accumulated on the
+stack above the value:
+
+	[rsp]                 offset of the element
+	[rsp+8]               the value
+
+The elements of a list are on the heap, so if the path goes through a list
+the offset is into its buffer, and the list is kept between the two:
+
+	[rsp]                 offset of the element in the buffer
+	[rsp+8]               the list
+	[rsp+16]              the value
+*/
+func compileElementAssignment(target parser.Expression, value parser.Expression, env *Env) ([]Instruction, error) {
+	// Find the binding or list at the root and the element at each level
+	var path []parser.Expression
+	var root parser.Expression = target
+	var list *parser.IndexExpr
+	for list == nil {
+		if index, ok := root.(*parser.IndexExpr); ok {
+			path = append([]parser.Expression{index}, path...)
+			root = index.Array
+			if env.tipeOf(index.Array).Name == "list" {
+				list = index
+			}
+		} else if field, ok := root.(*parser.FieldExpr); ok {
+			path = append([]parser.Expression{field}, path...)
+			root = field.Struct
+		} else {
+			break
+		}
+	}
+
+	output, tipe, err := compilePushed(value, env)
+	if err != nil {
+		return []Instruction{}, err
+	}
+	valueEnv := env.addTemp(tipe)
+
+	var offsetEnv *Env
+	address := 0
+	if list != nil {
+		compiled, listTipe, err := compileExpression(list.Array, valueEnv)
+		if err != nil {
+			return []Instruction{}, err
+		}
+		output = append(output, compiled...)
+		output = append(output, PUSH(RAX))
+		listEnv := valueEnv.addTemp(listTipe)
+
+		compiled, err = compileListIndex(list.Index, listTipe, listEnv)
+		if err != nil {
+			return []Instruction{}, err
+		}
+		output = append(output, compiled...)
+		output = append(output, PUSH(RAX))
+		offsetEnv = listEnv.addNever(8)
+		path = path[1:]
+	} else {
+		ident, ok := root.(*parser.IdentExpr)
+		if !ok {
+			return []Instruction{}, errors.New("unexpected assignment target")
+		}
+		address, err = env.lexicalAddress(env.info.Uses[ident])
+		if err != nil {
+			return []Instruction{}, err
+		}
+		output = append(output, PUSH(Immediate(0)))
+		offsetEnv = valueEnv.addNever(8)
+	}
+
+	for _, element := range path {
+		switch element := element.(type) {
+		case *parser.IndexExpr:
+			compiled, err := compileIndex(element.Index, env.tipeOf(element.Array), offsetEnv)
+			if err != nil {
+				return []Instruction{}, err
+			}
+			output = append(output, compiled...)
+			output = append(output, ADD(Mem(RSP, 0), RAX))
+		case *parser.FieldExpr:
+			field := env.tipeOf(element.Struct).field(element.Field)
+			if field.Offset != 0 {
+				output = append(output, ADD(Mem(RSP, 0).Qword(), Immediate(field.Offset)))
+			}
+		}
+	}
+
+	if list != nil {
+		output = append(output, []Instruction{
+			POP(RCX),
+			POP(RDX),
+			ADD(RCX, Mem(RDX, 16)),
+		}...)
+	} else {
+		output = append(output, []Instruction{
+			POP(RCX),
+			LEA(RCX, Indexed(RSP, RCX, tipe.Size+address)),
+		}...)
+	}
+	output = append(output, copyWords(tipe.Size, Mem(RSP, 0), Mem(RCX, 0))...)
+	return append(output, pop(tipe.Size)...), nil
+}
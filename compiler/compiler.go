@@ -3,6 +3,8 @@ package compiler
 import (
 	"errors"
 	"fmt"
+	"monkey/checker"
+	"monkey/ir"
 	"monkey/parser"
 )
 
@@ -15,7 +17,24 @@ func (e *CompilerError) ToError(source string) error {
 	return errors.New(fmt.Sprint("[compiler err]: ", e.Error, "\nCulprit:\n>>> ", source))
 }
 
-func Compile(program parser.Program) ([]Instruction, *CompilerError) {
+type Options struct {
+	// Write the garbage collector's counters to stderr when the program exits
+	GCStats bool
+
+	// How much to optimise the program:
+	//
+	//	0    compile straight from the AST
+	//	1    compile through the IR, with register allocation
+	//	2    also fold constants and eliminate dead code in the IR
+	//
+	// Programs that can't be lowered to the IR are compiled from the AST. From
+	// level 1 the output of Compile is also meant to go through Peephole.
+	Optimise int
+}
+
+// Lowers a program to instructions. The program must already have been
+// checked, info is the result of checker.Check for that program.
+func Compile(program parser.Program, info *checker.Info, options Options) ([]Instruction, *CompilerError) {
 
 	var prelude = []Instruction{
 		SECTION(".text"),
@@ -24,13 +43,29 @@ func Compile(program parser.Program) ([]Instruction, *CompilerError) {
 	}
 
 	var epilogue = []Instruction{
-		MOV("rdi", "rax"),       // exit code - for now make it whatever was in rax
-		MOV("rax", "0x2000001"), // exit syscall
+		MOV(RDI, RAX),                  // exit code, which the program leaves in rax
+		MOV(RAX, Immediate(0x2000001)), // exit syscall
 		SYSCALL(),
 	}
 
-	compiledStatements, err := compileProgram(program.Statements)
-	output := append(append(prelude, compiledStatements...), epilogue...)
+	labelCounter = 0
+	var compiledStatements, functions []Instruction
+	var runtime = &Runtime{}
+	var err *CompilerError
+	lowered, lowerErr := lowerProgram(program, info, options)
+	if lowerErr == nil {
+		compiledStatements, functions = selectProgram(lowered)
+	} else {
+		compiledStatements, runtime, err = compileProgram(program, info)
+	}
+	output := append(prelude, compiledStatements...)
+	if options.GCStats {
+		runtime.gcStats = true
+		output = append(output, PUSH(RAX), CALL(Label(GC_STATS)), POP(RAX))
+	}
+	output = append(output, epilogue...)
+	output = append(output, functions...)
+	output = append(output, runtime.Instructions()...)
 
 	if err != nil {
 		return []Instruction{}, err
@@ -40,137 +75,412 @@ func Compile(program parser.Program) ([]Instruction, *CompilerError) {
 
 }
 
-func compileProgram(statements []parser.Statement) ([]Instruction, *CompilerError) {
+// Returns an error if the program is not to be compiled through the IR
+func lowerProgram(program parser.Program, info *checker.Info, options Options) (*ir.Program, error) {
+	if options.Optimise < 1 {
+		return nil, errors.New("the IR is only used when optimising")
+	}
+	lowered, err := ir.Lower(program, info)
+	if err != nil {
+		return nil, err
+	}
+	if options.Optimise >= 2 {
+		ir.Optimise(lowered)
+	}
+	return lowered, nil
+}
+
+// Also returns the runtime routines used by the program
+func compileProgram(program parser.Program, info *checker.Info) ([]Instruction, *Runtime, *CompilerError) {
 
-	var env = NewEnv()
+	var env = NewEnv(info)
 	var output []Instruction
 
-	for _, statement := range statements {
+	for _, statement := range program.Statements {
 		var res []Instruction
 		var err error
 
 		res, env, err = compileStatement(statement, env)
 		if err != nil {
-			return []Instruction{}, &CompilerError{err, statement.Position()}
+			return []Instruction{}, env.runtime, &CompilerError{err, statement.Position()}
 		}
 		output = append(output, res...)
 	}
-	return output, nil
+
+	// The program exits with the binding chosen by info.ExitBinding, or 0
+	symbol := info.ExitBinding(program)
+	if symbol == nil {
+		return append(output, MOV(RAX, Immediate(0))), env.runtime, nil
+	}
+	address, err := env.lexicalAddress(symbol)
+	if err != nil {
+		return []Instruction{}, env.runtime, &CompilerError{err, 0}
+	}
+	return append(output, MOV(RAX, Mem(RSP, address))), env.runtime, nil
 }
 
 func compileStatement(statement parser.Statement, env *Env) ([]Instruction, *Env, error) {
 	switch statement := statement.(type) {
 	case *parser.AssignStmt:
 		return compileAssignStmt(statement, env)
+	case *parser.DestructureStmt:
+		return compileDestructureStmt(statement, env)
+	case *parser.ReassignStmt:
+		return compileReassignStmt(statement, env)
+	case *parser.TypeDeclStmt:
+		// Types only exist at compile time
+		return []Instruction{}, env, nil
+	case *parser.WhileStmt:
+		return compileWhileStmt(statement, env)
+	case *parser.ForStmt:
+		return compileForStmt(statement, env)
+	case *parser.BreakStmt:
+		return compileLoopControl(env, func(loop *Loop) string { return loop.Break })
+	case *parser.ContinueStmt:
+		return compileLoopControl(env, func(loop *Loop) string { return loop.Continue })
 	}
 	return []Instruction{}, env, errors.New("unexpected statement type")
 }
 
 func compileAssignStmt(statement *parser.AssignStmt, env *Env) ([]Instruction, *Env, error) {
-	compiledExpression, exprTipe, err := compileExpression(statement.Rhs, env)
+	output, tipe, err := compilePushed(statement.Rhs, env)
+	if err != nil {
+		return []Instruction{}, env, err
+	}
+
+	env = env.addBinding(env.info.Defs[statement], tipe)
+
+	return output, env, nil
+}
+
+// The tuple is pushed and its elements become bindings in place, the last
+// element is at the highest address so it is bound first
+func compileDestructureStmt(statement *parser.DestructureStmt, env *Env) ([]Instruction, *Env, error) {
+	output, tipe, err := compilePushed(statement.Rhs, env)
+	if err != nil {
+		return []Instruction{}, env, err
+	}
+
+	for i := len(tipe.Fields) - 1; i >= 0; i-- {
+		env = env.addBinding(env.info.Defs[&statement.Lhs[i]], tipe.Fields[i].Tipe)
+	}
+
+	return output, env, nil
+}
+
+// Stores the new value back into the stack slot of the binding
+func compileReassignStmt(statement *parser.ReassignStmt, env *Env) ([]Instruction, *Env, error) {
+	switch lhs := statement.Lhs.(type) {
+	case *parser.IdentExpr:
+		address, err := env.lexicalAddress(env.info.Uses[lhs])
+		if err != nil {
+			return []Instruction{}, env, err
+		}
+
+		output, tipe, err := compileExpression(statement.Rhs, env)
+		if err != nil {
+			return []Instruction{}, env, err
+		}
+
+		if tipe.InRegister() {
+			return append(output, MOV(Mem(RSP, address), RAX)), env, nil
+		}
+
+		// The value is on the top of the stack, above the slot
+		output = append(output, copyWords(tipe.Size, Mem(RSP, 0), Mem(RSP, tipe.Size+address))...)
+		return append(output, pop(tipe.Size)...), env, nil
+	case *parser.IndexExpr, *parser.FieldExpr:
+		output, err := compileElementAssignment(lhs, statement.Rhs, env)
+		return output, env, err
+	}
+	return []Instruction{}, env, errors.New("unexpected assignment target")
+}
+
+func compileWhileStmt(statement *parser.WhileStmt, env *Env) ([]Instruction, *Env, error) {
+	start := genLabel()
+	end := genLabel()
+
+	cond, _, err := compileExpression(statement.Cond, env)
 	if err != nil {
 		return []Instruction{}, env, err
 	}
-	assignmentTipe, ok := env.lookupTipe(statement.Tipe)
 
-	if !ok {
-		err := fmt.Sprint("type not found: ", statement.Tipe.Render())
-		return []Instruction{}, env, errors.New(err)
+	output := append([]Instruction{LABEL(start)}, cond...)
+	output = append(output, []Instruction{
+		CMP(RAX, Immediate(0)),
+		JE(end),
+	}...)
+
+	body, err := compileStatements(statement.Body, env.enterLoop(start, end))
+	if err != nil {
+		return []Instruction{}, env, err
 	}
+	output = append(output, body...)
+	output = append(output, []Instruction{
+		JMP(start),
+		LABEL(end),
+	}...)
 
-	if exprTipe != assignmentTipe {
-		err := fmt.Sprint("cannot cannot assign type: ", exprTipe.Name, " to ", statement.Tipe.Render())
-		return []Instruction{}, env, errors.New(err)
+	return output, env, nil
+}
+
+/*
+The loop variable and the upper bound live on the stack for the duration of
+the loop:
+
+	[rsp]                 upper bound
+	[rsp+8]               loop variable
+*/
+func compileForStmt(statement *parser.ForStmt, env *Env) ([]Instruction, *Env, error) {
+	if statement.To == nil {
+		return compileListForStmt(statement, env)
 	}
-	output := append(compiledExpression, PUSH("rax"))
-	env, err = env.addBinding(statement.Lhs, statement.Tipe)
 
+	start := genLabel()
+	next := genLabel()
+	end := genLabel()
+
+	from, tipe, err := compileExpression(statement.From, env)
 	if err != nil {
 		return []Instruction{}, env, err
 	}
+	output := append(from, PUSH(RAX))
+	loopEnv := env.addBinding(env.info.Defs[statement], tipe)
+
+	to, tipe, err := compileExpression(statement.To, loopEnv)
+	if err != nil {
+		return []Instruction{}, env, err
+	}
+	output = append(output, to...)
+	output = append(output, PUSH(RAX))
+	loopEnv = loopEnv.addTemp(tipe)
+
+	exit := JGE(end)
+	if statement.Inclusive {
+		exit = JG(end)
+	}
+	output = append(output, []Instruction{
+		LABEL(start),
+		MOV(RAX, Mem(RSP, 8)),
+		CMP(RAX, Mem(RSP, 0)),
+		exit,
+	}...)
+
+	body, err := compileStatements(statement.Body, loopEnv.enterLoop(next, end))
+	if err != nil {
+		return []Instruction{}, env, err
+	}
+	output = append(output, body...)
+	output = append(output, []Instruction{
+		LABEL(next),
+		ADD(Mem(RSP, 8).Qword(), Immediate(1)),
+		JMP(start),
+		LABEL(end),
+		ADD(RSP, Immediate(loopEnv.size()-env.size())),
+	}...)
 
 	return output, env, nil
 }
 
+// Pops the locals of the current iteration, then jumps to the start or end of the loop
+func compileLoopControl(env *Env, target func(*Loop) string) ([]Instruction, *Env, error) {
+	if env.loop == nil {
+		return []Instruction{}, env, errors.New("break and continue can only be used inside a loop")
+	}
+
+	output := []Instruction{}
+	if locals := env.size() - env.loop.Size; locals > 0 {
+		output = append(output, ADD(RSP, Immediate(locals)))
+	}
+	return append(output, JMP(target(env.loop))), env, nil
+}
+
+// Compiles a sequence of statements, the locals they bind are popped at the end
+func compileStatements(statements []parser.Statement, env *Env) ([]Instruction, error) {
+	tempEnv := env
+	output := []Instruction{}
+
+	for _, statement := range statements {
+		var instrs []Instruction
+		var err error
+		instrs, tempEnv, err = compileStatement(statement, tempEnv)
+		if err != nil {
+			return []Instruction{}, err
+		}
+		output = append(output, instrs...)
+	}
+
+	if locals := tempEnv.size() - env.size(); locals > 0 {
+		output = append(output, ADD(RSP, Immediate(locals)))
+	}
+	return output, nil
+}
+
 func compileExpression(expression parser.Expression, env *Env) ([]Instruction, Tipe, error) {
+	// Constructors look like identifiers or calls
+	if _, ok := env.info.Variants[expression]; ok {
+		return compileConstructor(expression, env)
+	}
+
 	switch expression := expression.(type) {
 	case *parser.IntExpr:
 		return compileIntegerExpression(*expression)
 	case *parser.IdentExpr:
-		return compileIdentExpression(*expression, env)
+		return compileIdentExpression(expression, env)
 	case *parser.AddExpr:
-		return compileAddExpression(*expression, env)
+		return compileArithmeticExpression(expression.Lhs, expression.Rhs, ADD, env)
 	case *parser.SubExpr:
-		return compileSubExpression(*expression, env)
+		// the right operand is evaluated first, so the left one ends up in rax
+		return compileArithmeticExpression(expression.Rhs, expression.Lhs, SUB, env)
 	case *parser.BoolExpr:
-		return compileBoolExpression(*expression, env)
+		return compileBoolExpression(*expression)
 	case *parser.LessThanExpr:
 		return compileComparisonExpression(expression.Lhs, expression.Rhs, JL, env)
 	case *parser.GreaterThanExpr:
 		return compileComparisonExpression(expression.Lhs, expression.Rhs, JG, env)
 	case *parser.BlockBodyExpr:
-		return compileBlockBodyExpression(*expression, env)
+		// The checker made sure the block can't refer to outer bindings, but
+		// they are still part of the stack frame
+		return compileBlockBodyExpression(expression, env)
+	case *parser.LambdaExpr:
+		return compileLambdaExpression(expression, env)
+	case *parser.CallExpr:
+		if _, ok := env.info.Builtins[expression]; ok {
+			return compileBuiltinCall(expression, env)
+		}
+		return compileCallExpression(expression, env)
+	case *parser.ArrayExpr:
+		return compileArrayExpression(expression, env)
+	case *parser.ListExpr:
+		return compileListExpression(expression, env)
+	case *parser.TupleExpr:
+		return compileTupleExpression(expression, env)
+	case *parser.IndexExpr:
+		return compileIndexExpression(expression, env)
+	case *parser.StructExpr:
+		return compileStructExpression(expression, env)
+	case *parser.FieldExpr:
+		return compileFieldExpression(expression, env)
+	case *parser.MatchExpr:
+		return compileMatchExpression(expression, env)
 	}
 
 	return []Instruction{}, T_NEVER(0), errors.New("unexpected expression type")
 }
 
+// Compiles an expression, leaving its value on the top of the stack
+func compilePushed(expression parser.Expression, env *Env) ([]Instruction, Tipe, error) {
+	output, tipe, err := compileExpression(expression, env)
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+	if tipe.InRegister() {
+		output = append(output, PUSH(RAX))
+	}
+	return output, tipe, nil
+}
+
+// Pops a number of bytes off the stack
+func pop(size int) []Instruction {
+	if size == 0 {
+		return []Instruction{}
+	}
+	return []Instruction{ADD(RSP, Immediate(size))}
+}
+
+/*
+Copies a value from one address to another through rax, starting from the
+highest word. The destination may overlap the source as long as it is at a
+higher address.
+*/
+func copyWords(size int, from Memory, to Memory) []Instruction {
+	output := []Instruction{}
+	for offset := size - 8; offset >= 0; offset -= 8 {
+		output = append(output, []Instruction{
+			MOV(RAX, from.Plus(offset)),
+			MOV(to.Plus(offset), RAX),
+		}...)
+	}
+	return output
+}
+
 func compileIntegerExpression(expression parser.IntExpr) ([]Instruction, Tipe, error) {
 	return []Instruction{
-		MOV("rax", fmt.Sprint(expression.Value)),
+		MOV(RAX, Immediate(expression.Value)),
 	}, T_INT, nil
 }
 
-func compileBoolExpression(expression parser.BoolExpr, env *Env) ([]Instruction, Tipe, error) {
+func compileBoolExpression(expression parser.BoolExpr) ([]Instruction, Tipe, error) {
 	val := 0
 	if expression.Value {
 		val = 1
 	}
 	return []Instruction{
-		MOV("rax", fmt.Sprint(val)),
+		MOV(RAX, Immediate(val)),
 	}, T_BOOL, nil
 }
 
-func compileComparisonExpression(lhs parser.Expression, rhs parser.Expression, jump func(string) Instruction, env *Env) ([]Instruction, Tipe, error) {
+/*
+Evaluates both operands of a binary operator. The left operand is left on the
+top of the stack and the right operand in rax.
+*/
+func compileOperands(lhs parser.Expression, rhs parser.Expression, env *Env) ([]Instruction, error) {
 	left, leftTipe, err := compileExpression(lhs, env)
 	if err != nil {
-		return []Instruction{}, T_NEVER(0), err
+		return []Instruction{}, err
 	}
-	output := append(left, PUSH("rax"))
-	tmpEnv := env.addNever(leftTipe.Size)
+	output := append(left, PUSH(RAX))
+	tmpEnv := env.addTemp(leftTipe)
 
-	right, rightTipe, err := compileExpression(rhs, tmpEnv)
+	right, _, err := compileExpression(rhs, tmpEnv)
 	if err != nil {
-		return []Instruction{}, T_NEVER(0), err
+		return []Instruction{}, err
 	}
+	return append(output, right...), nil
+}
 
-	if (leftTipe != T_INT) || (rightTipe != T_INT) {
-		err := fmt.Sprint("cannot cannot compare types: ", leftTipe.Name, " and ", rightTipe.Name)
-		return []Instruction{}, T_NEVER(0), errors.New(err)
+func compileComparisonExpression(lhs parser.Expression, rhs parser.Expression, jump func(string) Instruction, env *Env) ([]Instruction, Tipe, error) {
+	output, err := compileOperands(lhs, rhs, env)
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
 	}
 
-	output = append(output, right...)
-
 	ifTrue := genLabel()
 	done := genLabel()
 
 	output = append(output, []Instruction{
-		CMP("[rsp]", "rax"),
+		CMP(Mem(RSP, 0), RAX),
 		jump(ifTrue),
-		MOV("rax", "0"),
+		MOV(RAX, Immediate(0)),
 		JMP(done),
 		LABEL(ifTrue),
-		MOV("rax", "1"),
+		MOV(RAX, Immediate(1)),
 		LABEL(done),
-		ADD("rsp", "8"),
+		ADD(RSP, Immediate(8)),
 	}...)
 
 	return output, T_BOOL, nil
 }
 
-func compileBlockBodyExpression(expression parser.BlockBodyExpr, env *Env) ([]Instruction, Tipe, error) {
-	// the stuff inside the block can't peek out
-	tempEnv := NewEnv()
+// Computes `operation rax, [rsp]` where rax holds the second operand and [rsp] the first
+func compileArithmeticExpression(first parser.Expression, second parser.Expression, operation func(Operand, Operand) Instruction, env *Env) ([]Instruction, Tipe, error) {
+	output, err := compileOperands(first, second, env)
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+
+	output = append(output, []Instruction{
+		operation(RAX, Mem(RSP, 0)),
+		ADD(RSP, Immediate(8)),
+	}...)
+
+	return output, T_INT, nil
+}
+
+// Compiles the block in the given environment, the locals it binds are
+// popped off the stack once the final expression has been evaluated
+func compileBlockBodyExpression(expression *parser.BlockBodyExpr, env *Env) ([]Instruction, Tipe, error) {
+	tempEnv := env
 	output := []Instruction{}
 
 	for _, statement := range expression.Statements {
@@ -185,70 +495,129 @@ func compileBlockBodyExpression(expression parser.BlockBodyExpr, env *Env) ([]In
 
 	// the final expression in the block is the return value
 	final, tipe, err := compileExpression(expression.Final, tempEnv)
-	output = append(output, final...)
-	return output, tipe, err
-}
-
-func compileIdentExpression(expression parser.IdentExpr, env *Env) ([]Instruction, Tipe, error) {
-	address, tipe, err := env.lexicalAddress(expression.Name)
 	if err != nil {
 		return []Instruction{}, T_NEVER(0), err
 	}
-	return []Instruction{
-		MOV("rax", fmt.Sprintf("[rsp+%d]", address)),
-	}, tipe, nil
+	output = append(output, final...)
+
+	if locals := tempEnv.size() - env.size(); locals > 0 {
+		// A value on the stack is moved down over the locals
+		if !tipe.InRegister() {
+			output = append(output, copyWords(tipe.Size, Mem(RSP, 0), Mem(RSP, locals))...)
+		}
+		output = append(output, ADD(RSP, Immediate(locals)))
+	}
+	return output, tipe, nil
 }
 
-func compileAddExpression(expression parser.AddExpr, env *Env) ([]Instruction, Tipe, error) {
-	left, leftTipe, err := compileExpression(expression.Lhs, env)
-	if err != nil {
-		return []Instruction{}, T_NEVER(0), err
+/*
+Functions are compiled in place, with a jump over their body. The value of a
+lambda is the address of its body. Arguments are pushed by the caller in order,
+so the stack looks like this on entry:
+
+	[rsp]                 return address
+	[rsp+8]               last argument
+	[rsp+8*n]             first argument
+
+Return values that don't fit in rax are written to space the caller reserved
+below the arguments.
+*/
+func compileLambdaExpression(expression *parser.LambdaExpr, env *Env) ([]Instruction, Tipe, error) {
+	tipe := env.tipeOf(expression)
+	body := genLabel()
+	after := genLabel()
+
+	// Lambdas can only see their own parameters
+	bodyEnv := env.isolated()
+	for i := range expression.Parameters {
+		bodyEnv = bodyEnv.addBinding(env.info.Defs[&expression.Parameters[i]], tipe.Params[i])
 	}
-	output := append(left, PUSH("rax"))
-	tmpEnv := env.addNever(leftTipe.Size)
+	bodyEnv = bodyEnv.addReturnAddress()
 
-	right, rightTipe, err := compileExpression(expression.Rhs, tmpEnv)
+	compiledBody, _, err := compileBlockBodyExpression(&expression.Body, bodyEnv)
 	if err != nil {
 		return []Instruction{}, T_NEVER(0), err
 	}
 
-	if (leftTipe != T_INT) || (rightTipe != T_INT) {
-		err := fmt.Sprint("cannot cannot add types: ", leftTipe.Name, " and ", rightTipe.Name)
-		return []Instruction{}, T_NEVER(0), errors.New(err)
+	if returns := *tipe.Returns; !returns.InRegister() {
+		// Skip over the value itself, the return address and the arguments
+		reserved := Mem(RSP, returns.Size+bodyEnv.size())
+		compiledBody = append(compiledBody, copyWords(returns.Size, Mem(RSP, 0), reserved)...)
+		compiledBody = append(compiledBody, pop(returns.Size)...)
 	}
 
-	output = append(output, right...)
+	output := []Instruction{
+		JMP(after),
+		LABEL(body),
+	}
+	output = append(output, compiledBody...)
 	output = append(output, []Instruction{
-		ADD("rax", "[rsp]"),
-		ADD("rsp", "8"),
+		RET(),
+		LABEL(after),
+		LEA(RAX, Rel(body)),
 	}...)
 
-	return output, leftTipe, nil
+	return output, tipe, nil
 }
 
-func compileSubExpression(expression parser.SubExpr, env *Env) ([]Instruction, Tipe, error) {
-	left, leftTipe, err := compileExpression(expression.Rhs, env)
+func compileCallExpression(expression *parser.CallExpr, env *Env) ([]Instruction, Tipe, error) {
+	output := []Instruction{}
+	tipe := env.tipeOf(expression)
+
+	// Reserve space for a return value that doesn't fit in rax. The garbage
+	// collector may look at it before the callee has written to it, so any
+	// pointers in it start out as 0.
+	if !tipe.InRegister() && tipe.hasPointers() {
+		for i := 0; i < tipe.Size; i += 8 {
+			output = append(output, PUSH(Immediate(0)))
+		}
+		env = env.addTemp(tipe)
+	} else if !tipe.InRegister() {
+		output = append(output, SUB(RSP, Immediate(tipe.Size)))
+		env = env.addNever(tipe.Size)
+	}
+	tmpEnv := env
+
+	for _, argument := range expression.Arguments {
+		compiled, argTipe, err := compilePushed(argument, tmpEnv)
+		if err != nil {
+			return []Instruction{}, T_NEVER(0), err
+		}
+		output = append(output, compiled...)
+		tmpEnv = tmpEnv.addTemp(argTipe)
+	}
+
+	callee, _, err := compileExpression(expression.Callee, tmpEnv)
 	if err != nil {
 		return []Instruction{}, T_NEVER(0), err
 	}
-	output := append(left, PUSH("rax"))
-	tmpEnv := env.addNever(leftTipe.Size)
+	output = append(output, callee...)
+	output = append(output, CALL(RAX))
+	output = append(output, env.runtime.safepoint(tmpEnv)...)
+	output = append(output, pop(tmpEnv.size()-env.size())...)
 
-	right, rightTipe, err := compileExpression(expression.Lhs, tmpEnv)
+	return output, tipe, nil
+}
+
+func compileIdentExpression(expression *parser.IdentExpr, env *Env) ([]Instruction, Tipe, error) {
+	address, err := env.lexicalAddress(env.info.Uses[expression])
 	if err != nil {
 		return []Instruction{}, T_NEVER(0), err
 	}
 
-	if (leftTipe != T_INT) || (rightTipe != T_INT) {
-		err := fmt.Sprint("cannot cannot subtract types: ", leftTipe.Name, " and ", rightTipe.Name)
-		return []Instruction{}, T_NEVER(0), errors.New(err)
+	// Generic bindings take the type they were instantiated with
+	tipe := env.tipeOf(expression)
+	if tipe.InRegister() {
+		return []Instruction{
+			MOV(RAX, Mem(RSP, address)),
+		}, tipe, nil
 	}
 
-	output = append(output, right...)
-	output = append(output, []Instruction{
-		SUB("rax", "[rsp]"),
-		ADD("rsp", "8"),
-	}...)
-
-	return output, leftTipe, nil
+	// Each push moves the stack pointer down a word, so the address of the
+	// next lower word stays the same
+	output := []Instruction{}
+	for i := 0; i < tipe.Size; i += 8 {
+		output = append(output, PUSH(Mem(RSP, address+tipe.Size-8).Qword()))
+	}
+	return output, tipe, nil
 }
@@ -1,9 +1,17 @@
 package compiler
 
 import (
+	"flag"
 	"fmt"
+	"io/fs"
+	"monkey/checker"
+	"monkey/ir"
 	"monkey/lexer"
 	"monkey/parser"
+	"monkey/toolchain"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -16,13 +24,843 @@ func parseHelper(t *testing.T, s string) parser.Program {
 	return *program
 }
 
-func TestCompile(t *testing.T) {
-	program := parseHelper(t, "let x: int = 3 let y: int = x")
+func compileHelper(t *testing.T, s string) []Instruction {
+	return compileWithOptions(t, s, Options{})
+}
 
-	var compiled, err = Compile(program)
+func compileWithOptions(t *testing.T, s string, options Options) []Instruction {
+	program := parseHelper(t, s)
+	info, checkErr := checker.Check(program)
+	if checkErr != nil {
+		t.Fatalf("Failed to check: %s", checkErr.ToError("foo"))
+	}
+	compiled, err := Compile(program, info, options)
 	if err != nil {
 		t.Fatalf("Failed to compile: %s", err.ToError("foo"))
 	}
-	fmt.Println(Render(compiled))
+	return compiled
+}
+
+var update = flag.Bool("update", false, "rewrite the expected assembly of the golden tests")
+
+// The options each golden program is compiled with, and the extension of the
+// file with the expected assembly for them. When optimising, the output also
+// goes through Peephole, the same as with main.
+var goldenOptions = []struct {
+	extension string
+	options   Options
+}{
+	{".s", Options{}},
+	{".O1.s", Options{Optimise: 1}},
+	{".O2.s", Options{Optimise: 2}},
+}
+
+/*
+Compiles each program in testdata with each of goldenOptions and compares the
+assembly to the file next to it. If there is an .exit or .out file, the program
+is also run when toolchain.Available(), and must exit with that status and
+write that to stdout.
+
+After an intended change to the generated code, run the tests with -update to
+rewrite the .s files, and review the changes to them.
+*/
+func TestGolden(t *testing.T) {
+	var paths []string
+	err := filepath.WalkDir("testdata", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && strings.HasSuffix(path, ".thing") {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range paths {
+		for _, golden := range goldenOptions {
+			name := strings.TrimSuffix(path, ".thing")
+			t.Run(name+golden.extension, func(t *testing.T) {
+				checkGolden(t, path, name+golden.extension, golden.options)
+			})
+		}
+	}
+}
+
+func checkGolden(t *testing.T, path string, golden string, options Options) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled := compileWithOptions(t, string(source), options)
+	if options.Optimise > 0 {
+		compiled = Peephole(compiled)
+	}
+	rendered := Render(compiled)
+
+	if *update {
+		if err := os.WriteFile(golden, []byte(rendered), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%s, run the tests with -update to create it", err)
+	}
+	if rendered != string(expected) {
+		t.Errorf("The program no longer compiles to %s, run the tests with -update if that is intended\n%s", golden, diffLines(string(expected), rendered))
+	}
+
+	if toolchain.Available() {
+		checkRun(t, strings.TrimSuffix(path, ".thing"), rendered)
+	}
+}
+
+// Runs a compiled program, and checks its status and output against the .exit
+// and .out files of the program if they exist
+func checkRun(t *testing.T, name string, assembly string) {
+	expectedExit, exitErr := os.ReadFile(name + ".exit")
+	expectedOut, outErr := os.ReadFile(name + ".out")
+	if exitErr != nil && outErr != nil {
+		return
+	}
+
+	binary, err := toolchain.Build(assembly, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exitCode, stdout, err := toolchain.Run(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exitErr == nil && strings.TrimSpace(string(expectedExit)) != fmt.Sprint(exitCode) {
+		t.Errorf("Expected the program to exit with %s, got %d", strings.TrimSpace(string(expectedExit)), exitCode)
+	}
+	if outErr == nil && string(expectedOut) != stdout {
+		t.Errorf("Expected the program to write\n%s\ngot\n%s", expectedOut, stdout)
+	}
+}
+
+// Shows the lines around the first difference between two texts
+func diffLines(expected string, actual string) string {
+	expectedLines := strings.Split(expected, "\n")
+	actualLines := strings.Split(actual, "\n")
+
+	first := 0
+	for first < len(expectedLines) && first < len(actualLines) && expectedLines[first] == actualLines[first] {
+		first++
+	}
+
+	var b = strings.Builder{}
+	b.WriteString(fmt.Sprintf("first difference at line %d:\n", first+1))
+	for i := first - 3; i < first; i++ {
+		if i >= 0 {
+			b.WriteString("  " + expectedLines[i] + "\n")
+		}
+	}
+	for i := first; i < first+5 && i < len(expectedLines); i++ {
+		b.WriteString("- " + expectedLines[i] + "\n")
+	}
+	for i := first; i < first+5 && i < len(actualLines); i++ {
+		b.WriteString("+ " + actualLines[i] + "\n")
+	}
+	return b.String()
+}
+
+func TestArrowTipes(t *testing.T) {
+	intToBool := fromType(&checker.TArrow{Params: []checker.Type{checker.T_INT}, Returns: checker.T_BOOL})
+	boolIntToInt := fromType(&checker.TArrow{Params: []checker.Type{checker.T_BOOL, checker.T_INT}, Returns: checker.T_INT})
+
+	if intToBool.IsEqualTo(boolIntToInt) {
+		t.Fatalf("Expected \"%s\" and \"%s\" to be different types", intToBool.Render(), boolIntToInt.Render())
+	}
+	if !intToBool.IsEqualTo(T_ARROW([]Tipe{T_INT}, T_BOOL)) {
+		t.Fatalf("Expected \"(int) -> bool\", got \"%s\"", intToBool.Render())
+	}
+	if boolIntToInt.Render() != "(bool, int) -> int" {
+		t.Fatalf("Expected \"(bool, int) -> int\", got \"%s\"", boolIntToInt.Render())
+	}
+}
+
+func TestCompileInferredAssignment(t *testing.T) {
+	compileHelper(t, "let x = 3 let y = x < 4 let z: bool = y")
+}
+
+func TestCompileGenericFunctions(t *testing.T) {
+	compiled := compileHelper(t, `
+		let id = def (x) { x }
+		let a: int = id(3)
+		let b: bool = id(true)
+		let f: (int) -> int = id
+	`)
+
+	calls := 0
+	for _, instruction := range compiled {
+		if instruction.Opcode == OP_CALL {
+			calls++
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("Expected 2 calls, got %d", calls)
+	}
+}
+
+func TestCompileResolvesShadowedNames(t *testing.T) {
+	compiled := compileHelper(t, "let x = 1 let y = 2 let x = 3 let z = x")
+
+	// z should load the second x, which is on the top of the stack
+	load := compiled[len(compiled)-6]
+	if rendered := Render([]Instruction{load}); rendered != "\tmov rax, [rsp]\n" {
+		t.Fatalf("Expected \"mov rax, [rsp]\", got %q", rendered)
+	}
+}
+
+func TestCompileUnresolvedIdentifier(t *testing.T) {
+	program := parseHelper(t, "let x = 1 let y = x")
+	info, checkErr := checker.Check(program)
+	if checkErr != nil {
+		t.Fatalf("Failed to check: %s", checkErr.ToError("foo"))
+	}
+	for ident := range info.Uses {
+		delete(info.Uses, ident)
+	}
 
+	if _, err := Compile(program, info, Options{}); err == nil {
+		t.Fatal("Expected an identifier the checker didn't resolve to be an error")
+	}
+}
+
+func TestCompileReassignment(t *testing.T) {
+	compiled := compileHelper(t, "var x = 1 let y = 2 x = 3")
+
+	// x is below y on the stack
+	store := compiled[len(compiled)-5]
+	if rendered := Render([]Instruction{store}); rendered != "\tmov [rsp+8], rax\n" {
+		t.Fatalf("Expected \"mov [rsp+8], rax\", got %q", rendered)
+	}
+
+	// The last statement doesn't bind anything, so the program exits with 0
+	status := compiled[len(compiled)-4]
+	if rendered := Render([]Instruction{status}); rendered != "\tmov rax, 0\n" {
+		t.Fatalf("Expected \"mov rax, 0\", got %q", rendered)
+	}
+}
+
+func TestCompileWhile(t *testing.T) {
+	compiled := compileHelper(t, `
+		var x = 0
+		while x < 10 {
+			let y = x + 1
+			x = y
+			while true {
+				let z = 1
+				break
+			}
+			continue
+		}
+	`)
+
+	var output []string
+	for _, instruction := range compiled {
+		if instruction.Opcode == OP_ADD && instruction.Operands[0] == RSP || instruction.Opcode == OP_JMP {
+			output = append(output, Render([]Instruction{instruction}))
+		}
+	}
+
+	// The comparison and addition pop their operands, break pops z and
+	// continue pops y, every iteration pops the locals of each loop body
+	expected := []string{
+		"\tjmp label_4\n",
+		"\tadd rsp, 8\n",
+		"\tadd rsp, 8\n",
+		"\tadd rsp, 8\n",
+		"\tjmp label_6\n",
+		"\tadd rsp, 8\n",
+		"\tjmp label_5\n",
+		"\tadd rsp, 8\n",
+		"\tjmp label_1\n",
+		"\tadd rsp, 8\n",
+		"\tjmp label_1\n",
+	}
+	if fmt.Sprint(output) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestCompileFor(t *testing.T) {
+	compiled := compileHelper(t, `
+		var total = 0
+		for i in 0..=10 {
+			total = total + i
+			continue
+		}
+	`)
+
+	rendered := Render(compiled)
+	expected := `	mov rax, 0
+	push rax
+	mov rax, 10
+	push rax
+label_1: 
+	mov rax, [rsp+8]
+	cmp rax, [rsp]
+	jg label_3
+	mov rax, [rsp+16]
+	push rax
+	mov rax, [rsp+16]
+	add rax, [rsp]
+	add rsp, 8
+	mov [rsp+16], rax
+	jmp label_2
+label_2: 
+	add qword [rsp+8], 1
+	jmp label_1
+label_3: 
+	add rsp, 16
+`
+	if !strings.Contains(rendered, expected) {
+		t.Fatalf("Expected the loop to compile to\n%s\ngot\n%s", expected, rendered)
+	}
+}
+
+func TestCompileArrays(t *testing.T) {
+	compiled := compileHelper(t, `
+		var a = [1, 2, 3]
+		a[2] = a[0]
+	`)
+
+	rendered := Render(compiled)
+	expected := `	mov rax, 3
+	push rax
+	mov rax, 2
+	push rax
+	mov rax, 1
+	push rax
+	mov rax, 0
+	cmp rax, 3
+	jae bounds_error
+	imul rax, rax, 8
+	mov rax, [rsp+rax]
+	push rax
+	push 0
+	mov rax, 2
+	cmp rax, 3
+	jae bounds_error
+	imul rax, rax, 8
+	add [rsp], rax
+	pop rcx
+	lea rcx, [rsp+rcx+8]
+	mov rax, [rsp]
+	mov [rcx], rax
+	add rsp, 8
+`
+	if !strings.Contains(rendered, expected) {
+		t.Fatalf("Expected the program to compile to\n%s\ngot\n%s", expected, rendered)
+	}
+
+	// The bounds error routine is only included when it is used
+	if !strings.Contains(rendered, "bounds_error: \n") {
+		t.Fatalf("Expected the bounds error routine to be included, got\n%s", rendered)
+	}
+	if rendered := Render(compileHelper(t, "let x = 1")); strings.Contains(rendered, "bounds_error") {
+		t.Fatalf("Expected the bounds error routine to be left out, got\n%s", rendered)
+	}
+}
+
+func TestArrayTipes(t *testing.T) {
+	matrix := fromType(&checker.TArray{Elem: &checker.TArray{Elem: checker.T_INT, Length: 2}, Length: 3})
+	if matrix.Size != 48 {
+		t.Fatalf("Expected a [[int; 2]; 3] to be 48 bytes, got %d", matrix.Size)
+	}
+	if matrix.Render() != "[[int; 2]; 3]" {
+		t.Fatalf("Expected \"[[int; 2]; 3]\", got \"%s\"", matrix.Render())
+	}
+	if matrix.IsEqualTo(T_ARRAY(T_ARRAY(T_INT, 3), 2)) {
+		t.Fatal("Expected [[int; 2]; 3] and [[int; 3]; 2] to be different types")
+	}
+}
+
+func TestCompileStructs(t *testing.T) {
+	compiled := compileHelper(t, `
+		type Point = { x: int, y: int }
+		var p = Point { y: 2, x: 1 }
+		p.y = p.x
+	`)
+
+	rendered := Render(compiled)
+	expected := `	mov rax, 2
+	push rax
+	mov rax, 1
+	push rax
+	mov rax, [rsp]
+	push rax
+	push 0
+	add qword [rsp], 8
+	pop rcx
+	lea rcx, [rsp+rcx+8]
+	mov rax, [rsp]
+	mov [rcx], rax
+	add rsp, 8
+`
+	if !strings.Contains(rendered, expected) {
+		t.Fatalf("Expected the program to compile to\n%s\ngot\n%s", expected, rendered)
+	}
+}
+
+func TestStructTipes(t *testing.T) {
+	point := &checker.TStruct{Name: "Point", Fields: []checker.Field{{Name: "x", Type: checker.T_INT}, {Name: "y", Type: checker.T_INT}}}
+	line := fromType(&checker.TStruct{Name: "Line", Fields: []checker.Field{
+		{Name: "tag", Type: checker.T_BOOL},
+		{Name: "from", Type: point},
+		{Name: "to", Type: point},
+	}})
+	if line.Size != 40 {
+		t.Fatalf("Expected a Line to be 40 bytes, got %d", line.Size)
+	}
+	for name, offset := range map[string]int{"tag": 0, "from": 8, "to": 24} {
+		if field := line.field(name); field.Offset != offset {
+			t.Fatalf("Expected %s to be at offset %d, got %d", name, offset, field.Offset)
+		}
+	}
+	if line.Render() != "Line" {
+		t.Fatalf("Expected \"Line\", got \"%s\"", line.Render())
+	}
+}
+
+func TestCompileUnions(t *testing.T) {
+	compiled := compileHelper(t, `
+		type Shape = Circle(int) | Rect(int, int)
+		let area = match Circle(3) { Circle(r) => r, Rect(w, h) => w }
+	`)
+
+	rendered := Render(compiled)
+	expected := `	push 0
+	mov rax, 3
+	push rax
+	push 0
+	cmp qword [rsp], 0
+	jne label_2
+	mov rax, [rsp+8]
+	jmp label_1
+label_2: 
+	mov rax, [rsp+8]
+label_1: 
+	add rsp, 24
+`
+	if !strings.Contains(rendered, expected) {
+		t.Fatalf("Expected the program to compile to\n%s\ngot\n%s", expected, rendered)
+	}
+}
+
+func TestUnionTipes(t *testing.T) {
+	shape := fromType(&checker.TUnion{Name: "Shape", Variants: []checker.Variant{
+		{Name: "Circle", Payload: []checker.Type{checker.T_INT}},
+		{Name: "Rect", Payload: []checker.Type{checker.T_INT, checker.T_INT}},
+		{Name: "Empty"},
+	}})
+	if shape.Size != 24 {
+		t.Fatalf("Expected a Shape to be 24 bytes, got %d", shape.Size)
+	}
+	if shape.tag("Empty") != 2 {
+		t.Fatalf("Expected Empty to have tag 2, got %d", shape.tag("Empty"))
+	}
+	if offset := shape.Variants[1].Payload[1].Offset; offset != 16 {
+		t.Fatalf("Expected the second value of Rect to be at offset 16, got %d", offset)
+	}
+
+	flag := fromType(&checker.TUnion{Name: "Flag", Variants: []checker.Variant{{Name: "On"}, {Name: "Off"}}})
+	if !flag.InRegister() {
+		t.Fatal("Expected a union without payloads to be held in a register")
+	}
+}
+
+func TestOptionTipes(t *testing.T) {
+	option := fromType(checker.T_OPTION(&checker.TTuple{Elems: []checker.Type{checker.T_INT, checker.T_INT}}))
+	if option.Size != 24 || option.Render() != "option<(int, int)>" {
+		t.Fatalf("Expected a 24 byte option<(int, int)>, got a %d byte %s", option.Size, option.Render())
+	}
+	if option.tag("none") != 0 || option.tag("some") != 1 {
+		t.Fatalf("Expected none and some to have tags 0 and 1, got %d and %d", option.tag("none"), option.tag("some"))
+	}
+	if option.IsEqualTo(fromType(checker.T_OPTION(&checker.TTuple{Elems: []checker.Type{checker.T_BOOL, checker.T_INT}}))) {
+		t.Fatal("Expected option<(int, int)> and option<(bool, int)> to be different types")
+	}
+	if !fromType(checker.T_OPTION(checker.T_LIST(checker.T_INT))).hasPointers() {
+		t.Fatal("Expected the garbage collector to look inside an option<list<int>>")
+	}
+}
+
+func TestCompileTuples(t *testing.T) {
+	compiled := compileHelper(t, `
+		let (a, b) = (1, true)
+		let c = a
+	`)
+
+	rendered := Render(compiled)
+	expected := `	mov rax, 1
+	push rax
+	mov rax, 1
+	push rax
+	mov rax, [rsp]
+	push rax
+`
+	if !strings.Contains(rendered, expected) {
+		t.Fatalf("Expected the program to compile to\n%s\ngot\n%s", expected, rendered)
+	}
+
+	pair := fromType(&checker.TTuple{Elems: []checker.Type{checker.T_INT, &checker.TArray{Elem: checker.T_BOOL, Length: 2}}})
+	if pair.Size != 24 || pair.Render() != "(int, [bool; 2])" {
+		t.Fatalf("Expected a 24 byte (int, [bool; 2]), got a %d byte %s", pair.Size, pair.Render())
+	}
+	if pair.IsEqualTo(T_TUPLE([]Tipe{T_ARRAY(T_BOOL, 2), T_INT})) {
+		t.Fatal("Expected (int, [bool; 2]) and ([bool; 2], int) to be different types")
+	}
+}
+
+func TestRuntimeAlloc(t *testing.T) {
+	runtime := &Runtime{}
+	if rendered := Render(runtime.Instructions()); rendered != "" {
+		t.Fatalf("Expected an unused runtime to be empty, got\n%s", rendered)
+	}
+
+	labelCounter = 0
+	if rendered := Render(runtime.alloc(T_INT, NewEnv(nil))); rendered != "\tmov rcx, 0\n\tcall alloc\nlabel_1: \n" {
+		t.Fatalf("Expected \"call alloc\", got \"%s\"", rendered)
+	}
+
+	rendered := Render(runtime.Instructions())
+	for _, expected := range []string{"alloc: \n", "\tmov rax, 33554629\n", "\tjc out_of_memory\n", "heap_ptr: dq 0\n", "heap_end: dq 0\n"} {
+		if !strings.Contains(rendered, expected) {
+			t.Fatalf("Expected the runtime to contain \"%s\", got\n%s", expected, rendered)
+		}
+	}
+}
+
+func TestGCStackMaps(t *testing.T) {
+	runtime := &Runtime{}
+	labelCounter = 0
+
+	pointer := Tipe{Name: "ref", Size: 8, Pointer: true}
+	pair := T_STRUCT("pair", []Field{{Name: "a", Tipe: T_INT}, {Name: "b", Tipe: pointer}})
+	env := NewEnv(nil).addTemp(T_INT).addTemp(T_ARRAY(pair, 2)).addTemp(pointer)
+
+	runtime.alloc(pair, env)
+	rendered := Render(runtime.Instructions())
+	for _, expected := range []string{
+		// The frame is 48 bytes and is the top level of the program
+		"gc_map_0: dq label_1, 48, 1, gc_trace_1\n",
+		// The object holds pairs, so the pointer is 8 bytes into each cell
+		"gc_trace_0: \n",
+		"\tlea rdi, [rbx+8]\n",
+		// The temp pushed last is at the bottom of the frame
+		"gc_trace_1: \n\tlea rdi, [rbx]\n\tcall gc_forward\n",
+		"gc_maps_end: dq 0\n",
+	} {
+		if !strings.Contains(rendered, expected) {
+			t.Fatalf("Expected the runtime to contain \"%s\", got\n%s", expected, rendered)
+		}
+	}
+
+	// Frames of functions stop at the return address, and frames without
+	// pointers have nothing to trace
+	runtime.safepoint(NewEnv(nil).addTemp(pointer).addReturnAddress().addTemp(T_INT))
+	if rendered := Render(runtime.gcMaps()); !strings.Contains(rendered, "gc_map_1: dq label_2, 8, 0, 0\n") {
+		t.Fatalf("Expected a frame of 8 bytes with nothing to trace, got\n%s", rendered)
+	}
+}
+
+// The program allocates several times the initial heap through calls, while
+// keeping a list alive across them, so it only works if collections find and
+// move that list
+func TestGCCollects(t *testing.T) {
+	source, err := os.ReadFile("testdata/collection.thing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled := compileWithOptions(t, string(source), Options{GCStats: true})
+	if !toolchain.Available() {
+		t.Skip("Native programs can't be built and run here")
+	}
+
+	binary, err := toolchain.Build(Render(compiled), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exitCode, _, stderr, err := toolchain.RunWithStderr(binary)
+	if err != nil {
+		t.Fatalf("Failed to run: %s", err)
+	}
+	if exitCode != 28 {
+		t.Fatalf("Expected the program to exit with 28, got %d", exitCode)
+	}
+	if !strings.Contains(stderr, "gc_collections: ") || strings.Contains(stderr, "gc_collections: 0\n") {
+		t.Fatalf("Expected the program to collect garbage, got\n%s", stderr)
+	}
+}
+
+func TestCompileLists(t *testing.T) {
+	compiled := compileHelper(t, `
+		let xs = list[1, 2]
+		let n = push(xs, 3)
+	`)
+	rendered := Render(compiled)
+	for _, expected := range []string{
+		// The buffer holds two ints and has nothing to trace, the header
+		// points to the buffer
+		"\tmov rax, 16\n\tmov rcx, 0\n\tcall alloc\n",
+		"\tmov rax, 24\n\tlea rcx, [rel gc_trace_0]\n\tcall alloc\n",
+		"gc_trace_0: \n",
+		"\tlea rdi, [rbx+16]\n\tcall gc_forward\n\tadd rbx, 24\n",
+		// When the buffer grows, both xs and the copy of it being pushed to
+		// are roots
+		"gc_map_2: dq label_7, 32, 1, gc_trace_2\n",
+		"gc_trace_2: \n\tlea rdi, [rbx+16]\n\tcall gc_forward\n\tlea rdi, [rbx+24]\n\tcall gc_forward\n\tret \n",
+	} {
+		if !strings.Contains(rendered, expected) {
+			t.Fatalf("Expected the program to contain \"%s\", got\n%s", expected, rendered)
+		}
+	}
+
+	list := fromType(checker.T_LIST(&checker.TTuple{Elems: []checker.Type{checker.T_INT, checker.T_BOOL}}))
+	if list.Size != 8 || !list.Pointer || list.Render() != "list<(int, bool)>" {
+		t.Fatalf("Expected an 8 byte pointer to list<(int, bool)>, got a %d byte %s", list.Size, list.Render())
+	}
+	if list.IsEqualTo(T_LIST(T_INT)) {
+		t.Fatal("Expected list<(int, bool)> and list<int> to be different types")
+	}
+}
+
+func lowerHelper(t *testing.T, s string) *ir.Program {
+	program := parseHelper(t, s)
+	info, checkErr := checker.Check(program)
+	if checkErr != nil {
+		t.Fatalf("Failed to check: %s", checkErr.ToError("foo"))
+	}
+	lowered, err := ir.Lower(program, info)
+	if err != nil {
+		t.Fatalf("Failed to lower: %s", err)
+	}
+	return lowered
+}
+
+func TestAllocateRegisters(t *testing.T) {
+	program := lowerHelper(t, `
+		let f = def (x: int) -> int { x }
+		let a = 1
+		let b = f(a)
+		let c = a + b
+	`)
+
+	// a is needed after the call, so it has to be in a callee saved register
+	allocation := allocateRegisters(program.Functions[0])
+	if locations := renderOperands(allocation.locations); locations != "[rcx rbx rdx rcx]" {
+		t.Fatalf("Expected the registers to be in [rcx rbx rdx rcx], got %s", locations)
+	}
+	checkAllocation(t, program.Functions[0], allocation)
+	if saved := renderOperands(allocation.saved); saved != "[rbx]" || allocation.slots != 0 {
+		t.Fatalf("Expected only rbx to be saved and nothing to be spilled, got %s and %d slots", saved, allocation.slots)
+	}
+
+	// The parameter is only live until the return
+	if locations := renderOperands(allocateRegisters(program.Functions[1]).locations); locations != "[rcx]" {
+		t.Fatalf("Expected the parameter to be in rcx, got %s", locations)
+	}
+}
+
+func TestAllocateRegistersSpills(t *testing.T) {
+	var b strings.Builder
+	var sum strings.Builder
+	for i := 0; i < 16; i++ {
+		name := string(rune('a' + i))
+		b.WriteString(fmt.Sprintf("let %s = %d\n", name, i))
+		sum.WriteString(name + " + ")
+	}
+	b.WriteString("let sum = " + sum.String() + "0")
+
+	// The values are all live until the sum, and there are only 13 registers
+	fn := lowerHelper(t, b.String()).Functions[0]
+	allocation := allocateRegisters(fn)
+	if allocation.slots == 0 {
+		t.Fatalf("Expected some values to be spilled, got %s", renderOperands(allocation.locations))
+	}
+	checkAllocation(t, fn, allocation)
+}
+
+// Checks that registers that are live at the same time are in different
+// places, and that calls don't clobber them
+func checkAllocation(t *testing.T, fn *ir.Function, allocation allocation) {
+	intervals := ir.Intervals(fn)
+	for i, interval := range intervals {
+		location := allocation.locations[interval.Register]
+		if interval.CrossesCall && !isMemory(location) && !isCalleeSaved(location) {
+			t.Errorf("Expected r%d to be saved across calls, got %s", interval.Register, location.Render())
+		}
+		for _, other := range intervals[i+1:] {
+			overlaps := other.Start <= interval.End && interval.Start <= other.End
+			if overlaps && allocation.locations[other.Register] == location {
+				t.Errorf("Expected r%d and r%d to be in different places, both are in %s", interval.Register, other.Register, location.Render())
+			}
+		}
+	}
+}
+
+func renderOperands[T Operand](operands []T) string {
+	rendered := make([]string, len(operands))
+	for i, operand := range operands {
+		rendered[i] = operand.Render()
+	}
+	return fmt.Sprint(rendered)
+}
+
+func TestCompileOptimised(t *testing.T) {
+	input := "let unused = def (x: int) -> int { x } let x: int = 2 + 3"
+
+	// Without folding, the constants are still added at runtime
+	if rendered := Render(compileWithOptions(t, input, Options{Optimise: 1})); !strings.Contains(rendered, "\tadd ") {
+		t.Fatalf("Expected the unoptimised program to add, got\n%s", rendered)
+	}
+
+	rendered := Render(compileWithOptions(t, input, Options{Optimise: 2}))
+	expected := `section .text
+global _start
+_start: 
+	push rbp
+	mov rbp, rsp
+	mov rcx, 5
+	mov rax, rcx
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
+`
+	if rendered != expected {
+		t.Fatalf("Expected the optimised program to compile to\n%s\ngot\n%s", expected, rendered)
+	}
+}
+
+func TestRender(t *testing.T) {
+	instructions := []Instruction{
+		SECTION(".text"),
+		LABEL("start"),
+		MOV(RAX, Mem(RSP, 0)),
+		MOV(Mem(RBP, -16).Qword(), Immediate(-1)),
+		LEA(RCX, Indexed(RSP, RAX, 8)),
+		MOV(Mem(RSI, 0).Byte(), DL),
+		LEA(RSI, Rel("digits").Plus(20)),
+		SET(OP_JL, R9B),
+		JMP("start"),
+		DB("message", String("done"), Immediate(10)),
+		DQ("table", Label("start"), Immediate(0)),
+	}
+	expected := `section .text
+start: 
+	mov rax, [rsp]
+	mov qword [rbp-16], -1
+	lea rcx, [rsp+rax+8]
+	mov byte [rsi], dl
+	lea rsi, [rel digits+20]
+	setl r9b
+	jmp start
+message: db "done", 10
+table: dq start, 0
+`
+	if rendered := Render(instructions); rendered != expected {
+		t.Fatalf("Expected the instructions to render as\n%s\ngot\n%s", expected, rendered)
+	}
+
+	lows := map[Register]Register{RAX: AL, RBX: BL, RCX: CL, RDX: DL, RSI: SIL, RDI: DIL, R8: R8B, R12: R12B, R15: R15B}
+	for register := RAX; register <= R15; register++ {
+		low, ok := register.Low()
+		if expected, exists := lows[register]; exists && (!ok || low != expected) {
+			t.Errorf("Expected the low byte of %s to be %s, got %s", register.Render(), expected.Render(), low.Render())
+		}
+		if ok != (register != RSP && register != RBP) {
+			t.Errorf("Expected %s to have a low byte only if it is not rsp or rbp", register.Render())
+		}
+	}
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		rule     string
+		input    []Instruction
+		expected []Instruction
+	}{
+		{
+			"push-pop-move",
+			[]Instruction{PUSH(RAX), POP(RCX)},
+			[]Instruction{MOV(RCX, RAX)},
+		},
+		{
+			"push-reload",
+			[]Instruction{PUSH(RAX), MOV(RAX, Mem(RSP, 0))},
+			[]Instruction{PUSH(RAX)},
+		},
+		{
+			"store-reload",
+			[]Instruction{MOV(Mem(RSP, 8), RAX), MOV(RAX, Mem(RSP, 8))},
+			[]Instruction{MOV(Mem(RSP, 8), RAX)},
+		},
+		{
+			"move-to-itself",
+			[]Instruction{MOV(RCX, RCX), RET()},
+			[]Instruction{RET()},
+		},
+		{
+			"pop-push",
+			[]Instruction{ADD(RSP, Immediate(8)), PUSH(RAX)},
+			[]Instruction{MOV(Mem(RSP, 0), RAX)},
+		},
+		{
+			"merge-stack-adjustments",
+			[]Instruction{ADD(RSP, Immediate(8)), ADD(RSP, Immediate(16))},
+			[]Instruction{ADD(RSP, Immediate(24))},
+		},
+		{
+			"jump-to-next",
+			[]Instruction{JMP("label_1"), LABEL("label_1"), RET()},
+			[]Instruction{LABEL("label_1"), RET()},
+		},
+		{
+			"compare-to-set",
+			[]Instruction{
+				CMP(RCX, RDX),
+				JG("label_1"),
+				MOV(RCX, Immediate(0)),
+				JMP("label_2"),
+				LABEL("label_1"),
+				MOV(RCX, Immediate(1)),
+				LABEL("label_2"),
+			},
+			[]Instruction{CMP(RCX, RDX), SET(OP_JG, CL), MOVZX(RCX, CL)},
+		},
+	}
+
+	tested := map[string]bool{}
+	for _, tt := range tests {
+		tested[tt.rule] = true
+		if rendered, expected := Render(Peephole(tt.input)), Render(tt.expected); rendered != expected {
+			t.Errorf("Expected %s to rewrite\n%s\nto\n%s\ngot\n%s", tt.rule, Render(tt.input), expected, rendered)
+		}
+	}
+	for _, rule := range PEEPHOLE_RULES {
+		if !tested[rule.name] {
+			t.Errorf("Expected a test of the %s rule", rule.name)
+		}
+	}
+
+	// Rewrites that would be wrong, or would look across a label
+	unchanged := [][]Instruction{
+		{PUSH(Mem(RSP, 8).Qword()), POP(RAX)},
+		{PUSH(RAX), MOV(RAX, Mem(RSP, 8))},
+		{MOV(Mem(RSP, 8), RAX), LABEL("label_1"), MOV(RAX, Mem(RSP, 8))},
+		{ADD(RSP, Immediate(16)), PUSH(RAX)},
+		{ADD(RSP, Immediate(8)), LABEL("label_1"), ADD(RSP, Immediate(8))},
+		{JMP("label_1"), LABEL("label_2")},
+		{CMP(RCX, RDX), JG("label_1"), MOV(Mem(RBP, -8).Qword(), Immediate(0)), JMP("label_2"), LABEL("label_1"), MOV(Mem(RBP, -8).Qword(), Immediate(1)), LABEL("label_2")},
+	}
+	for _, input := range unchanged {
+		if rendered, expected := Render(Peephole(input)), Render(input); rendered != expected {
+			t.Errorf("Expected\n%s\nto be left alone, got\n%s", expected, rendered)
+		}
+	}
 }
@@ -3,92 +3,131 @@ package compiler
 import (
 	"errors"
 	"fmt"
+	"monkey/checker"
 	"monkey/parser"
 )
 
+// A binding is a value on the stack. Symbol is nil for values that were not
+// bound by the program author, e.g. temporaries.
 type Binding struct {
-	Name string
-	Tipe Tipe
+	Symbol *checker.Symbol
+	Tipe   Tipe
 }
 
 type Env struct {
 	globals []Binding
-	tipes   map[string]Tipe
+	info    *checker.Info
+	runtime *Runtime
+	loop    *Loop
 }
 
-func NewEnv() *Env {
+// The innermost loop being compiled
+type Loop struct {
+	// The labels that continue and break jump to
+	Continue string
+	Break    string
+
+	// The size of the stack when the loop was entered, everything above it
+	// is popped when leaving an iteration
+	Size int
+}
+
+func NewEnv(info *checker.Info) *Env {
 	return &Env{
 		globals: []Binding{},
-		tipes: map[string]Tipe{
-			"int":  T_INT,
-			"bool": T_BOOL,
-		},
+		info:    info,
+		runtime: &Runtime{},
 	}
 }
 
-func (env *Env) addBinding(s string, tipe parser.TypeExpression) (*Env, error) {
-	if s == NEVER {
-		panic(fmt.Sprint(s, " is not a valid name for assignment. If you are seeing this error, something has gone terribly wrong."))
+// Returns a copy of the environment with another binding on top of the stack
+func (env *Env) push(binding Binding) *Env {
+	globals := make([]Binding, len(env.globals), len(env.globals)+1)
+	copy(globals, env.globals)
+
+	return &Env{
+		globals: append(globals, binding),
+		info:    env.info,
+		runtime: env.runtime,
+		loop:    env.loop,
 	}
+}
 
-	t_var, ok := env.lookupTipe(tipe)
-	if !ok {
-		err := fmt.Sprint("unknown type ", tipe)
-		return env, errors.New(err)
+func (env *Env) addBinding(symbol *checker.Symbol, tipe Tipe) *Env {
+	if symbol == nil {
+		panic("cannot bind a value without a symbol. If you are seeing this error, something has gone terribly wrong.")
 	}
 
-	return &Env{
-		globals: append(env.globals, Binding{Name: s, Tipe: t_var}),
-		tipes:   env.tipes,
-	}, nil
+	return env.push(Binding{Symbol: symbol, Tipe: tipe})
+}
+
+// Used for values pushed onto the stack that were not bound by the program
+// author, the type tells the garbage collector where the pointers are
+func (env *Env) addTemp(tipe Tipe) *Env {
+	return env.push(Binding{Symbol: nil, Tipe: tipe})
+}
+
+// Marks where the return address of the function being compiled is, which is
+// where its stack frame ends
+func (env *Env) addReturnAddress() *Env {
+	return env.push(Binding{Symbol: nil, Tipe: T_RETURN_ADDRESS})
 }
 
 /*
 Used when an element has been pushed onto the stack without calling 'addBinding',
-and lexical address resolution still needs to work. The created symbol is gauranteed
-to never match a local bound by the program author.
+and lexical address resolution still needs to work. The created binding is gauranteed
+to never match a symbol declared by the program author. It must not hold pointers
+to the heap.
 */
 func (env *Env) addNever(size int) *Env {
+	return env.push(Binding{Symbol: nil, Tipe: T_NEVER(size)})
+}
+
+// Returns an environment with none of the bindings, for the stack frame of a
+// function
+func (env *Env) isolated() *Env {
 	return &Env{
-		globals: append(env.globals, Binding{Name: NEVER, Tipe: T_NEVER(size)}),
+		globals: []Binding{},
+		info:    env.info,
+		runtime: env.runtime,
 	}
 }
 
-func (env *Env) lexicalAddress(s string) (int, Tipe, error) {
-	jump := 0
-	for i := len(env.globals) - 1; i >= 0; i-- {
-		if s == env.globals[i].Name {
-			return jump, env.globals[i].Tipe, nil
-		}
-		jump += env.globals[i].Tipe.Size
+// Returns an environment for the body of a loop
+func (env *Env) enterLoop(continueLabel string, breakLabel string) *Env {
+	return &Env{
+		globals: env.globals,
+		info:    env.info,
+		runtime: env.runtime,
+		loop:    &Loop{Continue: continueLabel, Break: breakLabel, Size: env.size()},
 	}
-	return 0, T_NEVER(0), errors.New(fmt.Sprint("unbound variable ", s))
 }
 
-func (env *Env) lookupTipe(tipe parser.TypeExpression) (Tipe, bool) {
-	switch tipe := tipe.(type) {
-	case *parser.LiteralType:
-		name := tipe.Name
-		res, ok := env.tipes[name]
-		return res, ok
-	case *parser.ArrowType:
-		// If it is a function, we need to check all parameters
-		params := tipe.Parameters
-		for _, param := range params {
-			if _, ok := env.lookupTipe(param); !ok {
-				return T_NEVER(0), false
-			}
-		}
-		// We also need to check the return type
-		if _, ok := env.lookupTipe(tipe.Returns); !ok {
-			return T_NEVER(0), false
-		}
-		return T_ARROW, true
-	default:
-		return T_NEVER(0), false
+// The number of bytes the bindings occupy on the stack
+func (env *Env) size() int {
+	size := 0
+	for _, binding := range env.globals {
+		size += binding.Tipe.Size
 	}
+	return size
 }
 
-const (
-	NEVER = "-"
-)
+// The type the checker inferred for an expression
+func (env *Env) tipeOf(expression parser.Expression) Tipe {
+	return fromType(env.info.TypeOf(expression))
+}
+
+// The symbol is nil for an identifier the checker didn't resolve
+func (env *Env) lexicalAddress(symbol *checker.Symbol) (int, error) {
+	if symbol == nil {
+		return 0, errors.New("unresolved identifier")
+	}
+	jump := 0
+	for i := len(env.globals) - 1; i >= 0; i-- {
+		if symbol == env.globals[i].Symbol {
+			return jump, nil
+		}
+		jump += env.globals[i].Tipe.Size
+	}
+	return 0, errors.New(fmt.Sprint("unbound variable ", symbol.Name))
+}
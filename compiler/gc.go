@@ -0,0 +1,452 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+The heap is managed by a copying collector. Every object on the heap starts
+with a two word header, and pointers to it point just past the header:
+
+	[ptr-16]              size of the object in bytes, including the header
+	[ptr-8]               the routine that traces the object, or 0
+
+An object is an array of cells of a single type. When an allocation doesn't
+fit, every object reachable from the stack is copied to a freshly mapped
+space and the old one is unmapped. A copied object is left behind with its
+new address in place of its size, and -1 in place of its routine.
+
+The stack is walked frame by frame. Every call site is a safepoint, which
+records the size of the frame it is in and a routine that traces the pointers
+in that frame, keyed by the return address of the call.
+*/
+
+const GC_COLLECT = "gc_collect"
+const GC_FORWARD = "gc_forward"
+const GC_STATS = "gc_stats"
+
+// The size of the first space the heap is allocated from
+const HEAP_INITIAL_SIZE = 1 << 20
+
+type safepoint struct {
+	label string
+
+	// The number of bytes between the return address of the call and the
+	// return address of the function the call is in
+	frameSize int
+
+	// Set for the top level of the program, which has no return address
+	last bool
+
+	// Traces the frame, with rbx pointing just past the return address
+	trace Operand
+}
+
+// Allocates rax bytes on the heap for cells of the given type and leaves the
+// address in rax. Every other register is clobbered, so anything that is
+// live across the call must be on the stack and described by env.
+func (r *Runtime) alloc(cell Tipe, env *Env) []Instruction {
+	r.heap = true
+	output := []Instruction{MOV(RCX, Immediate(0))}
+	if trace, ok := r.heapTrace(cell).(Label); ok {
+		output = []Instruction{LEA(RCX, Rel(string(trace)))}
+	}
+	output = append(output, CALL(Label(ALLOC)))
+	return append(output, r.safepoint(env)...)
+}
+
+// Labels the return address of a call, recording where the pointers are in
+// the stack frame of the caller
+func (r *Runtime) safepoint(env *Env) []Instruction {
+	label := genLabel()
+	labels := 0
+	trace := []Instruction{}
+	offset := 0
+	last := true
+	for i := len(env.globals) - 1; i >= 0; i-- {
+		binding := env.globals[i]
+		if binding.Tipe.Name == T_RETURN_ADDRESS.Name {
+			last = false
+			break
+		}
+		trace = append(trace, traceValue(binding.Tipe, offset, &labels)...)
+		offset += binding.Tipe.Size
+	}
+
+	r.safepoints = append(r.safepoints, safepoint{
+		label:     label,
+		frameSize: offset,
+		last:      last,
+		trace:     r.traceRoutine(trace),
+	})
+	return []Instruction{LABEL(label)}
+}
+
+// Returns the routine that traces a heap object made of cells of the type,
+// with rbx pointing to the object and rsi holding its size without the header
+func (r *Runtime) heapTrace(cell Tipe) Operand {
+	if !cell.hasPointers() {
+		return Immediate(0)
+	}
+
+	labels := 0
+	loop, done := localLabel(&labels), localLabel(&labels)
+	trace := []Instruction{
+		PUSH(RBX),
+		LEA(RAX, Indexed(RBX, RSI, 0)),
+		PUSH(RAX),
+		LABEL(loop),
+		CMP(RBX, Mem(RSP, 0)),
+		JAE(done),
+	}
+	trace = append(trace, traceValue(cell, 0, &labels)...)
+	trace = append(trace, []Instruction{
+		ADD(RBX, Immediate(cell.Size)),
+		JMP(loop),
+		LABEL(done),
+		ADD(RSP, Immediate(8)),
+		POP(RBX),
+	}...)
+	return r.traceRoutine(trace)
+}
+
+// Emits a routine once for each distinct body, returning its label or 0 if
+// there is nothing to trace
+func (r *Runtime) traceRoutine(body []Instruction) Operand {
+	if len(body) == 0 {
+		return Immediate(0)
+	}
+	if r.traces == nil {
+		r.traces = map[string]string{}
+	}
+
+	key := Render(body)
+	if label, ok := r.traces[key]; ok {
+		return Label(label)
+	}
+	label := fmt.Sprint("gc_trace_", len(r.traces))
+	r.traces[key] = label
+
+	// Local labels are written as {n} until the routine has a name
+	resolve := strings.NewReplacer("{", label+"_", "}", "")
+	r.traceCode = append(r.traceCode, LABEL(label))
+	for _, instruction := range body {
+		operands := make([]Operand, len(instruction.Operands))
+		for i, operand := range instruction.Operands {
+			if local, ok := operand.(Label); ok {
+				operand = Label(resolve.Replace(string(local)))
+			}
+			operands[i] = operand
+		}
+		instruction.Operands = operands
+		r.traceCode = append(r.traceCode, instruction)
+	}
+	r.traceCode = append(r.traceCode, RET())
+	return Label(label)
+}
+
+func localLabel(labels *int) string {
+	*labels++
+	return fmt.Sprint("{", *labels, "}")
+}
+
+// Forwards every pointer in a value at rbx+offset. rbx is preserved.
+func traceValue(tipe Tipe, offset int, labels *int) []Instruction {
+	switch {
+	case !tipe.hasPointers():
+		return []Instruction{}
+	case tipe.Pointer:
+		return []Instruction{
+			LEA(RDI, Mem(RBX, offset)),
+			CALL(Label(GC_FORWARD)),
+		}
+	case tipe.Elem != nil:
+		loop := localLabel(labels)
+		output := []Instruction{
+			PUSH(RBX),
+			LEA(RBX, Mem(RBX, offset)),
+			PUSH(Immediate(tipe.Length)),
+			LABEL(loop),
+		}
+		output = append(output, traceValue(*tipe.Elem, 0, labels)...)
+		return append(output, []Instruction{
+			ADD(RBX, Immediate(tipe.Elem.Size)),
+			SUB(Mem(RSP, 0).Qword(), Immediate(1)),
+			JNE(loop),
+			ADD(RSP, Immediate(8)),
+			POP(RBX),
+		}...)
+	case len(tipe.Variants) > 0:
+		// Only the payload of the variant in the tag is traced
+		output := []Instruction{}
+		for tag, variant := range tipe.Variants {
+			payload := []Instruction{}
+			for _, field := range variant.Payload {
+				payload = append(payload, traceValue(field.Tipe, offset+field.Offset, labels)...)
+			}
+			if len(payload) == 0 {
+				continue
+			}
+			skip := localLabel(labels)
+			output = append(output, CMP(Mem(RBX, offset).Qword(), Immediate(tag)), JNE(skip))
+			output = append(output, payload...)
+			output = append(output, LABEL(skip))
+		}
+		return output
+	}
+
+	output := []Instruction{}
+	for _, field := range tipe.Fields {
+		output = append(output, traceValue(field.Tipe, offset+field.Offset, labels)...)
+	}
+	return output
+}
+
+/*
+A bump allocator over the current space. heap_ptr is the next free address
+and heap_end the end of the space. Both start out as 0, so the first
+allocation always collects, which maps the first space.
+*/
+func allocRoutine() []Instruction {
+	return []Instruction{
+		LABEL(ALLOC),
+		// Make room for the header and keep every object 8 byte aligned
+		ADD(RAX, Immediate(23)),
+		AND(RAX, Immediate(-8)),
+		ADD(Rel("gc_allocated"), RAX),
+		LABEL(ALLOC + "_bump"),
+		MOV(RDX, Rel("heap_ptr")),
+		LEA(RSI, Indexed(RDX, RAX, 0)),
+		CMP(RSI, Rel("heap_end")),
+		JA(ALLOC + "_collect"),
+		MOV(Rel("heap_ptr"), RSI),
+		MOV(Mem(RDX, 0), RAX),
+		MOV(Mem(RDX, 8), RCX),
+		LEA(RAX, Mem(RDX, 16)),
+		RET(),
+
+		LABEL(ALLOC + "_collect"),
+		PUSH(RAX),
+		PUSH(RCX),
+		CALL(Label(GC_COLLECT)),
+		POP(RCX),
+		POP(RAX),
+		JMP(ALLOC + "_bump"),
+	}
+}
+
+/*
+Collects the heap, called from alloc with the stack looking like:
+
+	[rsp+8]               the routine of the object being allocated
+	[rsp+16]              the size of the object being allocated
+	[rsp+24]              the return address of the call to alloc
+
+The new space is large enough for everything in the old one and the new
+object. It grows for the next collection once it is more than half full.
+*/
+func collectRoutine() []Instruction {
+	output := []Instruction{
+		LABEL(GC_COLLECT),
+		MOV(RAX, Rel("heap_ptr")),
+		SUB(RAX, Rel("heap_start")),
+		ADD(RAX, Mem(RSP, 16)),
+		CMP(RAX, Rel("heap_size")),
+		JBE(GC_COLLECT + "_map"),
+		MOV(Rel("heap_size"), RAX),
+		LABEL(GC_COLLECT + "_map"),
+	}
+	output = append(output, mmap(Rel("heap_size"))...)
+	output = append(output, []Instruction{
+		MOV(Rel("to_start"), RAX),
+		MOV(Rel("to_ptr"), RAX),
+
+		// Walk the frames, r12 points to the return address of each one
+		LEA(R12, Mem(RSP, 24)),
+		LABEL(GC_COLLECT + "_frame"),
+		MOV(RAX, Mem(R12, 0)),
+		LEA(R13, Rel("gc_maps")),
+		LABEL(GC_COLLECT + "_find"),
+		CMP(Mem(R13, 0).Qword(), Immediate(0)),
+		// A return address without a safepoint can't happen, stop walking
+		// rather than misread the stack
+		JE(GC_COLLECT + "_scan"),
+		CMP(RAX, Mem(R13, 0)),
+		JE(GC_COLLECT + "_found"),
+		ADD(R13, Immediate(32)),
+		JMP(GC_COLLECT + "_find"),
+		LABEL(GC_COLLECT + "_found"),
+		LEA(RBX, Mem(R12, 8)),
+		MOV(RAX, Mem(R13, 24)),
+		CMP(RAX, Immediate(0)),
+		JE(GC_COLLECT + "_next"),
+		CALL(RAX),
+		LABEL(GC_COLLECT + "_next"),
+		CMP(Mem(R13, 16).Qword(), Immediate(0)),
+		JNE(GC_COLLECT + "_scan"),
+		ADD(R12, Mem(R13, 8)),
+		ADD(R12, Immediate(8)),
+		JMP(GC_COLLECT + "_frame"),
+
+		// Trace the copied objects until there are no more, r14 is the next
+		// one to trace
+		LABEL(GC_COLLECT + "_scan"),
+		MOV(R14, Rel("to_start")),
+		LABEL(GC_COLLECT + "_object"),
+		CMP(R14, Rel("to_ptr")),
+		JAE(GC_COLLECT + "_done"),
+		MOV(RAX, Mem(R14, 8)),
+		CMP(RAX, Immediate(0)),
+		JE(GC_COLLECT + "_skip"),
+		LEA(RBX, Mem(R14, 16)),
+		MOV(RSI, Mem(R14, 0)),
+		SUB(RSI, Immediate(16)),
+		CALL(RAX),
+		LABEL(GC_COLLECT + "_skip"),
+		ADD(R14, Mem(R14, 0)),
+		JMP(GC_COLLECT + "_object"),
+
+		// The first call maps the first space, there was nothing to collect
+		LABEL(GC_COLLECT + "_done"),
+		MOV(RDI, Rel("heap_start")),
+		CMP(RDI, Immediate(0)),
+		JE(GC_COLLECT + "_swap"),
+		ADD(Rel("gc_collections").Qword(), Immediate(1)),
+		MOV(RAX, Rel("to_ptr")),
+		SUB(RAX, Rel("to_start")),
+		ADD(Rel("gc_copied"), RAX),
+		MOV(RAX, Immediate(0x2000049)), // munmap syscall
+		MOV(RSI, Rel("heap_end")),
+		SUB(RSI, RDI),
+		SYSCALL(),
+
+		LABEL(GC_COLLECT + "_swap"),
+		MOV(RAX, Rel("to_start")),
+		MOV(Rel("heap_start"), RAX),
+		MOV(RCX, Rel("to_ptr")),
+		MOV(Rel("heap_ptr"), RCX),
+		ADD(RAX, Rel("heap_size")),
+		MOV(Rel("heap_end"), RAX),
+
+		MOV(RAX, RCX),
+		SUB(RAX, Rel("heap_start")),
+		ADD(RAX, Mem(RSP, 16)),
+		ADD(RAX, RAX),
+		CMP(RAX, Rel("heap_size")),
+		JBE(GC_COLLECT + "_end"),
+		MOV(Rel("heap_size"), RAX),
+		LABEL(GC_COLLECT + "_end"),
+		RET(),
+	}...)
+	return output
+}
+
+/*
+Forwards the pointer at the address in rdi: objects in the old space are
+copied to the new one the first time they are seen, and the pointer is
+updated to the copy. Pointers anywhere else, including 0, are left alone.
+*/
+func forwardRoutine() []Instruction {
+	return []Instruction{
+		LABEL(GC_FORWARD),
+		MOV(RAX, Mem(RDI, 0)),
+		CMP(RAX, Rel("heap_start")),
+		JB(GC_FORWARD + "_done"),
+		CMP(RAX, Rel("heap_ptr")),
+		JAE(GC_FORWARD + "_done"),
+		CMP(Mem(RAX, -8).Qword(), Immediate(-1)),
+		JNE(GC_FORWARD + "_copy"),
+		MOV(RAX, Mem(RAX, -16)),
+		MOV(Mem(RDI, 0), RAX),
+		RET(),
+
+		LABEL(GC_FORWARD + "_copy"),
+		MOV(RCX, Mem(RAX, -16)),
+		MOV(RDX, Rel("to_ptr")),
+		MOV(R8, Immediate(0)),
+		LABEL(GC_FORWARD + "_word"),
+		MOV(R9, Indexed(RAX, R8, -16)),
+		MOV(Indexed(RDX, R8, 0), R9),
+		ADD(R8, Immediate(8)),
+		CMP(R8, RCX),
+		JB(GC_FORWARD + "_word"),
+		ADD(Rel("to_ptr"), RCX),
+		LEA(R9, Mem(RDX, 16)),
+		MOV(Mem(RAX, -16), R9),
+		MOV(Mem(RAX, -8).Qword(), Immediate(-1)),
+		MOV(Mem(RDI, 0), R9),
+		LABEL(GC_FORWARD + "_done"),
+		RET(),
+	}
+}
+
+// Maps a region of memory of the given size, leaving its address in rax
+func mmap(size Operand) []Instruction {
+	return []Instruction{
+		MOV(RAX, Immediate(0x20000C5)), // mmap syscall
+		MOV(RDI, Immediate(0)),         // anywhere
+		MOV(RSI, size),
+		MOV(RDX, Immediate(3)),      // PROT_READ | PROT_WRITE
+		MOV(R10, Immediate(0x1002)), // MAP_PRIVATE | MAP_ANON
+		MOV(R8, Immediate(-1)),      // no file
+		MOV(R9, Immediate(0)),
+		SYSCALL(),
+		JC(OUT_OF_MEMORY),
+	}
+}
+
+// The table of safepoints, four words each and terminated by a 0
+func (r *Runtime) gcMaps() []Instruction {
+	output := []Instruction{LABEL("gc_maps")}
+	for i, point := range r.safepoints {
+		last := Immediate(0)
+		if point.last {
+			last = Immediate(1)
+		}
+		output = append(output, DQ(fmt.Sprint("gc_map_", i), Label(point.label), Immediate(point.frameSize), last, point.trace))
+	}
+	return append(output, DQ("gc_maps_end", Immediate(0)))
+}
+
+// Writes the collector's counters to stderr, one per line
+func statsRoutine() []Instruction {
+	output := []Instruction{LABEL(GC_STATS)}
+	for _, counter := range []string{"gc_collections", "gc_allocated", "gc_copied"} {
+		output = append(output, writeStderr(counter+"_msg", len(counter)+2)...)
+		output = append(output, MOV(RAX, Rel(counter)))
+		output = append(output, CALL(Label(GC_STATS+"_number")))
+	}
+	output = append(output, RET())
+
+	// Writes rax in decimal followed by a newline, from the last digit backwards
+	return append(output, []Instruction{
+		LABEL(GC_STATS + "_number"),
+		LEA(RSI, Rel("gc_digits").Plus(20)),
+		MOV(Mem(RSI, 0).Byte(), Immediate(10)),
+		MOV(RCX, Immediate(10)),
+		LABEL(GC_STATS + "_digit"),
+		MOV(RDX, Immediate(0)),
+		DIV(RCX),
+		ADD(RDX, Immediate(48)),
+		SUB(RSI, Immediate(1)),
+		MOV(Mem(RSI, 0), DL),
+		CMP(RAX, Immediate(0)),
+		JNE(GC_STATS + "_digit"),
+		LEA(RDX, Rel("gc_digits").Plus(21)),
+		SUB(RDX, RSI),
+		MOV(RAX, Immediate(0x2000004)), // write syscall
+		MOV(RDI, Immediate(2)),         // stderr
+		SYSCALL(),
+		RET(),
+	}...)
+}
+
+func statsData() []Instruction {
+	output := []Instruction{}
+	for _, counter := range []string{"gc_collections", "gc_allocated", "gc_copied"} {
+		output = append(output, DB(counter+"_msg", String(counter+": ")))
+	}
+	// Room for the digits of any 64 bit number and a newline
+	return append(output, DQ("gc_digits", Immediate(0), Immediate(0), Immediate(0)))
+}
@@ -5,12 +5,323 @@ import (
 	"strings"
 )
 
+/*
+Instructions are kept structured until they are rendered, so passes like the
+peephole optimiser can look at their operands. Render writes them in NASM
+syntax.
+*/
 type Instruction struct {
-	Opcode   string
-	Args     []string
-	IsIndent bool
+	Opcode   Opcode
+	Operands []Operand
 }
 
+type Opcode int
+
+const (
+	// Directives, which are not indented
+	OP_SECTION Opcode = iota
+	OP_GLOBAL
+	// Defines a label, its operand is the Label
+	OP_LABEL
+	// Data, the first operand is the Label of the data
+	OP_DB
+	OP_DQ
+
+	OP_PUSH
+	OP_POP
+	OP_MOV
+	OP_MOVZX
+	OP_LEA
+	OP_ADD
+	OP_SUB
+	OP_IMUL
+	OP_AND
+	OP_DIV
+	OP_CMP
+	OP_JMP
+	OP_JE
+	OP_JNE
+	OP_JL
+	OP_JG
+	OP_JGE
+	OP_JA
+	OP_JAE
+	OP_JB
+	OP_JBE
+	OP_JC
+	OP_SETE
+	OP_SETNE
+	OP_SETL
+	OP_SETG
+	OP_SETGE
+	OP_SETA
+	OP_SETAE
+	OP_SETB
+	OP_SETBE
+	OP_SETC
+	OP_CALL
+	OP_RET
+	OP_SYSCALL
+)
+
+var MNEMONICS = []string{
+	OP_SECTION: "section",
+	OP_GLOBAL:  "global",
+	OP_LABEL:   "",
+	OP_DB:      "db",
+	OP_DQ:      "dq",
+	OP_PUSH:    "push",
+	OP_POP:     "pop",
+	OP_MOV:     "mov",
+	OP_MOVZX:   "movzx",
+	OP_LEA:     "lea",
+	OP_ADD:     "add",
+	OP_SUB:     "sub",
+	OP_IMUL:    "imul",
+	OP_AND:     "and",
+	OP_DIV:     "div",
+	OP_CMP:     "cmp",
+	OP_JMP:     "jmp",
+	OP_JE:      "je",
+	OP_JNE:     "jne",
+	OP_JL:      "jl",
+	OP_JG:      "jg",
+	OP_JGE:     "jge",
+	OP_JA:      "ja",
+	OP_JAE:     "jae",
+	OP_JB:      "jb",
+	OP_JBE:     "jbe",
+	OP_JC:      "jc",
+	OP_SETE:    "sete",
+	OP_SETNE:   "setne",
+	OP_SETL:    "setl",
+	OP_SETG:    "setg",
+	OP_SETGE:   "setge",
+	OP_SETA:    "seta",
+	OP_SETAE:   "setae",
+	OP_SETB:    "setb",
+	OP_SETBE:   "setbe",
+	OP_SETC:    "setc",
+	OP_CALL:    "call",
+	OP_RET:     "ret",
+	OP_SYSCALL: "syscall",
+}
+
+// The set instruction with the same condition as each conditional jump
+var SET_FOR_JUMP = map[Opcode]Opcode{
+	OP_JE:  OP_SETE,
+	OP_JNE: OP_SETNE,
+	OP_JL:  OP_SETL,
+	OP_JG:  OP_SETG,
+	OP_JGE: OP_SETGE,
+	OP_JA:  OP_SETA,
+	OP_JAE: OP_SETAE,
+	OP_JB:  OP_SETB,
+	OP_JBE: OP_SETBE,
+	OP_JC:  OP_SETC,
+}
+
+func (o Opcode) Render() string {
+	return MNEMONICS[o]
+}
+
+type Operand interface {
+	isOperand()
+	Render() string
+}
+
+type Register int
+
+const (
+	// Not a register, for the index of a Memory operand without one
+	NO_REGISTER Register = iota
+	RAX
+	RBX
+	RCX
+	RDX
+	RSI
+	RDI
+	RSP
+	RBP
+	R8
+	R9
+	R10
+	R11
+	R12
+	R13
+	R14
+	R15
+
+	// The lowest byte of the registers above
+	AL
+	BL
+	CL
+	DL
+	SIL
+	DIL
+	R8B
+	R9B
+	R10B
+	R11B
+	R12B
+	R13B
+	R14B
+	R15B
+)
+
+var REGISTER_NAMES = []string{
+	NO_REGISTER: "",
+	RAX:         "rax",
+	RBX:         "rbx",
+	RCX:         "rcx",
+	RDX:         "rdx",
+	RSI:         "rsi",
+	RDI:         "rdi",
+	RSP:         "rsp",
+	RBP:         "rbp",
+	R8:          "r8",
+	R9:          "r9",
+	R10:         "r10",
+	R11:         "r11",
+	R12:         "r12",
+	R13:         "r13",
+	R14:         "r14",
+	R15:         "r15",
+	AL:          "al",
+	BL:          "bl",
+	CL:          "cl",
+	DL:          "dl",
+	SIL:         "sil",
+	DIL:         "dil",
+	R8B:         "r8b",
+	R9B:         "r9b",
+	R10B:        "r10b",
+	R11B:        "r11b",
+	R12B:        "r12b",
+	R13B:        "r13b",
+	R14B:        "r14b",
+	R15B:        "r15b",
+}
+
+// Returns the lowest byte of a 64 bit register
+func (r Register) Low() (Register, bool) {
+	if r < RAX || r > R15 || r == RSP || r == RBP {
+		return NO_REGISTER, false
+	}
+	if r > RBP {
+		return r - R8 + R8B, true
+	}
+	return r - RAX + AL, true
+}
+
+func (r Register) Render() string {
+	return REGISTER_NAMES[r]
+}
+
+type Immediate int64
+
+func (i Immediate) Render() string {
+	return fmt.Sprint(int64(i))
+}
+
+type Size int
+
+const (
+	// The size is given by the other operand
+	UNSIZED Size = iota
+	BYTE
+	QWORD
+)
+
+/*
+An address of the form [base+index+offset], where the index is optional, or
+[rel label+offset] when the address is relative to a label.
+*/
+type Memory struct {
+	Size   Size
+	Base   Register
+	Index  Register
+	Offset int
+	Label  string
+}
+
+// [base+offset]
+func Mem(base Register, offset int) Memory {
+	return Memory{Base: base, Offset: offset}
+}
+
+// [base+index+offset]
+func Indexed(base Register, index Register, offset int) Memory {
+	return Memory{Base: base, Index: index, Offset: offset}
+}
+
+// [rel label]
+func Rel(label string) Memory {
+	return Memory{Label: label}
+}
+
+// The address a number of bytes higher
+func (m Memory) Plus(offset int) Memory {
+	m.Offset += offset
+	return m
+}
+
+// Memory operands need a size when nothing else gives one, e.g. when the
+// other operand is an immediate
+func (m Memory) Qword() Memory {
+	m.Size = QWORD
+	return m
+}
+
+func (m Memory) Byte() Memory {
+	m.Size = BYTE
+	return m
+}
+
+func (m Memory) Render() string {
+	var address strings.Builder
+	switch m.Size {
+	case BYTE:
+		address.WriteString("byte ")
+	case QWORD:
+		address.WriteString("qword ")
+	}
+	address.WriteString("[")
+	if m.Label != "" {
+		address.WriteString("rel " + m.Label)
+	} else {
+		address.WriteString(m.Base.Render())
+		if m.Index != NO_REGISTER {
+			address.WriteString("+" + m.Index.Render())
+		}
+	}
+	if m.Offset != 0 {
+		address.WriteString(fmt.Sprintf("%+d", m.Offset))
+	}
+	address.WriteString("]")
+	return address.String()
+}
+
+// The name of a label, to jump to or to use as an address
+type Label string
+
+func (l Label) Render() string {
+	return string(l)
+}
+
+// A string of bytes in data
+type String string
+
+func (s String) Render() string {
+	return fmt.Sprintf("%q", string(s))
+}
+
+func (Register) isOperand()  {}
+func (Immediate) isOperand() {}
+func (Memory) isOperand()    {}
+func (Label) isOperand()     {}
+func (String) isOperand()    {}
+
 var labelCounter = 0
 
 func genLabel() string {
@@ -22,15 +333,27 @@ func Render(instructions []Instruction) string {
 	var output strings.Builder
 
 	for _, instruction := range instructions {
-		args := strings.Join(instruction.Args, ", ")
+		operands := make([]string, len(instruction.Operands))
+		for i, operand := range instruction.Operands {
+			operands[i] = operand.Render()
+		}
 
-		if instruction.IsIndent {
+		switch instruction.Opcode {
+		case OP_SECTION, OP_GLOBAL:
+			output.WriteString(instruction.Opcode.Render())
+		case OP_LABEL:
+			output.WriteString(operands[0] + ":")
+			operands = operands[1:]
+		case OP_DB, OP_DQ:
+			output.WriteString(operands[0] + ": " + instruction.Opcode.Render())
+			operands = operands[1:]
+		default:
 			output.WriteString("\t")
+			output.WriteString(instruction.Opcode.Render())
 		}
 
-		output.WriteString(instruction.Opcode)
 		output.WriteString(" ")
-		output.WriteString(args)
+		output.WriteString(strings.Join(operands, ", "))
 		output.WriteString("\n")
 	}
 
@@ -39,96 +362,203 @@ func Render(instructions []Instruction) string {
 
 func SECTION(section string) Instruction {
 	return Instruction{
-		Opcode:   "section",
-		Args:     []string{section},
-		IsIndent: false,
+		Opcode:   OP_SECTION,
+		Operands: []Operand{Label(section)},
 	}
 }
 
 func GLOBAL(symbol string) Instruction {
 	return Instruction{
-		Opcode:   "global",
-		Args:     []string{symbol},
-		IsIndent: false,
+		Opcode:   OP_GLOBAL,
+		Operands: []Operand{Label(symbol)},
 	}
 }
 
 func LABEL(symbol string) Instruction {
 	return Instruction{
-		Opcode:   symbol + ":",
-		Args:     []string{},
-		IsIndent: false,
+		Opcode:   OP_LABEL,
+		Operands: []Operand{Label(symbol)},
 	}
 }
 
-func PUSH(address string) Instruction {
+// Defines bytes of data, which can be referred to by label
+func DB(label string, bytes ...Operand) Instruction {
 	return Instruction{
-		Opcode:   "push",
-		Args:     []string{address},
-		IsIndent: true,
+		Opcode:   OP_DB,
+		Operands: append([]Operand{Label(label)}, bytes...),
 	}
 }
 
-func MOV(destination string, source string) Instruction {
+// Defines 8 byte words of data, which can be referred to by label
+func DQ(label string, words ...Operand) Instruction {
 	return Instruction{
-		Opcode:   "mov",
-		Args:     []string{destination, source},
-		IsIndent: true,
+		Opcode:   OP_DQ,
+		Operands: append([]Operand{Label(label)}, words...),
 	}
 }
 
-func ADD(destination string, source string) Instruction {
+func PUSH(source Operand) Instruction {
 	return Instruction{
-		Opcode:   "add",
-		Args:     []string{destination, source},
-		IsIndent: true,
+		Opcode:   OP_PUSH,
+		Operands: []Operand{source},
 	}
 }
 
-func SUB(destination string, source string) Instruction {
+func POP(destination Operand) Instruction {
 	return Instruction{
-		Opcode:   "sub",
-		Args:     []string{destination, source},
-		IsIndent: true,
+		Opcode:   OP_POP,
+		Operands: []Operand{destination},
 	}
 }
 
-func CMP(destination string, source string) Instruction {
+func MOV(destination Operand, source Operand) Instruction {
 	return Instruction{
-		Opcode:   "cmp",
-		Args:     []string{destination, source},
-		IsIndent: true,
+		Opcode:   OP_MOV,
+		Operands: []Operand{destination, source},
 	}
 }
 
-func JL(label string) Instruction {
+func ADD(destination Operand, source Operand) Instruction {
 	return Instruction{
-		Opcode:   "jl",
-		Args:     []string{label},
-		IsIndent: true,
+		Opcode:   OP_ADD,
+		Operands: []Operand{destination, source},
 	}
 }
 
-func JMP(label string) Instruction {
+func SUB(destination Operand, source Operand) Instruction {
 	return Instruction{
-		Opcode:   "jmp",
-		Args:     []string{label},
-		IsIndent: true,
+		Opcode:   OP_SUB,
+		Operands: []Operand{destination, source},
 	}
 }
 
+func IMUL(destination Register, source Operand, factor Immediate) Instruction {
+	return Instruction{
+		Opcode:   OP_IMUL,
+		Operands: []Operand{destination, source, factor},
+	}
+}
+
+func AND(destination Operand, source Operand) Instruction {
+	return Instruction{
+		Opcode:   OP_AND,
+		Operands: []Operand{destination, source},
+	}
+}
+
+// Divides rdx:rax by the operand, leaving the quotient in rax and the
+// remainder in rdx
+func DIV(source Operand) Instruction {
+	return Instruction{
+		Opcode:   OP_DIV,
+		Operands: []Operand{source},
+	}
+}
+
+func CMP(destination Operand, source Operand) Instruction {
+	return Instruction{
+		Opcode:   OP_CMP,
+		Operands: []Operand{destination, source},
+	}
+}
+
+func jump(opcode Opcode, label string) Instruction {
+	return Instruction{
+		Opcode:   opcode,
+		Operands: []Operand{Label(label)},
+	}
+}
+
+func JL(label string) Instruction {
+	return jump(OP_JL, label)
+}
+
+func JMP(label string) Instruction {
+	return jump(OP_JMP, label)
+}
+
+func JE(label string) Instruction {
+	return jump(OP_JE, label)
+}
+
+func JNE(label string) Instruction {
+	return jump(OP_JNE, label)
+}
+
+func JGE(label string) Instruction {
+	return jump(OP_JGE, label)
+}
+
+// Unsigned comparison, so negative numbers are above any positive number
+func JAE(label string) Instruction {
+	return jump(OP_JAE, label)
+}
+
+// Unsigned comparison
+func JA(label string) Instruction {
+	return jump(OP_JA, label)
+}
+
+// Unsigned comparison
+func JB(label string) Instruction {
+	return jump(OP_JB, label)
+}
+
+// Unsigned comparison
+func JBE(label string) Instruction {
+	return jump(OP_JBE, label)
+}
+
+// Jumps if the carry flag is set, which is how syscalls report errors
+func JC(label string) Instruction {
+	return jump(OP_JC, label)
+}
+
 func JG(label string) Instruction {
+	return jump(OP_JG, label)
+}
+
+// Sets a byte register to 1 if the condition of a conditional jump holds and
+// to 0 otherwise, e.g. SET(OP_JL, AL) is setl al
+func SET(jump Opcode, destination Register) Instruction {
+	return Instruction{
+		Opcode:   SET_FOR_JUMP[jump],
+		Operands: []Operand{destination},
+	}
+}
+
+// Moves a byte into a larger register, setting the rest of it to 0
+func MOVZX(destination Register, source Operand) Instruction {
+	return Instruction{
+		Opcode:   OP_MOVZX,
+		Operands: []Operand{destination, source},
+	}
+}
+
+func LEA(destination Register, source Memory) Instruction {
+	return Instruction{
+		Opcode:   OP_LEA,
+		Operands: []Operand{destination, source},
+	}
+}
+
+func CALL(target Operand) Instruction {
+	return Instruction{
+		Opcode:   OP_CALL,
+		Operands: []Operand{target},
+	}
+}
+
+func RET() Instruction {
 	return Instruction{
-		Opcode:   "jg",
-		Args:     []string{label},
-		IsIndent: true,
+		Opcode:   OP_RET,
+		Operands: []Operand{},
 	}
 }
 
 func SYSCALL() Instruction {
 	return Instruction{
-		Opcode:   "syscall",
-		Args:     []string{},
-		IsIndent: true,
+		Opcode:   OP_SYSCALL,
+		Operands: []Operand{},
 	}
 }
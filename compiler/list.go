@@ -0,0 +1,248 @@
+package compiler
+
+import (
+	"errors"
+	"monkey/parser"
+)
+
+/*
+A list is the address of a header on the heap, so copies of a list share
+their elements. The elements are in a separate buffer on the heap, which is
+replaced by one twice the size when it is full:
+
+	[0]                   number of elements
+	[8]                   number of elements the buffer has room for
+	[16]                  address of the buffer
+*/
+var listHeader = T_STRUCT("list header", []Field{
+	{Name: "len", Tipe: T_INT},
+	{Name: "cap", Tipe: T_INT},
+	{Name: "data", Tipe: Tipe{Name: "buffer", Size: 8, Pointer: true}},
+})
+
+// The room in the buffer of a list that is pushed to when it has none
+const LIST_MIN_CAP = 4
+
+// The elements are pushed from last to first, then moved into a buffer that
+// is just large enough for them
+func compileListExpression(expression *parser.ListExpr, env *Env) ([]Instruction, Tipe, error) {
+	tipe := env.tipeOf(expression)
+	elem := *tipe.Elem
+	output := []Instruction{}
+	tmpEnv := env
+
+	for i := len(expression.Elements) - 1; i >= 0; i-- {
+		element, elemTipe, err := compilePushed(expression.Elements[i], tmpEnv)
+		if err != nil {
+			return []Instruction{}, T_NEVER(0), err
+		}
+		output = append(output, element...)
+		tmpEnv = tmpEnv.addTemp(elemTipe)
+	}
+
+	length := len(expression.Elements)
+	output = append(output, MOV(RAX, Immediate(length*elem.Size)))
+	output = append(output, env.runtime.alloc(elem, tmpEnv)...)
+	output = append(output, PUSH(RAX))
+	bufferEnv := tmpEnv.addTemp(listHeader.field("data").Tipe)
+
+	output = append(output, MOV(RAX, Immediate(listHeader.Size)))
+	output = append(output, env.runtime.alloc(listHeader, bufferEnv)...)
+	output = append(output, []Instruction{
+		MOV(RDX, RAX),
+		POP(RCX),
+		MOV(Mem(RDX, 0).Qword(), Immediate(length)),
+		MOV(Mem(RDX, 8).Qword(), Immediate(length)),
+		MOV(Mem(RDX, 16), RCX),
+	}...)
+	output = append(output, copyWords(length*elem.Size, Mem(RSP, 0), Mem(RCX, 0))...)
+	output = append(output, pop(length*elem.Size)...)
+	return append(output, MOV(RAX, RDX)), tipe, nil
+}
+
+// Calls to builtins are compiled in place, they are not functions
+func compileBuiltinCall(expression *parser.CallExpr, env *Env) ([]Instruction, Tipe, error) {
+	switch env.info.Builtins[expression] {
+	case "len":
+		output, _, err := compileExpression(expression.Arguments[0], env)
+		if err != nil {
+			return []Instruction{}, T_NEVER(0), err
+		}
+		return append(output, MOV(RAX, Mem(RAX, 0))), T_INT, nil
+	case "push":
+		return compileListPush(expression.Arguments[0], expression.Arguments[1], env)
+	}
+	return []Instruction{}, T_NEVER(0), errors.New("unexpected builtin")
+}
+
+/*
+Appends a value to a list and leaves the new length in rax. The list and the
+value are kept on the stack, so the garbage collector can find them if the
+buffer has to grow:
+
+	[rsp]                 the value
+	[rsp+size]            the list
+*/
+func compileListPush(list parser.Expression, value parser.Expression, env *Env) ([]Instruction, Tipe, error) {
+	output, listTipe, err := compileExpression(list, env)
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+	output = append(output, PUSH(RAX))
+	listEnv := env.addTemp(listTipe)
+
+	pushed, tipe, err := compilePushed(value, listEnv)
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+	output = append(output, pushed...)
+	valueEnv := listEnv.addTemp(tipe)
+
+	grow := genLabel()
+	loop := genLabel()
+	copied := genLabel()
+	store := genLabel()
+
+	output = append(output, []Instruction{
+		MOV(RAX, Mem(RSP, tipe.Size)),
+		MOV(RCX, Mem(RAX, 0)),
+		CMP(RCX, Mem(RAX, 8)),
+		JL(store),
+
+		// The buffer is full, replace it with one twice the size
+		MOV(RAX, Mem(RAX, 8)),
+		ADD(RAX, RAX),
+		CMP(RAX, Immediate(LIST_MIN_CAP)),
+		JGE(grow),
+		MOV(RAX, Immediate(LIST_MIN_CAP)),
+		LABEL(grow),
+		PUSH(RAX),
+		IMUL(RAX, RAX, Immediate(tipe.Size)),
+	}...)
+	output = append(output, env.runtime.alloc(tipe, valueEnv.addTemp(T_INT))...)
+
+	// The list may have been moved by the allocation, so it is loaded again
+	output = append(output, []Instruction{
+		MOV(RDX, Mem(RSP, tipe.Size+8)),
+		MOV(RSI, Mem(RDX, 16)),
+		MOV(RCX, Mem(RDX, 0)),
+		IMUL(RCX, RCX, Immediate(tipe.Size)),
+		LABEL(loop),
+		CMP(RCX, Immediate(0)),
+		JE(copied),
+		SUB(RCX, Immediate(8)),
+		MOV(R8, Indexed(RSI, RCX, 0)),
+		MOV(Indexed(RAX, RCX, 0), R8),
+		JMP(loop),
+		LABEL(copied),
+		MOV(Mem(RDX, 16), RAX),
+		POP(RCX),
+		MOV(Mem(RDX, 8), RCX),
+
+		LABEL(store),
+		MOV(RDX, Mem(RSP, tipe.Size)),
+		MOV(RCX, Mem(RDX, 0)),
+		IMUL(RCX, RCX, Immediate(tipe.Size)),
+		ADD(RCX, Mem(RDX, 16)),
+	}...)
+	output = append(output, copyWords(tipe.Size, Mem(RSP, 0), Mem(RCX, 0))...)
+	output = append(output, []Instruction{
+		ADD(Mem(RDX, 0).Qword(), Immediate(1)),
+		MOV(RAX, Mem(RDX, 0)),
+	}...)
+	return append(output, pop(tipe.Size+8)...), T_INT, nil
+}
+
+// The list is kept on the stack while the index is evaluated
+func compileListIndexExpression(expression *parser.IndexExpr, env *Env) ([]Instruction, Tipe, error) {
+	output, listTipe, err := compileExpression(expression.Array, env)
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+	output = append(output, PUSH(RAX))
+
+	index, err := compileListIndex(expression.Index, listTipe, env.addTemp(listTipe))
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+	output = append(output, index...)
+	output = append(output, []Instruction{
+		POP(RCX),
+		MOV(RCX, Mem(RCX, 16)),
+		ADD(RCX, RAX),
+	}...)
+
+	elem := *listTipe.Elem
+	if elem.InRegister() {
+		return append(output, MOV(RAX, Mem(RCX, 0))), elem, nil
+	}
+	for offset := elem.Size - 8; offset >= 0; offset -= 8 {
+		output = append(output, PUSH(Mem(RCX, offset).Qword()))
+	}
+	return output, elem, nil
+}
+
+// Evaluates an index into a list on the top of the stack and checks it is in
+// bounds, then scales it to the byte offset of the element in the buffer
+func compileListIndex(index parser.Expression, listTipe Tipe, env *Env) ([]Instruction, error) {
+	output, _, err := compileExpression(index, env)
+	if err != nil {
+		return []Instruction{}, err
+	}
+	output = append(output, MOV(RCX, Mem(RSP, 0)))
+	output = append(output, env.runtime.boundsCheck(Mem(RCX, 0))...)
+	return append(output, IMUL(RAX, RAX, Immediate(listTipe.Elem.Size))), nil
+}
+
+/*
+The list and the index of the next element live on the stack for the
+duration of the loop, and each element is pushed as the loop variable:
+
+	[rsp]                 index of the next element
+	[rsp+8]               the list
+*/
+func compileListForStmt(statement *parser.ForStmt, env *Env) ([]Instruction, *Env, error) {
+	start := genLabel()
+	next := genLabel()
+	end := genLabel()
+
+	output, listTipe, err := compileExpression(statement.From, env)
+	if err != nil {
+		return []Instruction{}, env, err
+	}
+	output = append(output, PUSH(RAX), PUSH(Immediate(0)))
+	loopEnv := env.addTemp(listTipe).addTemp(T_INT)
+
+	elem := *listTipe.Elem
+	output = append(output, []Instruction{
+		LABEL(start),
+		MOV(RAX, Mem(RSP, 8)),
+		MOV(RCX, Mem(RSP, 0)),
+		CMP(RCX, Mem(RAX, 0)),
+		JGE(end),
+		IMUL(RCX, RCX, Immediate(elem.Size)),
+		ADD(RCX, Mem(RAX, 16)),
+	}...)
+	for offset := elem.Size - 8; offset >= 0; offset -= 8 {
+		output = append(output, PUSH(Mem(RCX, offset).Qword()))
+	}
+
+	// The loop variable is popped by continue and break along with the
+	// other locals of the iteration
+	bodyEnv := loopEnv.enterLoop(next, end).addBinding(env.info.Defs[statement], elem)
+	body, err := compileStatements(statement.Body, bodyEnv)
+	if err != nil {
+		return []Instruction{}, env, err
+	}
+	output = append(output, body...)
+	output = append(output, pop(elem.Size)...)
+	output = append(output, []Instruction{
+		LABEL(next),
+		ADD(Mem(RSP, 0).Qword(), Immediate(1)),
+		JMP(start),
+		LABEL(end),
+		ADD(RSP, Immediate(16)),
+	}...)
+
+	return output, env, nil
+}
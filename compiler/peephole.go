@@ -0,0 +1,148 @@
+package compiler
+
+/*
+A peephole optimiser, which looks at a few instructions at a time and
+replaces them with cheaper ones that do the same. It runs on the output of
+Compile, so it sees the runtime routines as well as the program.
+
+A rule never looks across a label, except for the labels it matches itself,
+since the code after a label can be reached from elsewhere. The labels of
+the comparison pattern are only ever jumped to from inside the pattern.
+*/
+
+type peepholeRule struct {
+	name string
+	// The number of instructions the rule looks at
+	size int
+	// Returns what to replace the instructions with, or false if the rule
+	// doesn't apply to them
+	rewrite func(window []Instruction) ([]Instruction, bool)
+}
+
+var PEEPHOLE_RULES = []peepholeRule{
+	// push rax, pop rbx, which is removed entirely when both are the same
+	{"push-pop-move", 2, func(w []Instruction) ([]Instruction, bool) {
+		if w[0].Opcode == OP_PUSH && w[1].Opcode == OP_POP && isRegister(w[0].Operands[0]) && isRegister(w[1].Operands[0]) {
+			return []Instruction{MOV(w[1].Operands[0], w[0].Operands[0])}, true
+		}
+		return nil, false
+	}},
+	// push rax, mov rax, [rsp]
+	{"push-reload", 2, func(w []Instruction) ([]Instruction, bool) {
+		if w[0].Opcode == OP_PUSH && isRegister(w[0].Operands[0]) && w[1].Opcode == OP_MOV &&
+			w[1].Operands[0] == w[0].Operands[0] && sameAddress(w[1].Operands[1], Mem(RSP, 0)) {
+			return w[:1], true
+		}
+		return nil, false
+	}},
+	// mov [rsp+8], rax, mov rax, [rsp+8]
+	{"store-reload", 2, func(w []Instruction) ([]Instruction, bool) {
+		if w[0].Opcode == OP_MOV && w[1].Opcode == OP_MOV && isRegister(w[0].Operands[1]) &&
+			sameAddress(w[0].Operands[0], w[1].Operands[1]) && w[0].Operands[1] == w[1].Operands[0] {
+			return w[:1], true
+		}
+		return nil, false
+	}},
+	// mov rax, rax
+	{"move-to-itself", 1, func(w []Instruction) ([]Instruction, bool) {
+		if w[0].Opcode == OP_MOV && w[0].Operands[0] == w[0].Operands[1] {
+			return []Instruction{}, true
+		}
+		return nil, false
+	}},
+	// add rsp, 8, push rax
+	{"pop-push", 2, func(w []Instruction) ([]Instruction, bool) {
+		if w[0].Opcode == OP_ADD && w[0].Operands[0] == RSP && w[0].Operands[1] == Immediate(8) &&
+			w[1].Opcode == OP_PUSH && isRegister(w[1].Operands[0]) {
+			return []Instruction{MOV(Mem(RSP, 0), w[1].Operands[0])}, true
+		}
+		return nil, false
+	}},
+	// add rsp, 8, add rsp, 16
+	{"merge-stack-adjustments", 2, func(w []Instruction) ([]Instruction, bool) {
+		if w[0].Opcode == OP_ADD && w[1].Opcode == OP_ADD && w[0].Operands[0] == RSP && w[1].Operands[0] == RSP {
+			first, firstOk := w[0].Operands[1].(Immediate)
+			second, secondOk := w[1].Operands[1].(Immediate)
+			if firstOk && secondOk {
+				return []Instruction{ADD(RSP, first+second)}, true
+			}
+		}
+		return nil, false
+	}},
+	// jmp label_1, label_1:
+	{"jump-to-next", 2, func(w []Instruction) ([]Instruction, bool) {
+		if w[0].Opcode == OP_JMP && w[1].Opcode == OP_LABEL && w[0].Operands[0] == w[1].Operands[0] {
+			return w[1:], true
+		}
+		return nil, false
+	}},
+	// cmp rax, rbx, jl label_1, mov rax, 0, jmp label_2, label_1:, mov rax, 1, label_2:
+	{"compare-to-set", 7, func(w []Instruction) ([]Instruction, bool) {
+		_, conditional := SET_FOR_JUMP[w[1].Opcode]
+		if w[0].Opcode != OP_CMP || !conditional || w[4].Opcode != OP_LABEL || w[1].Operands[0] != w[4].Operands[0] {
+			return nil, false
+		}
+		if w[2].Opcode != OP_MOV || w[3].Opcode != OP_JMP || w[5].Opcode != OP_MOV ||
+			w[6].Opcode != OP_LABEL || w[3].Operands[0] != w[6].Operands[0] {
+			return nil, false
+		}
+		destination, ok := w[2].Operands[0].(Register)
+		if !ok || w[5].Operands[0] != destination || w[2].Operands[1] != Immediate(0) || w[5].Operands[1] != Immediate(1) {
+			return nil, false
+		}
+		low, ok := destination.Low()
+		if !ok {
+			return nil, false
+		}
+		// The destination can't be set before the comparison, as it might
+		// be one of its operands
+		return []Instruction{w[0], SET(w[1].Opcode, low), MOVZX(destination, low)}, true
+	}},
+}
+
+// Rewrites the instructions with PEEPHOLE_RULES until none of them apply
+func Peephole(instructions []Instruction) []Instruction {
+	for changed := true; changed; {
+		changed = false
+		output := make([]Instruction, 0, len(instructions))
+		for i := 0; i < len(instructions); {
+			rewritten, size, ok := applyRules(instructions[i:])
+			if ok {
+				output = append(output, rewritten...)
+				i += size
+				changed = true
+			} else {
+				output = append(output, instructions[i])
+				i++
+			}
+		}
+		instructions = output
+	}
+	return instructions
+}
+
+// Applies the first rule that matches the start of the instructions, and
+// returns how many instructions it replaced
+func applyRules(instructions []Instruction) ([]Instruction, int, bool) {
+	for _, rule := range PEEPHOLE_RULES {
+		if rule.size > len(instructions) {
+			continue
+		}
+		if rewritten, ok := rule.rewrite(instructions[:rule.size]); ok {
+			return rewritten, rule.size, true
+		}
+	}
+	return nil, 0, false
+}
+
+func isRegister(operand Operand) bool {
+	_, ok := operand.(Register)
+	return ok
+}
+
+// Whether two operands are the same memory address, whatever their sizes
+func sameAddress(first Operand, second Operand) bool {
+	firstMemory, firstOk := first.(Memory)
+	secondMemory, secondOk := second.(Memory)
+	return firstOk && secondOk && firstMemory.Qword() == secondMemory.Qword()
+}
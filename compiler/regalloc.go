@@ -0,0 +1,127 @@
+package compiler
+
+import (
+	"monkey/ir"
+	"sort"
+)
+
+/*
+Linear scan register allocation for functions lowered to the IR. The
+intervals of the registers of a function are visited in the order they start,
+and each one takes a free machine register. When none is free, whichever of
+the intervals that hold one ends last is spilled to a stack slot.
+
+Every function is free to use the caller saved registers, so a value that is
+live across a call can only be kept in a callee saved register. Functions
+save the callee saved registers they use in their frame, and restore them
+before they return.
+
+rax is never allocated. It holds the callee and the result of a call, and is
+the scratch register for instructions whose operands are on the stack.
+*/
+
+var CALLER_SAVED = []Register{RCX, RDX, RSI, RDI, R8, R9, R10, R11}
+var CALLEE_SAVED = []Register{RBX, R12, R13, R14, R15}
+
+// Where the registers of a function live
+type allocation struct {
+	// The machine register or stack slot of each register of the function
+	locations []Operand
+
+	// The number of stack slots below rbp, for spilled registers
+	slots int
+
+	// The callee saved registers the function writes to
+	saved []Register
+}
+
+func isCalleeSaved(location Operand) bool {
+	for _, saved := range CALLEE_SAVED {
+		if saved == location {
+			return true
+		}
+	}
+	return false
+}
+
+// Parameters that are spilled stay where the caller pushed them
+func allocateRegisters(fn *ir.Function) allocation {
+	a := allocation{locations: make([]Operand, len(fn.Registers))}
+	incoming := map[ir.Register]Memory{}
+	for i, param := range fn.Params {
+		incoming[param] = Mem(RBP, 16+8*(len(fn.Params)-1-i))
+	}
+	spill := func(register ir.Register) {
+		if address, ok := incoming[register]; ok {
+			a.locations[register] = address
+			return
+		}
+		a.slots++
+		a.locations[register] = Mem(RBP, -8*a.slots)
+	}
+
+	free := map[Register]bool{}
+	for _, register := range append(append([]Register{}, CALLER_SAVED...), CALLEE_SAVED...) {
+		free[register] = true
+	}
+	// Takes the first free register of a kind
+	take := func(registers []Register) (Register, bool) {
+		for _, register := range registers {
+			if free[register] {
+				free[register] = false
+				return register, true
+			}
+		}
+		return NO_REGISTER, false
+	}
+
+	// The intervals that hold a machine register, ordered by where they end
+	active := []ir.Interval{}
+	for _, interval := range ir.Intervals(fn) {
+		for len(active) > 0 && active[0].End < interval.Start {
+			free[a.locations[active[0].Register].(Register)] = true
+			active = active[1:]
+		}
+
+		register, ok := NO_REGISTER, false
+		if !interval.CrossesCall {
+			register, ok = take(CALLER_SAVED)
+		}
+		if !ok {
+			register, ok = take(CALLEE_SAVED)
+		}
+
+		if !ok {
+			// Take the register of the active interval that ends last, if it
+			// ends after this one and could hold this one
+			victim := -1
+			for i := len(active) - 1; i >= 0; i-- {
+				if !interval.CrossesCall || isCalleeSaved(a.locations[active[i].Register]) {
+					victim = i
+					break
+				}
+			}
+			if victim < 0 || active[victim].End <= interval.End {
+				spill(interval.Register)
+				continue
+			}
+			register = a.locations[active[victim].Register].(Register)
+			spill(active[victim].Register)
+			active = append(active[:victim], active[victim+1:]...)
+		}
+
+		a.locations[interval.Register] = register
+		active = append(active, interval)
+		sort.SliceStable(active, func(i, j int) bool { return active[i].End < active[j].End })
+	}
+
+	for _, register := range CALLEE_SAVED {
+		for _, location := range a.locations {
+			if location == register {
+				a.saved = append(a.saved, register)
+				break
+			}
+		}
+	}
+	return a
+}
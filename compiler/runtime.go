@@ -0,0 +1,102 @@
+package compiler
+
+// The exit code of a program that indexed an array out of bounds
+const EXIT_OUT_OF_BOUNDS = 101
+
+// The exit code of a program that could not get memory for the heap
+const EXIT_OUT_OF_MEMORY = 102
+
+const BOUNDS_ERROR = "bounds_error"
+const OUT_OF_MEMORY = "out_of_memory"
+const ALLOC = "alloc"
+
+// Routines the generated code jumps to. Each one is only emitted if the
+// program uses it.
+type Runtime struct {
+	boundsError bool
+	heap        bool
+
+	// Whether the program writes the collector's counters to stderr at exit
+	gcStats bool
+
+	// Every call site, and the routines the garbage collector uses to find
+	// pointers, keyed by their code
+	safepoints []safepoint
+	traces     map[string]string
+	traceCode  []Instruction
+}
+
+// Jumps to the bounds error routine unless 0 <= rax < length, where length
+// is an operand like Immediate(5) or Mem(RCX, 0)
+func (r *Runtime) boundsCheck(length Operand) []Instruction {
+	r.boundsError = true
+	return []Instruction{
+		CMP(RAX, length),
+		JAE(BOUNDS_ERROR),
+	}
+}
+
+func (r *Runtime) Instructions() []Instruction {
+	var text, data []Instruction
+
+	if r.boundsError {
+		message := "index out of bounds"
+		text = append(text, LABEL(BOUNDS_ERROR))
+		text = append(text, writeStderr(BOUNDS_ERROR+"_msg", len(message)+1)...)
+		text = append(text, exit(EXIT_OUT_OF_BOUNDS)...)
+		data = append(data, DB(BOUNDS_ERROR+"_msg", String(message), Immediate(10)))
+	}
+
+	if r.heap {
+		message := "out of memory"
+		text = append(text, allocRoutine()...)
+		text = append(text, collectRoutine()...)
+		text = append(text, forwardRoutine()...)
+		text = append(text, r.traceCode...)
+		text = append(text, LABEL(OUT_OF_MEMORY))
+		text = append(text, writeStderr(OUT_OF_MEMORY+"_msg", len(message)+1)...)
+		text = append(text, exit(EXIT_OUT_OF_MEMORY)...)
+		data = append(data, DB(OUT_OF_MEMORY+"_msg", String(message), Immediate(10)))
+		data = append(data, []Instruction{
+			DQ("heap_start", Immediate(0)),
+			DQ("heap_ptr", Immediate(0)),
+			DQ("heap_end", Immediate(0)),
+			DQ("heap_size", Immediate(HEAP_INITIAL_SIZE)),
+			DQ("to_start", Immediate(0)),
+			DQ("to_ptr", Immediate(0)),
+		}...)
+		data = append(data, r.gcMaps()...)
+	}
+
+	if r.heap || r.gcStats {
+		data = append(data, DQ("gc_collections", Immediate(0)), DQ("gc_allocated", Immediate(0)), DQ("gc_copied", Immediate(0)))
+	}
+	if r.gcStats {
+		text = append(text, statsRoutine()...)
+		data = append(data, statsData()...)
+	}
+
+	if len(data) > 0 {
+		text = append(text, SECTION(".data"))
+		text = append(text, data...)
+	}
+	return text
+}
+
+func writeStderr(message string, length int) []Instruction {
+	return []Instruction{
+		MOV(RAX, Immediate(0x2000004)), // write syscall
+		MOV(RDI, Immediate(2)),         // stderr
+		LEA(RSI, Rel(message)),
+		MOV(RDX, Immediate(length)),
+		SYSCALL(),
+	}
+}
+
+func exit(code int) []Instruction {
+	return []Instruction{
+		MOV(RAX, Immediate(0x2000001)), // exit syscall
+		MOV(RDI, Immediate(code)),
+		SYSCALL(),
+	}
+}
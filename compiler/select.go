@@ -0,0 +1,240 @@
+package compiler
+
+import (
+	"fmt"
+	"monkey/ir"
+)
+
+/*
+Instruction selection for programs lowered to the IR. The registers of each
+function are given machine registers by allocateRegisters, and the ones that
+are spilled live in the frame of the function, which rbp points to:
+
+	[rbp+16+8*(n-1-i)]    argument i of n, pushed by the caller in order
+	[rbp+8]               return address
+	[rbp]                 rbp of the caller
+	[rbp-8*(j+1)]         spilled register j
+	...                   callee saved registers the function uses
+
+Calls use the same convention as the rest of the compiler: the arguments are
+pushed in order, the callee is called through rax and the result is returned
+in rax.
+
+IR programs only hold words, and nothing is ever allocated on the heap, so
+the garbage collector never has to walk their frames.
+*/
+
+// Returns the top level of the program, which leaves its status in rax, and
+// the functions, which have to be placed after the exit of the program
+func selectProgram(program *ir.Program) ([]Instruction, []Instruction) {
+	labels := make([]string, len(program.Functions))
+	for i := range program.Functions {
+		labels[i] = genLabel()
+	}
+
+	topLevel := selectFunction(program.Functions[0], labels, false)
+	functions := []Instruction{}
+	for i := 1; i < len(program.Functions); i++ {
+		functions = append(functions, LABEL(labels[i]))
+		functions = append(functions, selectFunction(program.Functions[i], labels, true)...)
+	}
+	return topLevel, functions
+}
+
+type selector struct {
+	fn *ir.Function
+
+	// The labels of the functions of the program, and of the blocks of this one
+	functions []string
+	blocks    []string
+
+	// Where the status of the program is left, if the exit is not the last block
+	exit string
+	// The location of each register
+	locations []Operand
+	// The callee saved registers to restore on return, and where they are saved
+	saved []Instruction
+}
+
+// The top level never returns, so it doesn't have to save any registers
+func selectFunction(fn *ir.Function, functions []string, returns bool) []Instruction {
+	allocation := allocateRegisters(fn)
+	if !returns {
+		allocation.saved = []Register{}
+	}
+	s := &selector{fn: fn, functions: functions, locations: allocation.locations}
+
+	for range fn.Blocks {
+		s.blocks = append(s.blocks, genLabel())
+	}
+
+	output := []Instruction{
+		PUSH(RBP),
+		MOV(RBP, RSP),
+	}
+	if size := 8 * (allocation.slots + len(allocation.saved)); size > 0 {
+		output = append(output, SUB(RSP, Immediate(size)))
+	}
+	for i, register := range allocation.saved {
+		slot := Mem(RBP, -8*(allocation.slots+i+1))
+		output = append(output, MOV(slot, register))
+		s.saved = append(s.saved, MOV(register, slot))
+	}
+	for i, param := range fn.Params {
+		output = append(output, move(s.locations[param], Mem(RBP, 16+8*(len(fn.Params)-1-i)))...)
+	}
+
+	for _, block := range fn.Blocks {
+		if block.Id > 0 {
+			output = append(output, LABEL(s.blocks[block.Id]))
+		}
+		for _, instruction := range block.Instructions {
+			output = append(output, s.selectInstruction(instruction)...)
+		}
+		output = append(output, s.selectTerminator(block)...)
+	}
+	if s.exit != "" {
+		output = append(output, LABEL(s.exit))
+	}
+	return output
+}
+
+func isMemory(location Operand) bool {
+	_, ok := location.(Memory)
+	return ok
+}
+
+// Gives a stack slot a size, for instructions where nothing else does
+func sized(location Operand) Operand {
+	if memory, ok := location.(Memory); ok {
+		return memory.Qword()
+	}
+	return location
+}
+
+// Moves a word between two locations, through rax if both are in memory
+func move(destination Operand, source Operand) []Instruction {
+	if destination == source {
+		return []Instruction{}
+	}
+	if isMemory(destination) && isMemory(source) {
+		return []Instruction{MOV(RAX, source), MOV(destination, RAX)}
+	}
+	return []Instruction{MOV(destination, source)}
+}
+
+func (s *selector) selectInstruction(instruction ir.Instruction) []Instruction {
+	switch instruction := instruction.(type) {
+	case *ir.Const:
+		dest := s.locations[instruction.Dest]
+		// Only registers can be set to a 64 bit immediate
+		if isMemory(dest) && int64(int32(instruction.Value)) != instruction.Value {
+			return []Instruction{MOV(RAX, Immediate(instruction.Value)), MOV(dest, RAX)}
+		}
+		return []Instruction{MOV(sized(dest), Immediate(instruction.Value))}
+	case *ir.Copy:
+		return move(s.locations[instruction.Dest], s.locations[instruction.Src])
+	case *ir.Binary:
+		return s.selectBinary(instruction)
+	case *ir.FunctionAddress:
+		address := Rel(s.functions[instruction.Function])
+		if dest, ok := s.locations[instruction.Dest].(Register); ok {
+			return []Instruction{LEA(dest, address)}
+		}
+		return []Instruction{LEA(RAX, address), MOV(s.locations[instruction.Dest], RAX)}
+	case *ir.Call:
+		output := []Instruction{}
+		for _, argument := range instruction.Arguments {
+			output = append(output, PUSH(sized(s.locations[argument])))
+		}
+		output = append(output, move(RAX, s.locations[instruction.Callee])...)
+		output = append(output, CALL(RAX))
+		output = append(output, pop(8*len(instruction.Arguments))...)
+		return append(output, move(s.locations[instruction.Dest], RAX)...)
+	}
+	panic(fmt.Sprintf("unexpected IR instruction %T", instruction))
+}
+
+func (s *selector) selectBinary(instruction *ir.Binary) []Instruction {
+	dest := s.locations[instruction.Dest]
+	lhs := s.locations[instruction.Lhs]
+	rhs := s.locations[instruction.Rhs]
+
+	switch instruction.Op {
+	case ir.Add, ir.Sub:
+		operation := ADD
+		if instruction.Op == ir.Sub {
+			operation = SUB
+		}
+		if !isMemory(dest) && dest != rhs {
+			return append(move(dest, lhs), operation(dest, rhs))
+		}
+		if instruction.Op == ir.Add && !isMemory(dest) {
+			// dest is rhs, and the operands of an addition can be swapped
+			return []Instruction{operation(dest, lhs)}
+		}
+		return []Instruction{
+			MOV(RAX, lhs),
+			operation(RAX, rhs),
+			MOV(dest, RAX),
+		}
+	}
+
+	output := []Instruction{}
+	if isMemory(lhs) && isMemory(rhs) {
+		output = append(output, MOV(RAX, lhs))
+		lhs = RAX
+	}
+	jump := JL
+	if instruction.Op == ir.GreaterThan {
+		jump = JG
+	}
+	ifTrue := genLabel()
+	done := genLabel()
+	return append(output, []Instruction{
+		CMP(lhs, rhs),
+		jump(ifTrue),
+		MOV(sized(dest), Immediate(0)),
+		JMP(done),
+		LABEL(ifTrue),
+		MOV(sized(dest), Immediate(1)),
+		LABEL(done),
+	}...)
+}
+
+// Blocks are laid out in order, so a jump to the next block falls through
+func (s *selector) selectTerminator(block *ir.Block) []Instruction {
+	next := block.Id + 1
+
+	switch terminator := block.Terminator.(type) {
+	case *ir.Jump:
+		if terminator.Target.Id == next {
+			return []Instruction{}
+		}
+		return []Instruction{JMP(s.blocks[terminator.Target.Id])}
+	case *ir.Branch:
+		output := []Instruction{CMP(sized(s.locations[terminator.Cond]), Immediate(0))}
+		if terminator.Then.Id == next {
+			return append(output, JE(s.blocks[terminator.Else.Id]))
+		}
+		output = append(output, JNE(s.blocks[terminator.Then.Id]))
+		if terminator.Else.Id == next {
+			return output
+		}
+		return append(output, JMP(s.blocks[terminator.Else.Id]))
+	case *ir.Return:
+		output := move(RAX, s.locations[terminator.Value])
+		output = append(output, s.saved...)
+		return append(output, MOV(RSP, RBP), POP(RBP), RET())
+	case *ir.Exit:
+		output := move(RAX, s.locations[terminator.Value])
+		if next == len(s.fn.Blocks) {
+			return output
+		}
+		if s.exit == "" {
+			s.exit = genLabel()
+		}
+		return append(output, JMP(s.exit))
+	}
+	panic(fmt.Sprintf("unexpected IR terminator %T", block.Terminator))
+}
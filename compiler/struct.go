@@ -0,0 +1,97 @@
+package compiler
+
+import (
+	"monkey/parser"
+)
+
+// The fields are pushed from last to first in declaration order, regardless
+// of the order they are written in, so the first field ends up at the lowest
+// address
+func compileStructExpression(expression *parser.StructExpr, env *Env) ([]Instruction, Tipe, error) {
+	tipe := env.tipeOf(expression)
+	values := map[string]parser.Expression{}
+	for _, init := range expression.Fields {
+		values[init.Name] = init.Value
+	}
+
+	output := []Instruction{}
+	tmpEnv := env
+	for i := len(tipe.Fields) - 1; i >= 0; i-- {
+		field, fieldTipe, err := compilePushed(values[tipe.Fields[i].Name], tmpEnv)
+		if err != nil {
+			return []Instruction{}, T_NEVER(0), err
+		}
+		output = append(output, field...)
+		tmpEnv = tmpEnv.addTemp(fieldTipe)
+	}
+
+	if tipe.InRegister() {
+		output = append(output, POP(RAX))
+	}
+	return output, tipe, nil
+}
+
+// Tuples are pushed like structs, from the last element to the first
+func compileTupleExpression(expression *parser.TupleExpr, env *Env) ([]Instruction, Tipe, error) {
+	output := []Instruction{}
+	tmpEnv := env
+	elems := make([]Tipe, len(expression.Elements))
+	for i := len(expression.Elements) - 1; i >= 0; i-- {
+		element, elemTipe, err := compilePushed(expression.Elements[i], tmpEnv)
+		if err != nil {
+			return []Instruction{}, T_NEVER(0), err
+		}
+		output = append(output, element...)
+		tmpEnv = tmpEnv.addTemp(elemTipe)
+		elems[i] = elemTipe
+	}
+
+	tipe := T_TUPLE(elems)
+	if tipe.InRegister() {
+		output = append(output, POP(RAX))
+	}
+	return output, tipe, nil
+}
+
+func compileFieldExpression(expression *parser.FieldExpr, env *Env) ([]Instruction, Tipe, error) {
+	if ident, ok := expression.Struct.(*parser.IdentExpr); ok {
+		return compileFieldBinding(expression, ident, env)
+	}
+
+	// Any other struct is evaluated onto the stack first
+	output, structTipe, err := compilePushed(expression.Struct, env)
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+
+	field := structTipe.field(expression.Field)
+	if field.Tipe.InRegister() {
+		output = append(output, MOV(RAX, Mem(RSP, field.Offset)))
+		return append(output, pop(structTipe.Size)...), field.Tipe, nil
+	}
+
+	// Move the field down to where the struct ends, then pop the rest
+	rest := structTipe.Size - field.Tipe.Size
+	output = append(output, copyWords(field.Tipe.Size, Mem(RSP, field.Offset), Mem(RSP, rest))...)
+	return append(output, pop(rest)...), field.Tipe, nil
+}
+
+// Reads a field straight out of the stack slot of a binding, without copying
+// the whole struct
+func compileFieldBinding(expression *parser.FieldExpr, ident *parser.IdentExpr, env *Env) ([]Instruction, Tipe, error) {
+	address, err := env.lexicalAddress(env.info.Uses[ident])
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+	field := env.tipeOf(ident).field(expression.Field)
+
+	if field.Tipe.InRegister() {
+		return []Instruction{MOV(RAX, Mem(RSP, address+field.Offset))}, field.Tipe, nil
+	}
+
+	output := []Instruction{LEA(RCX, Mem(RSP, address+field.Offset))}
+	for offset := field.Tipe.Size - 8; offset >= 0; offset -= 8 {
+		output = append(output, PUSH(Mem(RCX, offset).Qword()))
+	}
+	return output, field.Tipe, nil
+}
@@ -0,0 +1,11 @@
+section .text
+global _start
+_start: 
+	push rbp
+	mov rbp, rsp
+	mov rcx, 3
+	mov rdx, rcx
+	mov rax, rdx
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
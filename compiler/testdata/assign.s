@@ -0,0 +1,11 @@
+section .text
+global _start
+_start: 
+	mov rax, 3
+	push rax
+	mov rax, [rsp]
+	push rax
+	mov rax, [rsp]
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
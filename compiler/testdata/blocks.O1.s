@@ -0,0 +1,21 @@
+section .text
+global _start
+_start: 
+	push rbp
+	mov rbp, rsp
+	mov rcx, 8
+	mov rdx, 22
+	mov rsi, 17
+	mov rdi, 5
+	cmp rdi, rsi
+	setl r8b
+	movzx r8, r8b
+	mov rdi, rsi
+	mov rsi, rdi
+	add rsi, rcx
+	mov rcx, rsi
+	add rcx, rdx
+	mov rax, rcx
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
@@ -0,0 +1,10 @@
+section .text
+global _start
+_start: 
+	push rbp
+	mov rbp, rsp
+	mov rcx, 47
+	mov rax, rcx
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
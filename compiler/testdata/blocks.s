@@ -0,0 +1,41 @@
+section .text
+global _start
+_start: 
+	mov rax, 8
+	push rax
+	mov rax, 22
+	push rax
+	mov rax, [rsp]
+	add rsp, 8
+	push rax
+	mov rax, 17
+	push rax
+	mov rax, 5
+	push rax
+	mov rax, [rsp+8]
+	cmp [rsp], rax
+	jl label_1
+	mov rax, 0
+	jmp label_2
+label_1: 
+	mov rax, 1
+label_2: 
+	add rsp, 8
+	push rax
+	mov rax, [rsp+8]
+	push rax
+	mov rax, [rsp]
+	add rsp, 24
+	push rax
+	mov rax, [rsp+16]
+	add rax, [rsp]
+	add rsp, 8
+	push rax
+	mov rax, [rsp+8]
+	add rax, [rsp]
+	add rsp, 8
+	push rax
+	mov rax, [rsp]
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
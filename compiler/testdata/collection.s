@@ -0,0 +1,401 @@
+section .text
+global _start
+_start: 
+	jmp label_2
+label_1: 
+	mov rax, 0
+	mov rcx, 0
+	call alloc
+label_3: 
+	push rax
+	mov rax, 24
+	lea rcx, [rel gc_trace_0]
+	call alloc
+label_4: 
+	mov rdx, rax
+	pop rcx
+	mov qword [rdx], 0
+	mov qword [rdx+8], 0
+	mov [rdx+16], rcx
+	mov rax, rdx
+	push rax
+	mov rax, 0
+	push rax
+	mov rax, [rsp+24]
+	push rax
+label_5: 
+	mov rax, [rsp+8]
+	cmp rax, [rsp]
+	jge label_7
+	mov rax, [rsp+16]
+	push rax
+	mov rax, [rsp+16]
+	push rax
+	mov rax, [rsp+8]
+	mov rcx, [rax]
+	cmp rcx, [rax+8]
+	jl label_11
+	mov rax, [rax+8]
+	add rax, rax
+	cmp rax, 4
+	jge label_8
+	mov rax, 4
+label_8: 
+	push rax
+	imul rax, rax, 8
+	mov rcx, 0
+	call alloc
+label_12: 
+	mov rdx, [rsp+16]
+	mov rsi, [rdx+16]
+	mov rcx, [rdx]
+	imul rcx, rcx, 8
+label_9: 
+	cmp rcx, 0
+	je label_10
+	sub rcx, 8
+	mov r8, [rsi+rcx]
+	mov [rax+rcx], r8
+	jmp label_9
+label_10: 
+	mov [rdx+16], rax
+	pop rcx
+	mov [rdx+8], rcx
+label_11: 
+	mov rdx, [rsp+8]
+	mov rcx, [rdx]
+	imul rcx, rcx, 8
+	add rcx, [rdx+16]
+	mov rax, [rsp]
+	mov [rcx], rax
+	add qword [rdx], 1
+	mov rax, [rdx]
+	add rsp, 16
+	push rax
+	add rsp, 8
+label_6: 
+	add qword [rsp+8], 1
+	jmp label_5
+label_7: 
+	add rsp, 16
+	mov rax, [rsp]
+	add rsp, 8
+	ret 
+label_2: 
+	lea rax, [rel label_1]
+	push rax
+	mov rax, 10
+	push rax
+	mov rax, [rsp+8]
+	call rax
+label_13: 
+	add rsp, 8
+	push rax
+	mov rax, 0
+	push rax
+	mov rax, 0
+	push rax
+	mov rax, 300
+	push rax
+label_14: 
+	mov rax, [rsp+8]
+	cmp rax, [rsp]
+	jge label_16
+	mov rax, 1000
+	push rax
+	mov rax, [rsp+40]
+	call rax
+label_17: 
+	add rsp, 8
+	push rax
+	mov rax, 990
+	push rax
+	mov rax, [rsp+40]
+	push rax
+	mov rax, 9
+	mov rcx, [rsp]
+	cmp rax, [rcx]
+	jae bounds_error
+	imul rax, rax, 8
+	pop rcx
+	mov rcx, [rcx+16]
+	add rcx, rax
+	mov rax, [rcx]
+	push rax
+	mov rax, [rsp+40]
+	push rax
+	mov rax, [rsp+24]
+	push rax
+	mov rax, 999
+	mov rcx, [rsp]
+	cmp rax, [rcx]
+	jae bounds_error
+	imul rax, rax, 8
+	pop rcx
+	mov rcx, [rcx+16]
+	add rcx, rax
+	mov rax, [rcx]
+	add rax, [rsp]
+	add rsp, 8
+	sub rax, [rsp]
+	add rsp, 8
+	sub rax, [rsp]
+	add rsp, 8
+	mov [rsp+24], rax
+	add rsp, 8
+label_15: 
+	add qword [rsp+8], 1
+	jmp label_14
+label_16: 
+	add rsp, 16
+	mov rax, 10
+	push rax
+	mov rax, [rsp+24]
+	call rax
+label_18: 
+	add rsp, 8
+	push rax
+	mov rax, [rsp+8]
+	push rax
+	mov rax, [rsp+24]
+	push rax
+	mov rax, 9
+	mov rcx, [rsp]
+	cmp rax, [rcx]
+	jae bounds_error
+	imul rax, rax, 8
+	pop rcx
+	mov rcx, [rcx+16]
+	add rcx, rax
+	mov rax, [rcx]
+	add rax, [rsp]
+	add rsp, 8
+	push rax
+	mov rax, [rsp+24]
+	mov rax, [rax]
+	add rax, [rsp]
+	add rsp, 8
+	push rax
+	mov rax, [rsp+8]
+	push rax
+	mov rax, 9
+	mov rcx, [rsp]
+	cmp rax, [rcx]
+	jae bounds_error
+	imul rax, rax, 8
+	pop rcx
+	mov rcx, [rcx+16]
+	add rcx, rax
+	mov rax, [rcx]
+	add rax, [rsp]
+	add rsp, 8
+	push rax
+	mov rax, [rsp]
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
+bounds_error: 
+	mov rax, 33554436
+	mov rdi, 2
+	lea rsi, [rel bounds_error_msg]
+	mov rdx, 20
+	syscall 
+	mov rax, 33554433
+	mov rdi, 101
+	syscall 
+alloc: 
+	add rax, 23
+	and rax, -8
+	add [rel gc_allocated], rax
+alloc_bump: 
+	mov rdx, [rel heap_ptr]
+	lea rsi, [rdx+rax]
+	cmp rsi, [rel heap_end]
+	ja alloc_collect
+	mov [rel heap_ptr], rsi
+	mov [rdx], rax
+	mov [rdx+8], rcx
+	lea rax, [rdx+16]
+	ret 
+alloc_collect: 
+	push rax
+	push rcx
+	call gc_collect
+	pop rcx
+	pop rax
+	jmp alloc_bump
+gc_collect: 
+	mov rax, [rel heap_ptr]
+	sub rax, [rel heap_start]
+	add rax, [rsp+16]
+	cmp rax, [rel heap_size]
+	jbe gc_collect_map
+	mov [rel heap_size], rax
+gc_collect_map: 
+	mov rax, 33554629
+	mov rdi, 0
+	mov rsi, [rel heap_size]
+	mov rdx, 3
+	mov r10, 4098
+	mov r8, -1
+	mov r9, 0
+	syscall 
+	jc out_of_memory
+	mov [rel to_start], rax
+	mov [rel to_ptr], rax
+	lea r12, [rsp+24]
+gc_collect_frame: 
+	mov rax, [r12]
+	lea r13, [rel gc_maps]
+gc_collect_find: 
+	cmp qword [r13], 0
+	je gc_collect_scan
+	cmp rax, [r13]
+	je gc_collect_found
+	add r13, 32
+	jmp gc_collect_find
+gc_collect_found: 
+	lea rbx, [r12+8]
+	mov rax, [r13+24]
+	cmp rax, 0
+	je gc_collect_next
+	call rax
+gc_collect_next: 
+	cmp qword [r13+16], 0
+	jne gc_collect_scan
+	add r12, [r13+8]
+	add r12, 8
+	jmp gc_collect_frame
+gc_collect_scan: 
+	mov r14, [rel to_start]
+gc_collect_object: 
+	cmp r14, [rel to_ptr]
+	jae gc_collect_done
+	mov rax, [r14+8]
+	cmp rax, 0
+	je gc_collect_skip
+	lea rbx, [r14+16]
+	mov rsi, [r14]
+	sub rsi, 16
+	call rax
+gc_collect_skip: 
+	add r14, [r14]
+	jmp gc_collect_object
+gc_collect_done: 
+	mov rdi, [rel heap_start]
+	cmp rdi, 0
+	je gc_collect_swap
+	add qword [rel gc_collections], 1
+	mov rax, [rel to_ptr]
+	sub rax, [rel to_start]
+	add [rel gc_copied], rax
+	mov rax, 33554505
+	mov rsi, [rel heap_end]
+	sub rsi, rdi
+	syscall 
+gc_collect_swap: 
+	mov rax, [rel to_start]
+	mov [rel heap_start], rax
+	mov rcx, [rel to_ptr]
+	mov [rel heap_ptr], rcx
+	add rax, [rel heap_size]
+	mov [rel heap_end], rax
+	mov rax, rcx
+	sub rax, [rel heap_start]
+	add rax, [rsp+16]
+	add rax, rax
+	cmp rax, [rel heap_size]
+	jbe gc_collect_end
+	mov [rel heap_size], rax
+gc_collect_end: 
+	ret 
+gc_forward: 
+	mov rax, [rdi]
+	cmp rax, [rel heap_start]
+	jb gc_forward_done
+	cmp rax, [rel heap_ptr]
+	jae gc_forward_done
+	cmp qword [rax-8], -1
+	jne gc_forward_copy
+	mov rax, [rax-16]
+	mov [rdi], rax
+	ret 
+gc_forward_copy: 
+	mov rcx, [rax-16]
+	mov rdx, [rel to_ptr]
+	mov r8, 0
+gc_forward_word: 
+	mov r9, [rax+r8-16]
+	mov [rdx+r8], r9
+	add r8, 8
+	cmp r8, rcx
+	jb gc_forward_word
+	add [rel to_ptr], rcx
+	lea r9, [rdx+16]
+	mov [rax-16], r9
+	mov qword [rax-8], -1
+	mov [rdi], r9
+gc_forward_done: 
+	ret 
+gc_trace_0: 
+	push rbx
+	lea rax, [rbx+rsi]
+	push rax
+gc_trace_0_1: 
+	cmp rbx, [rsp]
+	jae gc_trace_0_2
+	lea rdi, [rbx+16]
+	call gc_forward
+	add rbx, 24
+	jmp gc_trace_0_1
+gc_trace_0_2: 
+	add rsp, 8
+	pop rbx
+	ret 
+gc_trace_1: 
+	lea rdi, [rbx]
+	call gc_forward
+	ret 
+gc_trace_2: 
+	lea rdi, [rbx+16]
+	call gc_forward
+	lea rdi, [rbx+40]
+	call gc_forward
+	ret 
+gc_trace_3: 
+	lea rdi, [rbx+32]
+	call gc_forward
+	ret 
+gc_trace_4: 
+	lea rdi, [rbx+16]
+	call gc_forward
+	ret 
+out_of_memory: 
+	mov rax, 33554436
+	mov rdi, 2
+	lea rsi, [rel out_of_memory_msg]
+	mov rdx, 14
+	syscall 
+	mov rax, 33554433
+	mov rdi, 102
+	syscall 
+section .data
+bounds_error_msg: db "index out of bounds", 10
+out_of_memory_msg: db "out of memory", 10
+heap_start: dq 0
+heap_ptr: dq 0
+heap_end: dq 0
+heap_size: dq 1048576
+to_start: dq 0
+to_ptr: dq 0
+gc_maps: 
+gc_map_0: dq label_3, 0, 0, 0
+gc_map_1: dq label_4, 8, 0, gc_trace_1
+gc_map_2: dq label_12, 48, 0, gc_trace_2
+gc_map_3: dq label_13, 16, 1, 0
+gc_map_4: dq label_17, 48, 1, gc_trace_3
+gc_map_5: dq label_18, 32, 1, gc_trace_4
+gc_maps_end: dq 0
+gc_collections: dq 0
+gc_allocated: dq 0
+gc_copied: dq 0
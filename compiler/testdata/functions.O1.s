@@ -0,0 +1,48 @@
+section .text
+global _start
+_start: 
+	push rbp
+	mov rbp, rsp
+	lea rbx, [rel label_2]
+	lea rcx, [rel label_3]
+	push rcx
+	mov rax, rbx
+	call rax
+	add rsp, 8
+	mov r12, rax
+	mov rcx, 40
+	push rcx
+	mov rax, rbx
+	call rax
+	add rsp, 8
+	mov rdx, rax
+	mov rcx, 2
+	push rdx
+	push rcx
+	mov rax, r12
+	call rax
+	add rsp, 16
+	mov rsi, rax
+	mov rax, rsi
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
+label_2: 
+	push rbp
+	mov rbp, rsp
+	mov rcx, [rbp+16]
+	mov rax, rcx
+	mov rsp, rbp
+	pop rbp
+	ret 
+label_3: 
+	push rbp
+	mov rbp, rsp
+	mov rcx, [rbp+24]
+	mov rdx, [rbp+16]
+	mov rsi, rcx
+	add rsi, rdx
+	mov rax, rsi
+	mov rsp, rbp
+	pop rbp
+	ret 
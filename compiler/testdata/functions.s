@@ -0,0 +1,46 @@
+section .text
+global _start
+_start: 
+	jmp label_2
+label_1: 
+	mov rax, [rsp+8]
+	ret 
+label_2: 
+	lea rax, [rel label_1]
+	push rax
+	jmp label_4
+label_3: 
+	mov rax, [rsp+16]
+	push rax
+	mov rax, [rsp+16]
+	add rax, [rsp]
+	add rsp, 8
+	ret 
+label_4: 
+	lea rax, [rel label_3]
+	push rax
+	mov rax, [rsp]
+	push rax
+	mov rax, [rsp+16]
+	call rax
+label_5: 
+	add rsp, 8
+	push rax
+	mov rax, 40
+	push rax
+	mov rax, [rsp+24]
+	call rax
+label_6: 
+	add rsp, 8
+	push rax
+	mov rax, 2
+	push rax
+	mov rax, [rsp+16]
+	call rax
+label_7: 
+	add rsp, 16
+	push rax
+	mov rax, [rsp]
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
@@ -0,0 +1,46 @@
+section .text
+global _start
+_start: 
+	push rbp
+	mov rbp, rsp
+	mov rcx, 0
+	mov rdx, 0
+	mov rsi, 4
+label_3: 
+	cmp rdx, rsi
+	setg dil
+	movzx rdi, dil
+	cmp rdi, 0
+	jne label_7
+label_4: 
+	mov rdi, rcx
+	add rdi, rdx
+	mov rcx, rdi
+	jmp label_6
+label_5: 
+label_6: 
+	mov rdi, 1
+	add rdx, rdi
+	jmp label_3
+label_7: 
+label_8: 
+	mov rdx, 20
+	cmp rcx, rdx
+	setl sil
+	movzx rsi, sil
+	cmp rsi, 0
+	je label_11
+label_9: 
+	mov rdx, 3
+	mov rsi, rcx
+	add rsi, rdx
+	mov rcx, rsi
+	jmp label_11
+label_10: 
+	jmp label_8
+label_11: 
+	mov rdx, rcx
+	mov rax, rdx
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
@@ -0,0 +1,54 @@
+section .text
+global _start
+_start: 
+	mov rax, 0
+	push rax
+	mov rax, 0
+	push rax
+	mov rax, 4
+	push rax
+label_1: 
+	mov rax, [rsp+8]
+	cmp rax, [rsp]
+	jg label_3
+	mov rax, [rsp+16]
+	push rax
+	mov rax, [rsp+16]
+	add rax, [rsp]
+	add rsp, 8
+	mov [rsp+16], rax
+	jmp label_2
+label_2: 
+	add qword [rsp+8], 1
+	jmp label_1
+label_3: 
+	add rsp, 16
+label_4: 
+	mov rax, [rsp]
+	push rax
+	mov rax, 20
+	cmp [rsp], rax
+	jl label_6
+	mov rax, 0
+	jmp label_7
+label_6: 
+	mov rax, 1
+label_7: 
+	add rsp, 8
+	cmp rax, 0
+	je label_5
+	mov rax, [rsp]
+	push rax
+	mov rax, 3
+	add rax, [rsp]
+	add rsp, 8
+	mov [rsp], rax
+	jmp label_5
+	jmp label_4
+label_5: 
+	mov rax, [rsp]
+	push rax
+	mov rax, [rsp]
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
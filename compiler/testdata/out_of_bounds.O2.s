@@ -0,0 +1,30 @@
+section .text
+global _start
+_start: 
+	mov rax, 3
+	push rax
+	mov rax, 2
+	push rax
+	mov rax, 1
+	push rax
+	mov rax, 3
+	push rax
+	cmp rax, 3
+	jae bounds_error
+	imul rax, rax, 8
+	mov rax, [rsp+rax+8]
+	push rax
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
+bounds_error: 
+	mov rax, 33554436
+	mov rdi, 2
+	lea rsi, [rel bounds_error_msg]
+	mov rdx, 20
+	syscall 
+	mov rax, 33554433
+	mov rdi, 101
+	syscall 
+section .data
+bounds_error_msg: db "index out of bounds", 10
@@ -0,0 +1,60 @@
+section .text
+global _start
+_start: 
+	mov rax, 4
+	push rax
+	mov rax, 3
+	push rax
+	mov rax, 2
+	push rax
+	mov rax, 1
+	push rax
+	mov rax, 7
+	push rax
+	push 0
+	mov rax, 1
+	cmp rax, 2
+	jae bounds_error
+	imul rax, rax, 16
+	add [rsp], rax
+	add qword [rsp], 8
+	pop rcx
+	lea rcx, [rsp+rcx+8]
+	mov rax, [rsp]
+	mov [rcx], rax
+	add rsp, 8
+	mov rax, 0
+	cmp rax, 2
+	jae bounds_error
+	imul rax, rax, 16
+	lea rcx, [rsp+rax]
+	push qword [rcx+8]
+	push qword [rcx]
+	mov rax, [rsp]
+	add rsp, 16
+	push rax
+	mov rax, 1
+	cmp rax, 2
+	jae bounds_error
+	imul rax, rax, 16
+	lea rcx, [rsp+rax+8]
+	push qword [rcx+8]
+	push qword [rcx]
+	mov rax, [rsp+8]
+	add rsp, 16
+	sub rax, [rsp]
+	mov [rsp], rax
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
+bounds_error: 
+	mov rax, 33554436
+	mov rdi, 2
+	lea rsi, [rel bounds_error_msg]
+	mov rdx, 20
+	syscall 
+	mov rax, 33554433
+	mov rdi, 101
+	syscall 
+section .data
+bounds_error_msg: db "index out of bounds", 10
@@ -0,0 +1,58 @@
+section .text
+global _start
+_start: 
+	jmp label_2
+label_1: 
+	push qword [rsp+24]
+	push qword [rsp+24]
+	push qword [rsp+24]
+	cmp qword [rsp], 0
+	jne label_4
+	mov rax, [rsp+8]
+	push rax
+	mov rax, [rsp+16]
+	add rax, [rsp]
+	add rsp, 8
+	jmp label_3
+label_4: 
+	cmp qword [rsp], 1
+	jne label_5
+	mov rax, [rsp+16]
+	push rax
+	mov rax, [rsp+16]
+	sub rax, [rsp]
+	add rsp, 8
+	jmp label_3
+label_5: 
+	mov rax, 0
+label_3: 
+	add rsp, 24
+	ret 
+label_2: 
+	lea rax, [rel label_1]
+	push rax
+	mov rax, 2
+	push rax
+	mov rax, 9
+	push rax
+	push 1
+	mov rax, [rsp+24]
+	call rax
+label_6: 
+	add rsp, 24
+	push rax
+	push 0
+	mov rax, 5
+	push rax
+	push 0
+	mov rax, [rsp+32]
+	call rax
+label_7: 
+	add rsp, 24
+	add rax, [rsp]
+	add rsp, 8
+	push rax
+	mov rax, [rsp]
+	mov rdi, rax
+	mov rax, 33554433
+	syscall 
@@ -1,12 +1,106 @@
 package compiler
 
+import (
+	"fmt"
+	"monkey/checker"
+	"strings"
+)
+
 type Tipe struct {
 	Name string
 	Size int
+
+	// Only set for arrow types
+	Params  []Tipe
+	Returns *Tipe
+
+	// Only set for array and list types
+	Elem   *Tipe
+	Length int
+
+	// Only set for struct types
+	Fields []Field
+
+	// Only set for union types
+	Variants []Variant
+
+	// Set for values that are the address of an object on the heap
+	Pointer bool
+}
+
+type Field struct {
+	Name   string
+	Tipe   Tipe
+	Offset int
+}
+
+// The tag of a variant is its index in the union
+type Variant struct {
+	Name    string
+	Payload []Field
 }
 
 func (t Tipe) IsEqualTo(other Tipe) bool {
-	return t.Name == other.Name && t.Size == other.Size
+	if t.Name != other.Name || t.Size != other.Size || len(t.Params) != len(other.Params) {
+		return false
+	}
+	for i := range t.Params {
+		if !t.Params[i].IsEqualTo(other.Params[i]) {
+			return false
+		}
+	}
+	if t.Length != other.Length || !isEqualOrNil(t.Elem, other.Elem) || len(t.Fields) != len(other.Fields) {
+		return false
+	}
+	for i := range t.Fields {
+		if !t.Fields[i].Tipe.IsEqualTo(other.Fields[i].Tipe) {
+			return false
+		}
+	}
+	return isEqualOrNil(t.Returns, other.Returns)
+}
+
+func isEqualOrNil(t *Tipe, other *Tipe) bool {
+	if t == nil || other == nil {
+		return t == other
+	}
+	return t.IsEqualTo(*other)
+}
+
+// Renders the type the same way it would be written in source code
+func (t Tipe) Render() string {
+	if t.Name == "list" {
+		return fmt.Sprint("list<", t.Elem.Render(), ">")
+	}
+	if t.Elem != nil {
+		return fmt.Sprint("[", t.Elem.Render(), "; ", t.Length, "]")
+	}
+	if t.Name == "tuple" {
+		var s = strings.Builder{}
+		s.WriteString("(")
+		for i, field := range t.Fields {
+			s.WriteString(field.Tipe.Render())
+			if i < len(t.Fields)-1 {
+				s.WriteString(", ")
+			}
+		}
+		s.WriteString(")")
+		return s.String()
+	}
+	if t.Returns == nil {
+		return t.Name
+	}
+	var s = strings.Builder{}
+	s.WriteString("(")
+	for i, param := range t.Params {
+		s.WriteString(param.Render())
+		if i < len(t.Params)-1 {
+			s.WriteString(", ")
+		}
+	}
+	s.WriteString(") -> ")
+	s.WriteString(t.Returns.Render())
+	return s.String()
 }
 
 func T_NEVER(size int) Tipe {
@@ -16,6 +110,12 @@ func T_NEVER(size int) Tipe {
 	}
 }
 
+// Pushed by a call, only ever seen by the garbage collector
+var T_RETURN_ADDRESS = Tipe{
+	Name: "return address",
+	Size: 8,
+}
+
 var T_INT = Tipe{
 	Name: "int",
 	Size: 8,
@@ -27,7 +127,194 @@ var T_BOOL = Tipe{
 }
 
 // An arrow type represents the address of a function.
-var T_ARROW = Tipe{
-	Name: "arrow",
-	Size: 8,
+func T_ARROW(params []Tipe, returns Tipe) Tipe {
+	return Tipe{
+		Name:    "arrow",
+		Size:    8,
+		Params:  params,
+		Returns: &returns,
+	}
+}
+
+// Arrays are laid out on the stack with the first element at the lowest address
+func T_ARRAY(elem Tipe, length int) Tipe {
+	return Tipe{
+		Name:   "array",
+		Size:   elem.Size * length,
+		Elem:   &elem,
+		Length: length,
+	}
+}
+
+// A list is the address of its header on the heap, see list.go
+func T_LIST(elem Tipe) Tipe {
+	return Tipe{
+		Name:    "list",
+		Size:    8,
+		Elem:    &elem,
+		Pointer: true,
+	}
+}
+
+// Tuples are laid out like structs with unnamed fields
+func T_TUPLE(elems []Tipe) Tipe {
+	fields := make([]Field, 0, len(elems))
+	for _, elem := range elems {
+		fields = append(fields, Field{Tipe: elem})
+	}
+	return T_STRUCT("tuple", fields)
+}
+
+// Struct fields are laid out in declaration order, with the first field at
+// the lowest address. The offsets of the given fields are filled in.
+func T_STRUCT(name string, fields []Field) Tipe {
+	size := 0
+	for i := range fields {
+		fields[i].Offset = size
+		size += fields[i].Tipe.Size
+	}
+	return Tipe{
+		Name:   name,
+		Size:   size,
+		Fields: fields,
+	}
+}
+
+// Returns the field with the given name, or nil if there is none
+func (t Tipe) field(name string) *Field {
+	for i := range t.Fields {
+		if t.Fields[i].Name == name {
+			return &t.Fields[i]
+		}
+	}
+	return nil
+}
+
+/*
+A union is a tag word followed by the payload of its variant. The payload of
+each variant is laid out like a struct, and the union is as large as its
+largest variant:
+
+	[0]                   tag
+	[8]                   first payload value
+	...                   padding up to the largest variant
+*/
+func T_UNION(name string, variants []Variant) Tipe {
+	largest := 0
+	for i := range variants {
+		size := 8
+		for j := range variants[i].Payload {
+			variants[i].Payload[j].Offset = size
+			size += variants[i].Payload[j].Tipe.Size
+		}
+		if size > largest {
+			largest = size
+		}
+	}
+	return Tipe{
+		Name:     name,
+		Size:     largest,
+		Variants: variants,
+	}
+}
+
+// Returns the tag of the variant with the given name
+func (t Tipe) tag(name string) int {
+	for i := range t.Variants {
+		if t.Variants[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Whether the garbage collector has to look inside values of the type
+func (t Tipe) hasPointers() bool {
+	if t.Pointer {
+		return true
+	}
+	if t.Elem != nil && t.Length > 0 {
+		return t.Elem.hasPointers()
+	}
+	for _, field := range t.Fields {
+		if field.Tipe.hasPointers() {
+			return true
+		}
+	}
+	for _, variant := range t.Variants {
+		for _, field := range variant.Payload {
+			if field.Tipe.hasPointers() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Values that fit in a machine word are held in rax, all others are pushed
+// onto the stack
+func (t Tipe) InRegister() bool {
+	return t.Size == 8
+}
+
+// Every value of a generic type is the size of a machine word, so one
+// compiled function can be shared by all instantiations.
+func T_GENERIC(name string) Tipe {
+	return Tipe{
+		Name: name,
+		Size: 8,
+	}
+}
+
+// Converts a type produced by the checker to its runtime representation
+func fromType(t checker.Type) Tipe {
+	switch t := checker.Resolve(t).(type) {
+	case *checker.TCon:
+		switch t.Name {
+		case "int":
+			return T_INT
+		case "bool":
+			return T_BOOL
+		case "list":
+			return T_LIST(fromType(t.Args[0]))
+		case "option":
+			// Named after the value, so options of different values are different types
+			return fromVariants(fmt.Sprint("option<", fromType(t.Args[0]).Render(), ">"), checker.VariantsOf(t))
+		}
+	case *checker.TArrow:
+		params := make([]Tipe, 0, len(t.Params))
+		for _, param := range t.Params {
+			params = append(params, fromType(param))
+		}
+		return T_ARROW(params, fromType(t.Returns))
+	case *checker.TArray:
+		return T_ARRAY(fromType(t.Elem), t.Length)
+	case *checker.TTuple:
+		elems := make([]Tipe, 0, len(t.Elems))
+		for _, elem := range t.Elems {
+			elems = append(elems, fromType(elem))
+		}
+		return T_TUPLE(elems)
+	case *checker.TStruct:
+		fields := make([]Field, 0, len(t.Fields))
+		for _, field := range t.Fields {
+			fields = append(fields, Field{Name: field.Name, Tipe: fromType(field.Type)})
+		}
+		return T_STRUCT(t.Name, fields)
+	case *checker.TUnion:
+		return fromVariants(t.Name, t.Variants)
+	}
+	return T_GENERIC(t.Render())
+}
+
+func fromVariants(name string, variants []checker.Variant) Tipe {
+	converted := make([]Variant, 0, len(variants))
+	for _, variant := range variants {
+		payload := make([]Field, 0, len(variant.Payload))
+		for _, tipe := range variant.Payload {
+			payload = append(payload, Field{Tipe: fromType(tipe)})
+		}
+		converted = append(converted, Variant{Name: variant.Name, Payload: payload})
+	}
+	return T_UNION(name, converted)
 }
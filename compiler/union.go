@@ -0,0 +1,115 @@
+package compiler
+
+import (
+	"monkey/parser"
+)
+
+// The padding is pushed first, then the payload from last to first, and the
+// tag ends up at the lowest address
+func compileConstructor(expression parser.Expression, env *Env) ([]Instruction, Tipe, error) {
+	tipe := env.tipeOf(expression)
+	variant := tipe.Variants[tipe.tag(env.info.Variants[expression])]
+
+	var arguments []parser.Expression
+	if call, ok := expression.(*parser.CallExpr); ok {
+		arguments = call.Arguments
+	}
+
+	output := []Instruction{}
+	payloadSize := 0
+	for _, field := range variant.Payload {
+		payloadSize += field.Tipe.Size
+	}
+	for padding := tipe.Size - 8 - payloadSize; padding > 0; padding -= 8 {
+		output = append(output, PUSH(Immediate(0)))
+	}
+	tmpEnv := env.addNever(tipe.Size - 8 - payloadSize)
+
+	for i := len(arguments) - 1; i >= 0; i-- {
+		argument, argTipe, err := compilePushed(arguments[i], tmpEnv)
+		if err != nil {
+			return []Instruction{}, T_NEVER(0), err
+		}
+		output = append(output, argument...)
+		tmpEnv = tmpEnv.addTemp(argTipe)
+	}
+
+	tag := Immediate(tipe.tag(variant.Name))
+	if tipe.InRegister() {
+		return append(output, MOV(RAX, tag)), tipe, nil
+	}
+	return append(output, PUSH(tag)), tipe, nil
+}
+
+/*
+The value is pushed and its tag compared against each arm in turn. The payload
+of the matching variant is bound in place, without copying it:
+
+	[rsp]                 tag
+	[rsp+8]               first payload value
+	...
+*/
+func compileMatchExpression(expression *parser.MatchExpr, env *Env) ([]Instruction, Tipe, error) {
+	output, valueTipe, err := compilePushed(expression.Value, env)
+	if err != nil {
+		return []Instruction{}, T_NEVER(0), err
+	}
+
+	tipe := env.tipeOf(expression)
+	end := genLabel()
+	for i := range expression.Arms {
+		arm := &expression.Arms[i]
+		// The checker made sure the arms are exhaustive, so the last one
+		// always matches
+		last := i == len(expression.Arms)-1
+
+		armEnv := env.addTemp(valueTipe)
+		next := ""
+		if arm.Variant != "_" {
+			tag := valueTipe.tag(arm.Variant)
+			armEnv = bindPayload(valueTipe, valueTipe.Variants[tag], arm, env)
+			if !last {
+				next = genLabel()
+				output = append(output, CMP(Mem(RSP, 0).Qword(), Immediate(tag)), JNE(next))
+			}
+		}
+
+		body, _, err := compileExpression(arm.Body, armEnv)
+		if err != nil {
+			return []Instruction{}, T_NEVER(0), err
+		}
+		output = append(output, body...)
+
+		if !last {
+			output = append(output, JMP(end))
+		}
+		if next != "" {
+			output = append(output, LABEL(next))
+		}
+	}
+	output = append(output, LABEL(end))
+
+	// A result on the stack is moved down over the value
+	if !tipe.InRegister() {
+		output = append(output, copyWords(tipe.Size, Mem(RSP, 0), Mem(RSP, valueTipe.Size))...)
+	}
+	return append(output, pop(valueTipe.Size)...), tipe, nil
+}
+
+// Returns an environment where the union value on top of the stack is split
+// into the bindings of an arm
+func bindPayload(union Tipe, variant Variant, arm *parser.MatchArm, env *Env) *Env {
+	payloadSize := 0
+	for _, field := range variant.Payload {
+		payloadSize += field.Tipe.Size
+	}
+
+	armEnv := env
+	if padding := union.Size - 8 - payloadSize; padding > 0 {
+		armEnv = armEnv.addNever(padding)
+	}
+	for j := len(variant.Payload) - 1; j >= 0; j-- {
+		armEnv = armEnv.addBinding(env.info.Defs[&arm.Bindings[j]], variant.Payload[j].Tipe)
+	}
+	return armEnv.addNever(8)
+}
@@ -0,0 +1,571 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"monkey/checker"
+	"monkey/parser"
+)
+
+/*
+Evaluates a program by walking its syntax tree, as a reference for what the
+compiled program does. The program must already have been checked, so the
+interpreter follows the same type rules as the compiler and looks up what the
+checker resolved: the symbol each name refers to, the variant each
+constructor makes and the builtin each call is to.
+
+Operands are evaluated in the same order as in the compiled program, so side
+effects and runtime errors happen in the same order too.
+*/
+
+// The exit code of a program that indexed out of bounds, the same as for the
+// compiled program
+const EXIT_OUT_OF_BOUNDS = 101
+
+var errOutOfBounds = errors.New("index out of bounds")
+
+// Raised by break and continue, and caught by the innermost loop
+var errBreak = errors.New("break")
+var errContinue = errors.New("continue")
+
+type RuntimeError struct {
+	Error    error
+	Position int
+
+	// The status the program exits with
+	ExitCode int
+}
+
+func (e *RuntimeError) ToError(source string) error {
+	return errors.New(fmt.Sprint("[runtime err]: ", e.Error, "\nCulprit:\n>>> ", source))
+}
+
+type interpreter struct {
+	info *checker.Info
+
+	// The position of the statement being evaluated
+	position int
+}
+
+// The values of the bindings of one function call, or of the top level.
+// Every declaration has its own symbol, so nested scopes can share a frame.
+type frame map[*checker.Symbol]Value
+
+// Lists are shared rather than copied, so pushing to one changes it in both
+func (f frame) copy() frame {
+	copied := make(frame, len(f))
+	for symbol, value := range f {
+		copied[symbol] = value
+	}
+	return copied
+}
+
+// Evaluates a program, info is the result of checker.Check for that program.
+// Returns the value bound by the last statement, or nil if it bound nothing.
+func Run(program parser.Program, info *checker.Info) (Value, *RuntimeError) {
+	return NewSession(info).Run(program.Statements)
+}
+
+// Evaluates a program one piece at a time, keeping the values of its bindings
+// between pieces. Info is that of the checker.Session the pieces are checked in.
+type Session struct {
+	in *interpreter
+	f  frame
+}
+
+func NewSession(info *checker.Info) *Session {
+	return &Session{in: &interpreter{info: info}, f: frame{}}
+}
+
+// Returns the value bound by the last statement, or nil if it bound nothing.
+// The bindings are only kept if every statement runs without an error.
+func (s *Session) Run(statements []parser.Statement) (Value, *RuntimeError) {
+	f := s.f.copy()
+	var result Value
+	for _, statement := range statements {
+		var err error
+		result, err = s.in.evalStatement(statement, f)
+		if err != nil {
+			return nil, s.in.runtimeError(err)
+		}
+	}
+	s.f = f
+	return result, nil
+}
+
+// Evaluates an expression on its own, errors are reported at the start of the
+// input unless they happen inside a statement
+func (s *Session) Eval(expression parser.Expression) (Value, *RuntimeError) {
+	s.in.position = 0
+	f := s.f.copy()
+	value, err := s.in.eval(expression, f)
+	if err != nil {
+		return nil, s.in.runtimeError(err)
+	}
+	s.f = f
+	return value, nil
+}
+
+// Returns the status a program exits with, given the value its last statement
+// bound. As in every backend only an int or a bool gives a status, see
+// checker.Info.ExitBinding.
+func ExitCode(result Value) int {
+	switch result := result.(type) {
+	case Int:
+		return int(uint8(result))
+	case Bool:
+		if result {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (in *interpreter) runtimeError(err error) *RuntimeError {
+	exitCode := 1
+	if err == errOutOfBounds {
+		exitCode = EXIT_OUT_OF_BOUNDS
+	}
+	return &RuntimeError{Error: err, Position: in.position, ExitCode: exitCode}
+}
+
+// Returns the value bound by an assignment, or nil for other statements
+func (in *interpreter) evalStatement(statement parser.Statement, f frame) (Value, error) {
+	in.position = statement.Position()
+
+	switch statement := statement.(type) {
+	case *parser.AssignStmt:
+		value, err := in.eval(statement.Rhs, f)
+		if err != nil {
+			return nil, err
+		}
+		f[in.info.Defs[statement]] = value
+		return value, nil
+	case *parser.DestructureStmt:
+		value, err := in.eval(statement.Rhs, f)
+		if err != nil {
+			return nil, err
+		}
+		for i := range statement.Lhs {
+			f[in.info.Defs[&statement.Lhs[i]]] = value.(*Tuple).Elements[i]
+		}
+		return nil, nil
+	case *parser.ReassignStmt:
+		return nil, in.assign(statement.Lhs, statement.Rhs, f)
+	case *parser.TypeDeclStmt:
+		// Types only exist at compile time
+		return nil, nil
+	case *parser.WhileStmt:
+		return nil, in.evalWhile(statement, f)
+	case *parser.ForStmt:
+		return nil, in.evalFor(statement, f)
+	case *parser.BreakStmt:
+		return nil, errBreak
+	case *parser.ContinueStmt:
+		return nil, errContinue
+	}
+	return nil, errors.New("unexpected statement type")
+}
+
+func (in *interpreter) evalStatements(statements []parser.Statement, f frame) error {
+	for _, statement := range statements {
+		if _, err := in.evalStatement(statement, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs the body of a loop once, returning whether the loop was broken out of
+func (in *interpreter) iterate(body []parser.Statement, f frame) (bool, error) {
+	switch err := in.evalStatements(body, f); err {
+	case errBreak:
+		return true, nil
+	case errContinue:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (in *interpreter) evalWhile(statement *parser.WhileStmt, f frame) error {
+	for {
+		cond, err := in.eval(statement.Cond, f)
+		if err != nil {
+			return err
+		}
+		if !cond.(Bool) {
+			return nil
+		}
+		if broken, err := in.iterate(statement.Body, f); broken || err != nil {
+			return err
+		}
+	}
+}
+
+// Both bounds of a range are evaluated once, before the first iteration. The
+// length of a list is looked at before each iteration, as it may grow.
+func (in *interpreter) evalFor(statement *parser.ForStmt, f frame) error {
+	variable := in.info.Defs[statement]
+
+	if statement.To == nil {
+		list, err := in.eval(statement.From, f)
+		if err != nil {
+			return err
+		}
+		elements := &list.(*List).Elements
+		for i := 0; i < len(*elements); i++ {
+			f[variable] = (*elements)[i]
+			if broken, err := in.iterate(statement.Body, f); broken || err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	from, to, err := in.evalOperands(statement.From, statement.To, f)
+	if err != nil {
+		return err
+	}
+	for i := from; i < to || (statement.Inclusive && i == to); i++ {
+		f[variable] = i
+		if broken, err := in.iterate(statement.Body, f); broken || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Writes a value to a binding, or to an element of it. Arrays and structs along
+the way are replaced by copies with the element changed, so that values shared
+with other bindings are unaffected. Lists are changed in place, so the path
+starts from the innermost list, like in the compiled program.
+
+The value is evaluated first, then the indices from the root to the element.
+*/
+func (in *interpreter) assign(target parser.Expression, value parser.Expression, f frame) error {
+	newValue, err := in.eval(value, f)
+	if err != nil {
+		return err
+	}
+
+	// Find the binding or list at the root and the element at each level
+	var path []parser.Expression
+	var root parser.Expression = target
+	var list *parser.IndexExpr
+	for list == nil {
+		if index, ok := root.(*parser.IndexExpr); ok {
+			path = append([]parser.Expression{index}, path...)
+			root = index.Array
+			if isList(in.info.TypeOf(index.Array)) {
+				list = index
+			}
+		} else if field, ok := root.(*parser.FieldExpr); ok {
+			path = append([]parser.Expression{field}, path...)
+			root = field.Struct
+		} else {
+			break
+		}
+	}
+
+	var elements []Value
+	listIndex := 0
+	if list != nil {
+		container, err := in.eval(list.Array, f)
+		if err != nil {
+			return err
+		}
+		elements = container.(*List).Elements
+		if listIndex, err = in.evalIndex(list.Index, len(elements), f); err != nil {
+			return err
+		}
+		path = path[1:]
+	}
+
+	keys := make([]int, len(path))
+	for i, element := range path {
+		switch element := element.(type) {
+		case *parser.IndexExpr:
+			length := in.info.TypeOf(element.Array).(*checker.TArray).Length
+			if keys[i], err = in.evalIndex(element.Index, length, f); err != nil {
+				return err
+			}
+		case *parser.FieldExpr:
+			tipe := in.info.TypeOf(element.Struct).(*checker.TStruct)
+			for j, field := range tipe.Fields {
+				if field.Name == element.Field {
+					keys[i] = j
+				}
+			}
+		}
+	}
+
+	if list != nil {
+		elements[listIndex] = update(elements[listIndex], keys, newValue)
+		return nil
+	}
+	symbol := in.info.Uses[root.(*parser.IdentExpr)]
+	f[symbol] = update(f[symbol], keys, newValue)
+	return nil
+}
+
+// Returns a copy of a value with the element at the end of the path replaced
+func update(container Value, keys []int, value Value) Value {
+	if len(keys) == 0 {
+		return value
+	}
+	switch container := container.(type) {
+	case *Array:
+		element := update(container.Elements[keys[0]], keys[1:], value)
+		return &Array{Elements: replaced(container.Elements, keys[0], element)}
+	case *Struct:
+		field := update(container.Fields[keys[0]], keys[1:], value)
+		return &Struct{Type: container.Type, Fields: replaced(container.Fields, keys[0], field)}
+	}
+	panic("cannot update an element of " + container.Render())
+}
+
+func isList(t checker.Type) bool {
+	tipe, ok := checker.Resolve(t).(*checker.TCon)
+	return ok && tipe.Name == "list"
+}
+
+func (in *interpreter) eval(expression parser.Expression, f frame) (Value, error) {
+	// Constructors look like identifiers or calls
+	if name, ok := in.info.Variants[expression]; ok {
+		return in.evalConstructor(expression, name, f)
+	}
+
+	switch expression := expression.(type) {
+	case *parser.IntExpr:
+		return Int(expression.Value), nil
+	case *parser.BoolExpr:
+		return Bool(expression.Value), nil
+	case *parser.IdentExpr:
+		return f[in.info.Uses[expression]], nil
+	case *parser.AddExpr:
+		lhs, rhs, err := in.evalOperands(expression.Lhs, expression.Rhs, f)
+		return lhs + rhs, err
+	case *parser.SubExpr:
+		// The right operand is evaluated first
+		rhs, lhs, err := in.evalOperands(expression.Rhs, expression.Lhs, f)
+		return lhs - rhs, err
+	case *parser.LessThanExpr:
+		lhs, rhs, err := in.evalOperands(expression.Lhs, expression.Rhs, f)
+		return Bool(lhs < rhs), err
+	case *parser.GreaterThanExpr:
+		lhs, rhs, err := in.evalOperands(expression.Lhs, expression.Rhs, f)
+		return Bool(lhs > rhs), err
+	case *parser.BlockBodyExpr:
+		return in.evalBlock(expression, f)
+	case *parser.LambdaExpr:
+		return &Function{Lambda: expression}, nil
+	case *parser.CallExpr:
+		if _, ok := in.info.Builtins[expression]; ok {
+			return in.evalBuiltinCall(expression, f)
+		}
+		return in.evalCall(expression, f)
+	case *parser.ArrayExpr:
+		elements, err := in.evalBackwards(expression.Elements, f)
+		return &Array{Elements: elements}, err
+	case *parser.ListExpr:
+		elements, err := in.evalBackwards(expression.Elements, f)
+		return &List{Elements: elements}, err
+	case *parser.TupleExpr:
+		elements, err := in.evalBackwards(expression.Elements, f)
+		return &Tuple{Elements: elements}, err
+	case *parser.IndexExpr:
+		return in.evalIndexExpression(expression, f)
+	case *parser.StructExpr:
+		return in.evalStruct(expression, f)
+	case *parser.FieldExpr:
+		value, err := in.eval(expression.Struct, f)
+		if err != nil {
+			return nil, err
+		}
+		s := value.(*Struct)
+		return s.Fields[s.field(expression.Field)], nil
+	case *parser.MatchExpr:
+		return in.evalMatch(expression, f)
+	}
+	return nil, errors.New("unexpected expression type")
+}
+
+// Evaluates both operands of an infix operator, which are integers
+func (in *interpreter) evalOperands(first parser.Expression, second parser.Expression, f frame) (Int, Int, error) {
+	lhs, err := in.eval(first, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	rhs, err := in.eval(second, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lhs.(Int), rhs.(Int), nil
+}
+
+// Evaluates from the last expression to the first, the order in which the
+// compiled program pushes them, and returns the values from first to last
+func (in *interpreter) evalBackwards(expressions []parser.Expression, f frame) ([]Value, error) {
+	values := make([]Value, len(expressions))
+	for i := len(expressions) - 1; i >= 0; i-- {
+		value, err := in.eval(expressions[i], f)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (in *interpreter) evalBlock(expression *parser.BlockBodyExpr, f frame) (Value, error) {
+	if err := in.evalStatements(expression.Statements, f); err != nil {
+		return nil, err
+	}
+	return in.eval(expression.Final, f)
+}
+
+// The arguments are evaluated before the callee, and each call has its own frame
+func (in *interpreter) evalCall(expression *parser.CallExpr, f frame) (Value, error) {
+	arguments := make([]Value, 0, len(expression.Arguments))
+	for _, argument := range expression.Arguments {
+		value, err := in.eval(argument, f)
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, value)
+	}
+
+	callee, err := in.eval(expression.Callee, f)
+	if err != nil {
+		return nil, err
+	}
+	lambda := callee.(*Function).Lambda
+
+	callFrame := frame{}
+	for i := range lambda.Parameters {
+		callFrame[in.info.Defs[&lambda.Parameters[i]]] = arguments[i]
+	}
+
+	// Errors after the call returns are in the statement that made it
+	outer := in.position
+	value, err := in.evalBlock(&lambda.Body, callFrame)
+	if err == nil {
+		in.position = outer
+	}
+	return value, err
+}
+
+func (in *interpreter) evalBuiltinCall(expression *parser.CallExpr, f frame) (Value, error) {
+	value, err := in.eval(expression.Arguments[0], f)
+	if err != nil {
+		return nil, err
+	}
+	list := value.(*List)
+
+	switch in.info.Builtins[expression] {
+	case "len":
+		return Int(len(list.Elements)), nil
+	case "push":
+		value, err := in.eval(expression.Arguments[1], f)
+		if err != nil {
+			return nil, err
+		}
+		list.Elements = append(list.Elements, value)
+		return Int(len(list.Elements)), nil
+	}
+	return nil, errors.New("unexpected builtin")
+}
+
+func (in *interpreter) evalIndexExpression(expression *parser.IndexExpr, f frame) (Value, error) {
+	value, err := in.eval(expression.Array, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []Value
+	switch value := value.(type) {
+	case *Array:
+		elements = value.Elements
+	case *List:
+		elements = value.Elements
+	}
+
+	index, err := in.evalIndex(expression.Index, len(elements), f)
+	if err != nil {
+		return nil, err
+	}
+	return elements[index], nil
+}
+
+// Evaluates an index and checks it is in bounds
+func (in *interpreter) evalIndex(expression parser.Expression, length int, f frame) (int, error) {
+	value, err := in.eval(expression, f)
+	if err != nil {
+		return 0, err
+	}
+	index := value.(Int)
+	if index < 0 || index >= Int(length) {
+		return 0, errOutOfBounds
+	}
+	return int(index), nil
+}
+
+// The fields are evaluated from the last declared to the first, whatever order
+// they are written in
+func (in *interpreter) evalStruct(expression *parser.StructExpr, f frame) (Value, error) {
+	tipe := in.info.TypeOf(expression).(*checker.TStruct)
+	values := map[string]parser.Expression{}
+	for _, init := range expression.Fields {
+		values[init.Name] = init.Value
+	}
+
+	fields := make([]Value, len(tipe.Fields))
+	for i := len(tipe.Fields) - 1; i >= 0; i-- {
+		value, err := in.eval(values[tipe.Fields[i].Name], f)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = value
+	}
+	return &Struct{Type: tipe, Fields: fields}, nil
+}
+
+func (in *interpreter) evalConstructor(expression parser.Expression, name string, f frame) (Value, error) {
+	var arguments []parser.Expression
+	if call, ok := expression.(*parser.CallExpr); ok {
+		arguments = call.Arguments
+	}
+
+	payload, err := in.evalBackwards(arguments, f)
+	if err != nil {
+		return nil, err
+	}
+	return &Variant{Name: name, Payload: payload}, nil
+}
+
+// The checker made sure the arms are exhaustive, so one of them always matches
+func (in *interpreter) evalMatch(expression *parser.MatchExpr, f frame) (Value, error) {
+	value, err := in.eval(expression.Value, f)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range expression.Arms {
+		arm := &expression.Arms[i]
+		if arm.Variant == "_" {
+			return in.eval(arm.Body, f)
+		}
+
+		variant := value.(*Variant)
+		if variant.Name != arm.Variant {
+			continue
+		}
+		for j := range arm.Bindings {
+			f[in.info.Defs[&arm.Bindings[j]]] = variant.Payload[j]
+		}
+		return in.eval(arm.Body, f)
+	}
+	return nil, errors.New("no match arm matched " + value.Render())
+}
@@ -0,0 +1,149 @@
+package interpreter
+
+import (
+	"monkey/checker"
+	"monkey/lexer"
+	"monkey/parser"
+	"testing"
+)
+
+func runHelper(t *testing.T, s string) (Value, *RuntimeError) {
+	lexer := lexer.New(&s)
+	program, error := parser.ParseProgram(lexer)
+	if error != nil {
+		t.Fatal("Could not parse program: ", error)
+	}
+	info, checkErr := checker.Check(*program)
+	if checkErr != nil {
+		t.Fatalf("Failed to check: %s", checkErr.ToError("foo"))
+	}
+	return Run(*program, info)
+}
+
+func expectResult(t *testing.T, s string, expected string) {
+	result, err := runHelper(t, s)
+	if err != nil {
+		t.Fatalf("Failed to run: %s", err.ToError("foo"))
+	}
+	if result.Render() != expected {
+		t.Fatalf("Expected the program to produce %s, got %s", expected, result.Render())
+	}
+}
+
+func TestRunArithmetic(t *testing.T) {
+	expectResult(t, "let x = 10 - 3 let y = x + 2", "9")
+	expectResult(t, "let x = 3 let y = x < 4", "true")
+	expectResult(t, "let add = def (a, b) { a + b } let y = add(1, 2)", "3")
+	expectResult(t, "let x = { let y = 4 y + 1 }", "5")
+}
+
+func TestRunLoops(t *testing.T) {
+	expectResult(t, `
+		var total = 0
+		for i in 1..=10 {
+			total = total + i
+		}
+		var i = 0
+		while i < 5 {
+			i = i + 1
+		}
+		let result = total + i
+	`, "60")
+
+	expectResult(t, `
+		var total = 0
+		for i in 0..10 {
+			total = total + i
+			break
+		}
+		var count = 0
+		for i in 0..10 {
+			continue
+			count = count + 1
+		}
+		let result = total + count
+	`, "0")
+}
+
+func TestRunCollections(t *testing.T) {
+	expectResult(t, `
+		var xs = [[1, 2], [3, 4]]
+		let ys = xs
+		xs[1][0] = 7
+		let result = (xs, ys)
+	`, "([[1, 2], [7, 4]], [[1, 2], [3, 4]])")
+
+	// Lists are shared, and loops see the elements pushed while they run
+	expectResult(t, `
+		let xs = list[1, 2]
+		var ys = xs
+		var total = 0
+		for x in xs {
+			total = total + x
+			while len(xs) < 4 {
+				let n = push(ys, 10)
+			}
+		}
+		ys[0] = 5
+		let result = (xs, total)
+	`, "(list[5, 2, 10, 10], 23)")
+
+	expectResult(t, `
+		type Point = { x: int, y: int }
+		var p = Point { y: 2, x: 1 }
+		p.y = 3
+		let (a, b) = (p, p.x)
+		let result = a
+	`, "Point { x: 1, y: 3 }")
+}
+
+func TestRunMatch(t *testing.T) {
+	expectResult(t, `
+		type Shape = Circle(int) | Rect(int, int) | Empty
+		let area = def (s: Shape) {
+			match s {
+				Circle(r) => r + r,
+				Rect(w, h) => w - h,
+				_ => 0,
+			}
+		}
+		let result = (area(Circle(3)), area(Rect(5, 2)), area(Empty))
+	`, "(6, 3, 0)")
+
+	expectResult(t, `
+		let get = def (o) {
+			match o {
+				some(x) => x,
+				none => 0,
+			}
+		}
+		let result = (get(some(4)), get(none), some(true))
+	`, "(4, 0, some(true))")
+}
+
+func TestRunOutOfBounds(t *testing.T) {
+	_, err := runHelper(t, `
+		let xs = [1, 2, 3]
+		var ys = list[xs]
+		ys[1][0] = 3
+	`)
+	if err == nil || err.Error != errOutOfBounds || err.ExitCode != EXIT_OUT_OF_BOUNDS {
+		t.Fatalf("Expected indexing out of bounds, got %v", err)
+	}
+	if err.Position != 41 {
+		t.Fatalf("Expected the error at position 41, got %d", err.Position)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for value, expected := range map[Value]int{
+		Int(3):     3,
+		Int(258):   2,
+		Bool(true): 1,
+		&Tuple{}:   0,
+	} {
+		if code := ExitCode(value); code != expected {
+			t.Fatalf("Expected %s to exit with %d, got %d", value.Render(), expected, code)
+		}
+	}
+}
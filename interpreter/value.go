@@ -0,0 +1,133 @@
+package interpreter
+
+import (
+	"fmt"
+	"monkey/checker"
+	"monkey/parser"
+	"strings"
+)
+
+type (
+	// Values are produced by evaluating expressions. Arrays, tuples, structs
+	// and variants are never modified once they have been made, so they can
+	// be shared where the compiled program would copy them. Lists are shared
+	// by reference, like on the heap of the compiled program.
+	Value interface {
+		isValue()
+		Render() string
+	}
+)
+
+// Integers wrap around like machine words
+type Int int64
+
+func (Int) isValue() {}
+func (i Int) Render() string {
+	return fmt.Sprint(int64(i))
+}
+
+type Bool bool
+
+func (Bool) isValue() {}
+func (b Bool) Render() string {
+	return fmt.Sprint(bool(b))
+}
+
+// Functions can only see their own parameters, so they don't capture anything
+type Function struct {
+	Lambda *parser.LambdaExpr
+}
+
+func (*Function) isValue() {}
+func (f *Function) Render() string {
+	return "<function>"
+}
+
+type Array struct {
+	Elements []Value
+}
+
+func (*Array) isValue() {}
+func (a *Array) Render() string {
+	return fmt.Sprint("[", renderAll(a.Elements), "]")
+}
+
+type Tuple struct {
+	Elements []Value
+}
+
+func (*Tuple) isValue() {}
+func (t *Tuple) Render() string {
+	return fmt.Sprint("(", renderAll(t.Elements), ")")
+}
+
+// The fields are in the order they were declared in
+type Struct struct {
+	Type   *checker.TStruct
+	Fields []Value
+}
+
+func (*Struct) isValue() {}
+func (s *Struct) Render() string {
+	var b = strings.Builder{}
+	b.WriteString(s.Type.Name)
+	b.WriteString(" { ")
+	for i, field := range s.Type.Fields {
+		b.WriteString(fmt.Sprint(field.Name, ": ", s.Fields[i].Render()))
+		if i < len(s.Fields)-1 {
+			b.WriteString(", ")
+		}
+	}
+	b.WriteString(" }")
+	return b.String()
+}
+
+// Returns the index of the field with the given name
+func (s *Struct) field(name string) int {
+	for i, field := range s.Type.Fields {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// A variant of a union or an option
+type Variant struct {
+	Name    string
+	Payload []Value
+}
+
+func (*Variant) isValue() {}
+func (v *Variant) Render() string {
+	if len(v.Payload) == 0 {
+		return v.Name
+	}
+	return fmt.Sprint(v.Name, "(", renderAll(v.Payload), ")")
+}
+
+// Lists are the only values that can change
+type List struct {
+	Elements []Value
+}
+
+func (*List) isValue() {}
+func (l *List) Render() string {
+	return fmt.Sprint("list[", renderAll(l.Elements), "]")
+}
+
+func renderAll(values []Value) string {
+	rendered := make([]string, 0, len(values))
+	for _, value := range values {
+		rendered = append(rendered, value.Render())
+	}
+	return strings.Join(rendered, ", ")
+}
+
+// Returns a copy of the values with one of them replaced
+func replaced(values []Value, i int, value Value) []Value {
+	copied := make([]Value, len(values))
+	copy(copied, values)
+	copied[i] = value
+	return copied
+}
@@ -0,0 +1,249 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+A three-address intermediate representation of a checked program. Each
+function is a list of basic blocks, and each block is a list of instructions
+ending in exactly one terminator, which is the only place control can leave
+the block.
+
+Values live in virtual registers, of which a function can have any number.
+Registers are not in SSA form: a mutable binding keeps its register for its
+whole lifetime, and reassigning it copies the new value into that register.
+Every value is a machine word, the type of a register only says what the word
+holds.
+*/
+
+type Type int
+
+const (
+	Int Type = iota
+	Bool
+	// The address of a function
+	Arrow
+	// A value of a type parameter, which can be any of the other types
+	Generic
+)
+
+func (t Type) Render() string {
+	switch t {
+	case Int:
+		return "int"
+	case Bool:
+		return "bool"
+	case Arrow:
+		return "arrow"
+	}
+	return "generic"
+}
+
+// A virtual register, numbered from 0 in each function
+type Register int
+
+func (r Register) Render() string {
+	return fmt.Sprint("r", r)
+}
+
+type Program struct {
+	// The first function is the top level of the program, it ends in an
+	// Exit instead of a Return
+	Functions []*Function
+}
+
+type Function struct {
+	Params []Register
+
+	// The type of each register, indexed by the register
+	Registers []Type
+
+	// The first block is the entry of the function
+	Blocks []*Block
+}
+
+type Block struct {
+	// The index of the block in Function.Blocks
+	Id           int
+	Instructions []Instruction
+	Terminator   Terminator
+}
+
+type (
+	Instruction interface {
+		isInstruction()
+		Render() string
+	}
+
+	// Ends a basic block
+	Terminator interface {
+		isTerminator()
+		Render() string
+	}
+)
+
+// Instructions -----------------------
+type Const struct {
+	Dest  Register
+	Value int64
+}
+
+func (*Const) isInstruction() {}
+func (i *Const) Render() string {
+	return fmt.Sprintf("%s = const %d", i.Dest.Render(), i.Value)
+}
+
+type Copy struct {
+	Dest Register
+	Src  Register
+}
+
+func (*Copy) isInstruction() {}
+func (i *Copy) Render() string {
+	return fmt.Sprintf("%s = copy %s", i.Dest.Render(), i.Src.Render())
+}
+
+type Operator int
+
+const (
+	Add Operator = iota
+	Sub
+	LessThan
+	GreaterThan
+)
+
+var operators = map[Operator]string{
+	Add:         "add",
+	Sub:         "sub",
+	LessThan:    "lt",
+	GreaterThan: "gt",
+}
+
+// Dest = Lhs operator Rhs, comparisons give a bool
+type Binary struct {
+	Op   Operator
+	Dest Register
+	Lhs  Register
+	Rhs  Register
+}
+
+func (*Binary) isInstruction() {}
+func (i *Binary) Render() string {
+	return fmt.Sprintf("%s = %s %s, %s", i.Dest.Render(), operators[i.Op], i.Lhs.Render(), i.Rhs.Render())
+}
+
+// Loads the address of the function with the given index in the program
+type FunctionAddress struct {
+	Dest     Register
+	Function int
+}
+
+func (*FunctionAddress) isInstruction() {}
+func (i *FunctionAddress) Render() string {
+	return fmt.Sprintf("%s = function %d", i.Dest.Render(), i.Function)
+}
+
+// The arguments are evaluated before the callee, so they are passed in the
+// order they were written
+type Call struct {
+	Dest      Register
+	Callee    Register
+	Arguments []Register
+}
+
+func (*Call) isInstruction() {}
+func (i *Call) Render() string {
+	arguments := make([]string, 0, len(i.Arguments))
+	for _, argument := range i.Arguments {
+		arguments = append(arguments, argument.Render())
+	}
+	return fmt.Sprintf("%s = call %s(%s)", i.Dest.Render(), i.Callee.Render(), strings.Join(arguments, ", "))
+}
+
+// Terminators ------------------------
+type Jump struct {
+	Target *Block
+}
+
+func (*Jump) isTerminator() {}
+func (t *Jump) Render() string {
+	return fmt.Sprint("jump b", t.Target.Id)
+}
+
+// Goes to Then if the bool in Cond is true, otherwise to Else
+type Branch struct {
+	Cond Register
+	Then *Block
+	Else *Block
+}
+
+func (*Branch) isTerminator() {}
+func (t *Branch) Render() string {
+	return fmt.Sprintf("branch %s, b%d, b%d", t.Cond.Render(), t.Then.Id, t.Else.Id)
+}
+
+type Return struct {
+	Value Register
+}
+
+func (*Return) isTerminator() {}
+func (t *Return) Render() string {
+	return fmt.Sprint("return ", t.Value.Render())
+}
+
+// Ends the program with the value as its status
+type Exit struct {
+	Value Register
+}
+
+func (*Exit) isTerminator() {}
+func (t *Exit) Render() string {
+	return fmt.Sprint("exit ", t.Value.Render())
+}
+
+// Renders a program one instruction per line, with the type of each register
+// after the instruction that defines it
+func (p *Program) Render() string {
+	var b = strings.Builder{}
+	for i, function := range p.Functions {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		params := make([]string, 0, len(function.Params))
+		for _, param := range function.Params {
+			params = append(params, fmt.Sprint(param.Render(), ": ", function.Registers[param].Render()))
+		}
+		b.WriteString(fmt.Sprintf("function %d(%s)\n", i, strings.Join(params, ", ")))
+		for _, block := range function.Blocks {
+			b.WriteString(fmt.Sprintf("b%d:\n", block.Id))
+			for _, instruction := range block.Instructions {
+				b.WriteString("\t" + instruction.Render())
+				if dest, ok := Defines(instruction); ok {
+					b.WriteString(" : " + function.Registers[dest].Render())
+				}
+				b.WriteString("\n")
+			}
+			b.WriteString("\t" + block.Terminator.Render() + "\n")
+		}
+	}
+	return b.String()
+}
+
+// Returns the register an instruction writes to
+func Defines(instruction Instruction) (Register, bool) {
+	switch instruction := instruction.(type) {
+	case *Const:
+		return instruction.Dest, true
+	case *Copy:
+		return instruction.Dest, true
+	case *Binary:
+		return instruction.Dest, true
+	case *FunctionAddress:
+		return instruction.Dest, true
+	case *Call:
+		return instruction.Dest, true
+	}
+	return 0, false
+}
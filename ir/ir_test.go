@@ -0,0 +1,295 @@
+package ir
+
+import (
+	"fmt"
+	"monkey/checker"
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func lowerHelper(t *testing.T, s string) (*Program, error) {
+	lexer := lexer.New(&s)
+	program, error := parser.ParseProgram(lexer)
+	if error != nil {
+		t.Fatal("Could not parse program: ", error)
+	}
+	info, checkErr := checker.Check(*program)
+	if checkErr != nil {
+		t.Fatalf("Failed to check: %s", checkErr.ToError("foo"))
+	}
+	return Lower(*program, info)
+}
+
+func expectLowered(t *testing.T, s string, expected string) {
+	program, err := lowerHelper(t, s)
+	if err != nil {
+		t.Fatalf("Failed to lower: %s", err)
+	}
+	if rendered := program.Render(); rendered != expected {
+		t.Fatalf("Expected the program to lower to\n%s\ngot\n%s", expected, rendered)
+	}
+}
+
+func TestLowerArithmetic(t *testing.T) {
+	// The right operand of a subtraction is evaluated first, and binding an
+	// identifier copies it
+	expectLowered(t, "let x = 1 let y = x let z = y - x + 2 < 3", `function 0()
+b0:
+	r0 = const 1 : int
+	r1 = copy r0 : int
+	r2 = sub r1, r0 : int
+	r3 = const 2 : int
+	r4 = add r2, r3 : int
+	r5 = const 3 : int
+	r6 = lt r4, r5 : bool
+	exit r6
+`)
+}
+
+func TestLowerFunctions(t *testing.T) {
+	expectLowered(t, `
+		let id = def (x) { x }
+		let add = def (a: int, b: int) -> int { let c = a + b c }
+		let n = add(id(1), 2)
+	`, `function 0()
+b0:
+	r0 = function 1 : arrow
+	r1 = function 2 : arrow
+	r2 = const 1 : int
+	r3 = call r0(r2) : int
+	r4 = const 2 : int
+	r5 = call r1(r3, r4) : int
+	exit r5
+
+function 1(r0: generic)
+b0:
+	return r0
+
+function 2(r0: int, r1: int)
+b0:
+	r2 = add r0, r1 : int
+	return r2
+`)
+}
+
+func TestLowerLoops(t *testing.T) {
+	expectLowered(t, `
+		var x = 0
+		while x < 10 {
+			x = x + 1
+			continue
+		}
+		for i in 0..=x {
+			break
+		}
+	`, `function 0()
+b0:
+	r0 = const 0 : int
+	jump b1
+b1:
+	r1 = const 10 : int
+	r2 = lt r0, r1 : bool
+	branch r2, b2, b4
+b2:
+	r3 = const 1 : int
+	r4 = add r0, r3 : int
+	r0 = copy r4 : int
+	jump b1
+b3:
+	jump b1
+b4:
+	r5 = const 0 : int
+	r6 = copy r0 : int
+	jump b5
+b5:
+	r7 = gt r5, r6 : bool
+	branch r7, b9, b6
+b6:
+	jump b9
+b7:
+	jump b8
+b8:
+	r8 = const 1 : int
+	r5 = add r5, r8 : int
+	jump b5
+b9:
+	r9 = const 0 : int
+	exit r9
+`)
+}
+
+func TestLowerExitStatus(t *testing.T) {
+	// Only ints and bools give a status
+	expectLowered(t, "let f = def (x: int) -> int { x }", `function 0()
+b0:
+	r0 = function 1 : arrow
+	r1 = const 0 : int
+	exit r1
+
+function 1(r0: int)
+b0:
+	return r0
+`)
+
+	// Nor does a reassignment, even of an int
+	expectLowered(t, "var x = 1 x = 7", `function 0()
+b0:
+	r0 = const 1 : int
+	r1 = const 7 : int
+	r0 = copy r1 : int
+	r2 = const 0 : int
+	exit r2
+`)
+}
+
+func TestLowerUnsupported(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let xs = [1, 2]", "arrays"},
+		{"let xs = list[1] let n = len(xs)", "lists"},
+		{"let n = len(list[1])", "builtin functions"},
+		{"let t = (1, true)", "tuples"},
+		{"type Point = { x: int } let p = Point { x: 1 }", "structs"},
+		{"let f = def (p: (int, int)) -> int { 1 }", "values of type"},
+	}
+
+	for _, tt := range tests {
+		_, err := lowerHelper(t, tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Expected %q not to lower because of %s, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestIntervals(t *testing.T) {
+	program, err := lowerHelper(t, `
+		let f = def (x: int) -> int { x }
+		var total = 0
+		while total < 10 {
+			let n = f(1)
+			total = total + n
+		}
+	`)
+	if err != nil {
+		t.Fatalf("Failed to lower: %s", err)
+	}
+
+	// f and total are live around the whole loop, as the loop jumps back
+	// to its condition, and n is only live until it is added
+	expected := []Interval{
+		{Register: 0, Start: 1, End: 11, CrossesCall: true},
+		{Register: 1, Start: 2, End: 11, CrossesCall: true},
+		{Register: 2, Start: 4, End: 5},
+		{Register: 3, Start: 5, End: 6},
+		{Register: 4, Start: 7, End: 8},
+		{Register: 5, Start: 8, End: 9},
+		{Register: 6, Start: 9, End: 10},
+		{Register: 7, Start: 12, End: 13},
+	}
+	intervals := Intervals(program.Functions[0])
+	if fmt.Sprint(intervals) != fmt.Sprint(expected) {
+		t.Fatalf("Expected the intervals\n%v\ngot\n%v\nfor\n%s", expected, intervals, program.Render())
+	}
+
+	params := Intervals(program.Functions[1])
+	if len(params) != 1 || params[0].Start != 0 || params[0].End != 1 {
+		t.Fatalf("Expected the parameter to be live from the entry to the return, got %v", params)
+	}
+}
+
+func expectOptimised(t *testing.T, s string, expected string) {
+	program, err := lowerHelper(t, s)
+	if err != nil {
+		t.Fatalf("Failed to lower: %s", err)
+	}
+	Optimise(program)
+	if rendered := program.Render(); rendered != expected {
+		t.Fatalf("Expected the program to be optimised to\n%s\ngot\n%s", expected, rendered)
+	}
+}
+
+func TestOptimiseFoldsConstants(t *testing.T) {
+	expectOptimised(t, "let x = 2 + 3 let y = x - 1 < x let z = y", `function 0()
+b0:
+	r6 = const 1 : bool
+	exit r6
+`)
+}
+
+func TestOptimiseFoldsReassignedBindings(t *testing.T) {
+	// x is written twice, but the value it holds is known within the block
+	expectOptimised(t, "var x = 1 let y = x x = 2 + 3 let z = x + y", `function 0()
+b0:
+	r5 = const 6 : int
+	exit r5
+`)
+}
+
+func TestOptimiseKeepsLoopVariables(t *testing.T) {
+	// x is written in the loop, so it isn't known to be 1 in the condition
+	expectOptimised(t, "var x = 1 while x < 3 { x = x + 1 } let y = x", `function 0()
+b0:
+	r0 = const 1 : int
+	jump b1
+b1:
+	r1 = const 3 : int
+	r2 = lt r0, r1 : bool
+	branch r2, b2, b3
+b2:
+	r3 = const 1 : int
+	r4 = add r0, r3 : int
+	r0 = copy r4 : int
+	jump b1
+b3:
+	r5 = copy r0 : int
+	exit r5
+`)
+}
+
+func TestOptimisePrunesBranches(t *testing.T) {
+	expectOptimised(t, `
+		var x = 0
+		while false {
+			x = x + 1
+		}
+		while true {
+			x = x + 2
+			break
+		}
+		let y = x
+	`, `function 0()
+b0:
+	r7 = const 2 : int
+	exit r7
+`)
+}
+
+func TestOptimiseRemovesUnusedFunctions(t *testing.T) {
+	// Calls are kept even if their result is not used, and so is the
+	// function they call
+	expectOptimised(t, `
+		let unused = def (x: int) -> int { x }
+		let f = def (x: int) -> int { let g = def (y: int) -> int { y } g(x) }
+		let a = f(1)
+	`, `function 0()
+b0:
+	r1 = function 1 : arrow
+	r2 = const 1 : int
+	r3 = call r1(r2) : int
+	exit r3
+
+function 1(r0: int)
+b0:
+	r1 = function 2 : arrow
+	r2 = call r1(r0) : int
+	return r2
+
+function 2(r0: int)
+b0:
+	return r0
+`)
+}
@@ -0,0 +1,198 @@
+package ir
+
+import "sort"
+
+/*
+Live intervals for register allocation. The instructions of a function are
+numbered in the order of its blocks, starting from 1, and the terminator of
+each block is numbered after its instructions. Position 0 is the entry of the
+function, where the parameters are defined.
+
+A register is live from where it is defined to where it is last read. Since
+registers can be written more than once and blocks can jump backwards, the
+interval of a register is the smallest range that covers every position it is
+live at, which may include positions it is not live at.
+*/
+
+type Interval struct {
+	Register Register
+	Start    int
+	End      int
+
+	// Whether there is a call after Start and before End, which would
+	// clobber the value if it was kept in a register the callee can use
+	CrossesCall bool
+}
+
+// Returns the registers an instruction reads
+func Uses(instruction Instruction) []Register {
+	switch instruction := instruction.(type) {
+	case *Copy:
+		return []Register{instruction.Src}
+	case *Binary:
+		return []Register{instruction.Lhs, instruction.Rhs}
+	case *Call:
+		return append([]Register{instruction.Callee}, instruction.Arguments...)
+	}
+	return []Register{}
+}
+
+// Returns the registers a terminator reads
+func TerminatorUses(terminator Terminator) []Register {
+	switch terminator := terminator.(type) {
+	case *Branch:
+		return []Register{terminator.Cond}
+	case *Return:
+		return []Register{terminator.Value}
+	case *Exit:
+		return []Register{terminator.Value}
+	}
+	return []Register{}
+}
+
+// Returns the blocks control can go to after a block
+func Successors(block *Block) []*Block {
+	switch terminator := block.Terminator.(type) {
+	case *Jump:
+		return []*Block{terminator.Target}
+	case *Branch:
+		return []*Block{terminator.Then, terminator.Else}
+	}
+	return []*Block{}
+}
+
+type registerSet map[Register]bool
+
+// Returns the registers that are live on entry to each block, and on exit
+// from it, indexed by the id of the block
+func liveness(fn *Function) ([]registerSet, []registerSet) {
+	uses := make([]registerSet, len(fn.Blocks))
+	defs := make([]registerSet, len(fn.Blocks))
+	for _, block := range fn.Blocks {
+		uses[block.Id] = registerSet{}
+		defs[block.Id] = registerSet{}
+		read := func(registers []Register) {
+			for _, register := range registers {
+				if !defs[block.Id][register] {
+					uses[block.Id][register] = true
+				}
+			}
+		}
+		for _, instruction := range block.Instructions {
+			read(Uses(instruction))
+			if dest, ok := Defines(instruction); ok {
+				defs[block.Id][dest] = true
+			}
+		}
+		read(TerminatorUses(block.Terminator))
+	}
+
+	liveIn := make([]registerSet, len(fn.Blocks))
+	liveOut := make([]registerSet, len(fn.Blocks))
+	for i := range fn.Blocks {
+		liveIn[i] = registerSet{}
+		liveOut[i] = registerSet{}
+	}
+
+	// Going backwards through the blocks reaches the fixed point sooner, as
+	// most blocks come before their successors
+	for changed := true; changed; {
+		changed = false
+		for i := len(fn.Blocks) - 1; i >= 0; i-- {
+			block := fn.Blocks[i]
+			for _, successor := range Successors(block) {
+				for register := range liveIn[successor.Id] {
+					if !liveOut[i][register] {
+						liveOut[i][register] = true
+						changed = true
+					}
+				}
+			}
+			for register := range liveOut[i] {
+				if !defs[i][register] && !liveIn[i][register] {
+					liveIn[i][register] = true
+					changed = true
+				}
+			}
+			for register := range uses[i] {
+				if !liveIn[i][register] {
+					liveIn[i][register] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return liveIn, liveOut
+}
+
+// Returns the interval of every register of the function, ordered by where
+// they start. Registers that are never read still get an interval that covers
+// where they are written.
+func Intervals(fn *Function) []Interval {
+	liveIn, liveOut := liveness(fn)
+
+	intervals := make([]Interval, len(fn.Registers))
+	seen := make([]bool, len(fn.Registers))
+	extend := func(register Register, position int) {
+		interval := &intervals[register]
+		if !seen[register] {
+			*interval = Interval{Register: register, Start: position, End: position}
+			seen[register] = true
+		}
+		if position < interval.Start {
+			interval.Start = position
+		}
+		if position > interval.End {
+			interval.End = position
+		}
+	}
+
+	for _, param := range fn.Params {
+		extend(param, 0)
+	}
+
+	calls := []int{}
+	position := 1
+	for _, block := range fn.Blocks {
+		for register := range liveIn[block.Id] {
+			extend(register, position)
+		}
+		for _, instruction := range block.Instructions {
+			for _, register := range Uses(instruction) {
+				extend(register, position)
+			}
+			if dest, ok := Defines(instruction); ok {
+				extend(dest, position)
+			}
+			if _, ok := instruction.(*Call); ok {
+				calls = append(calls, position)
+			}
+			position++
+		}
+		for _, register := range TerminatorUses(block.Terminator) {
+			extend(register, position)
+		}
+		for register := range liveOut[block.Id] {
+			extend(register, position)
+		}
+		position++
+	}
+
+	result := []Interval{}
+	for register := range intervals {
+		if !seen[register] {
+			continue
+		}
+		interval := intervals[register]
+		for _, call := range calls {
+			if interval.Start < call && call < interval.End {
+				interval.CrossesCall = true
+			}
+		}
+		result = append(result, interval)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Start < result[j].Start
+	})
+	return result
+}
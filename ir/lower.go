@@ -0,0 +1,425 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+	"monkey/checker"
+	"monkey/parser"
+)
+
+/*
+Lowers a checked program to the IR. Only programs whose values all fit in a
+machine word can be lowered for now: ints, bools and functions of them. Any
+other program returns an error, and has to be compiled from the AST instead.
+
+Operands are evaluated in the same order as in the other backends, so the
+right operand of a subtraction comes before the left one, and the arguments
+of a call come before the callee.
+*/
+func Lower(program parser.Program, info *checker.Info) (*Program, error) {
+	l := &lowering{info: info, program: &Program{}}
+	b := l.newFunction()
+
+	for _, statement := range program.Statements {
+		if err := b.lowerStatement(statement); err != nil {
+			return nil, err
+		}
+	}
+
+	// The program exits with the binding chosen by info.ExitBinding, or 0
+	status, ok := b.bindings[info.ExitBinding(program)]
+	if !ok {
+		status = b.newRegister(Int)
+		b.emit(&Const{Dest: status, Value: 0})
+	}
+	b.block.Terminator = &Exit{Value: status}
+	return l.program, nil
+}
+
+type lowering struct {
+	info    *checker.Info
+	program *Program
+}
+
+// Builds one function, appending instructions to the current block
+type builder struct {
+	*lowering
+	fn       *Function
+	block    *Block
+	bindings map[*checker.Symbol]Register
+	loop     *loop
+}
+
+// The blocks that continue and break jump to in the innermost loop
+type loop struct {
+	next  *Block
+	after *Block
+}
+
+func unsupported(what string) error {
+	return errors.New(fmt.Sprint(what, " can not be lowered to IR"))
+}
+
+// Adds a function to the program and returns a builder positioned in its entry block
+func (l *lowering) newFunction() *builder {
+	b := &builder{
+		lowering: l,
+		fn:       &Function{Params: []Register{}, Registers: []Type{}, Blocks: []*Block{}},
+		bindings: map[*checker.Symbol]Register{},
+	}
+	l.program.Functions = append(l.program.Functions, b.fn)
+	b.startBlock(newBlock())
+	return b
+}
+
+func (b *builder) newRegister(tipe Type) Register {
+	b.fn.Registers = append(b.fn.Registers, tipe)
+	return Register(len(b.fn.Registers) - 1)
+}
+
+// Blocks are only numbered once they are started, so they are laid out in
+// the order they are started in, which is not always the order they are
+// created in. E.g. break jumps to the block after its loop.
+func newBlock() *Block {
+	return &Block{Id: -1, Instructions: []Instruction{}}
+}
+
+func (b *builder) startBlock(block *Block) {
+	block.Id = len(b.fn.Blocks)
+	b.fn.Blocks = append(b.fn.Blocks, block)
+	b.block = block
+}
+
+func (b *builder) emit(instruction Instruction) {
+	b.block.Instructions = append(b.block.Instructions, instruction)
+}
+
+// Ends the current block and starts the next one
+func (b *builder) terminate(terminator Terminator, next *Block) {
+	b.block.Terminator = terminator
+	b.startBlock(next)
+}
+
+// Converts the type the checker gave a value to the type of its register
+func (b *builder) typeOf(t checker.Type) (Type, error) {
+	switch t := checker.Resolve(t).(type) {
+	case *checker.TCon:
+		switch t.Name {
+		case "int":
+			return Int, nil
+		case "bool":
+			return Bool, nil
+		}
+	case *checker.TArrow:
+		for _, param := range t.Params {
+			if _, err := b.typeOf(param); err != nil {
+				return Int, err
+			}
+		}
+		if _, err := b.typeOf(t.Returns); err != nil {
+			return Int, err
+		}
+		return Arrow, nil
+	case *checker.TVar:
+		return Generic, nil
+	}
+	return Int, unsupported(fmt.Sprint("values of type ", t.Render()))
+}
+
+// Returns a new register for the value of an expression
+func (b *builder) registerFor(expression parser.Expression) (Register, error) {
+	tipe, err := b.typeOf(b.info.TypeOf(expression))
+	if err != nil {
+		return 0, err
+	}
+	return b.newRegister(tipe), nil
+}
+
+func (b *builder) lowerStatement(statement parser.Statement) error {
+	switch statement := statement.(type) {
+	case *parser.AssignStmt:
+		value, err := b.lowerBound(statement.Rhs)
+		if err != nil {
+			return err
+		}
+		b.bindings[b.info.Defs[statement]] = value
+		return nil
+	case *parser.ReassignStmt:
+		lhs, ok := statement.Lhs.(*parser.IdentExpr)
+		if !ok {
+			return unsupported("assignments to elements")
+		}
+		value, err := b.lowerExpression(statement.Rhs)
+		if err != nil {
+			return err
+		}
+		b.emit(&Copy{Dest: b.bindings[b.info.Uses[lhs]], Src: value})
+		return nil
+	case *parser.TypeDeclStmt:
+		// Types only exist at compile time
+		return nil
+	case *parser.WhileStmt:
+		return b.lowerWhileStmt(statement)
+	case *parser.ForStmt:
+		return b.lowerForStmt(statement)
+	case *parser.BreakStmt:
+		// Anything after it in the loop body is unreachable
+		b.terminate(&Jump{Target: b.loop.after}, newBlock())
+		return nil
+	case *parser.ContinueStmt:
+		b.terminate(&Jump{Target: b.loop.next}, newBlock())
+		return nil
+	case *parser.DestructureStmt:
+		return unsupported("destructuring")
+	}
+	return errors.New("unexpected statement type")
+}
+
+// Evaluates an expression into a register of its own, which a binding can
+// keep. Identifiers would otherwise give the register of another binding.
+func (b *builder) lowerBound(expression parser.Expression) (Register, error) {
+	value, err := b.lowerExpression(expression)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := expression.(*parser.IdentExpr); !ok {
+		return value, nil
+	}
+	bound := b.newRegister(b.fn.Registers[value])
+	b.emit(&Copy{Dest: bound, Src: value})
+	return bound, nil
+}
+
+// Lowers the body of a loop, where continue goes to next and break to after
+func (b *builder) lowerLoopBody(statements []parser.Statement, next *Block, after *Block) error {
+	outer := b.loop
+	b.loop = &loop{next: next, after: after}
+	defer func() { b.loop = outer }()
+
+	for _, statement := range statements {
+		if err := b.lowerStatement(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+The condition is evaluated before every iteration:
+
+	cond:   branch on the condition to body or after
+	body:   ...
+	        jump cond
+	after:
+*/
+func (b *builder) lowerWhileStmt(statement *parser.WhileStmt) error {
+	cond := newBlock()
+	body := newBlock()
+	after := newBlock()
+
+	b.terminate(&Jump{Target: cond}, cond)
+	value, err := b.lowerExpression(statement.Cond)
+	if err != nil {
+		return err
+	}
+	b.terminate(&Branch{Cond: value, Then: body, Else: after}, body)
+
+	if err := b.lowerLoopBody(statement.Body, cond, after); err != nil {
+		return err
+	}
+	b.terminate(&Jump{Target: cond}, after)
+	return nil
+}
+
+/*
+The bounds are evaluated once, before the first iteration:
+
+	        i = from, bound = to
+	cond:   branch on i < bound to body or after, or on i > bound to after
+	        or body if the range is inclusive
+	body:   ...
+	next:   i = i + 1
+	        jump cond
+	after:
+*/
+func (b *builder) lowerForStmt(statement *parser.ForStmt) error {
+	if statement.To == nil {
+		return unsupported("loops over lists")
+	}
+
+	variable, err := b.lowerBound(statement.From)
+	if err != nil {
+		return err
+	}
+	bound, err := b.lowerBound(statement.To)
+	if err != nil {
+		return err
+	}
+	b.bindings[b.info.Defs[statement]] = variable
+
+	cond := newBlock()
+	body := newBlock()
+	next := newBlock()
+	after := newBlock()
+
+	b.terminate(&Jump{Target: cond}, cond)
+	done := b.newRegister(Bool)
+	if statement.Inclusive {
+		b.emit(&Binary{Op: GreaterThan, Dest: done, Lhs: variable, Rhs: bound})
+		b.terminate(&Branch{Cond: done, Then: after, Else: body}, body)
+	} else {
+		b.emit(&Binary{Op: LessThan, Dest: done, Lhs: variable, Rhs: bound})
+		b.terminate(&Branch{Cond: done, Then: body, Else: after}, body)
+	}
+
+	if err := b.lowerLoopBody(statement.Body, next, after); err != nil {
+		return err
+	}
+	b.terminate(&Jump{Target: next}, next)
+
+	one := b.newRegister(Int)
+	b.emit(&Const{Dest: one, Value: 1})
+	b.emit(&Binary{Op: Add, Dest: variable, Lhs: variable, Rhs: one})
+	b.terminate(&Jump{Target: cond}, after)
+	return nil
+}
+
+// Returns the register holding the value of the expression
+func (b *builder) lowerExpression(expression parser.Expression) (Register, error) {
+	if _, ok := b.info.Variants[expression]; ok {
+		return 0, unsupported("unions")
+	}
+
+	switch expression := expression.(type) {
+	case *parser.IntExpr:
+		dest := b.newRegister(Int)
+		b.emit(&Const{Dest: dest, Value: int64(expression.Value)})
+		return dest, nil
+	case *parser.BoolExpr:
+		dest := b.newRegister(Bool)
+		value := int64(0)
+		if expression.Value {
+			value = 1
+		}
+		b.emit(&Const{Dest: dest, Value: value})
+		return dest, nil
+	case *parser.IdentExpr:
+		return b.bindings[b.info.Uses[expression]], nil
+	case *parser.AddExpr:
+		return b.lowerBinary(Add, expression.Lhs, expression.Rhs, false)
+	case *parser.SubExpr:
+		return b.lowerBinary(Sub, expression.Lhs, expression.Rhs, true)
+	case *parser.LessThanExpr:
+		return b.lowerBinary(LessThan, expression.Lhs, expression.Rhs, false)
+	case *parser.GreaterThanExpr:
+		return b.lowerBinary(GreaterThan, expression.Lhs, expression.Rhs, false)
+	case *parser.BlockBodyExpr:
+		return b.lowerBlockBody(expression)
+	case *parser.LambdaExpr:
+		return b.lowerLambda(expression)
+	case *parser.CallExpr:
+		if _, ok := b.info.Builtins[expression]; ok {
+			return 0, unsupported("builtin functions")
+		}
+		return b.lowerCall(expression)
+	case *parser.ArrayExpr, *parser.IndexExpr:
+		return 0, unsupported("arrays")
+	case *parser.ListExpr:
+		return 0, unsupported("lists")
+	case *parser.TupleExpr:
+		return 0, unsupported("tuples")
+	case *parser.StructExpr, *parser.FieldExpr:
+		return 0, unsupported("structs")
+	case *parser.MatchExpr:
+		return 0, unsupported("match")
+	}
+	return 0, errors.New("unexpected expression type")
+}
+
+func (b *builder) lowerBinary(op Operator, lhs parser.Expression, rhs parser.Expression, rhsFirst bool) (Register, error) {
+	first, second := lhs, rhs
+	if rhsFirst {
+		first, second = rhs, lhs
+	}
+	firstValue, err := b.lowerExpression(first)
+	if err != nil {
+		return 0, err
+	}
+	secondValue, err := b.lowerExpression(second)
+	if err != nil {
+		return 0, err
+	}
+
+	tipe := Int
+	if op == LessThan || op == GreaterThan {
+		tipe = Bool
+	}
+	dest := b.newRegister(tipe)
+	if rhsFirst {
+		firstValue, secondValue = secondValue, firstValue
+	}
+	b.emit(&Binary{Op: op, Dest: dest, Lhs: firstValue, Rhs: secondValue})
+	return dest, nil
+}
+
+func (b *builder) lowerBlockBody(expression *parser.BlockBodyExpr) (Register, error) {
+	for _, statement := range expression.Statements {
+		if err := b.lowerStatement(statement); err != nil {
+			return 0, err
+		}
+	}
+	return b.lowerExpression(expression.Final)
+}
+
+// Lowers the lambda to a function of its own, its value is the address of
+// that function
+func (b *builder) lowerLambda(expression *parser.LambdaExpr) (Register, error) {
+	dest, err := b.registerFor(expression)
+	if err != nil {
+		return 0, err
+	}
+	arrow := b.info.TypeOf(expression).(*checker.TArrow)
+
+	index := len(b.program.Functions)
+	body := b.newFunction()
+	for i := range expression.Parameters {
+		tipe, err := body.typeOf(arrow.Params[i])
+		if err != nil {
+			return 0, err
+		}
+		param := body.newRegister(tipe)
+		body.fn.Params = append(body.fn.Params, param)
+		body.bindings[b.info.Defs[&expression.Parameters[i]]] = param
+	}
+
+	value, err := body.lowerBlockBody(&expression.Body)
+	if err != nil {
+		return 0, err
+	}
+	body.block.Terminator = &Return{Value: value}
+
+	b.emit(&FunctionAddress{Dest: dest, Function: index})
+	return dest, nil
+}
+
+func (b *builder) lowerCall(expression *parser.CallExpr) (Register, error) {
+	arguments := make([]Register, 0, len(expression.Arguments))
+	for _, argument := range expression.Arguments {
+		value, err := b.lowerExpression(argument)
+		if err != nil {
+			return 0, err
+		}
+		arguments = append(arguments, value)
+	}
+	callee, err := b.lowerExpression(expression.Callee)
+	if err != nil {
+		return 0, err
+	}
+
+	dest, err := b.registerFor(expression)
+	if err != nil {
+		return 0, err
+	}
+	b.emit(&Call{Dest: dest, Callee: callee, Arguments: arguments})
+	return dest, nil
+}
@@ -0,0 +1,265 @@
+package ir
+
+/*
+Optimisations over a lowered program, which rewrite it in place:
+
+  - Constant folding replaces arithmetic and comparisons of constants with
+    their result, and branches on a constant with a jump.
+  - Dead code elimination removes instructions whose result is never read,
+    blocks that can't be reached, and functions that are never referred to.
+  - A block that is only ever jumped to from one other block is merged into
+    that block, so pruned branches don't leave chains of jumps behind.
+
+Registers of mutable bindings are written again when the binding is
+reassigned, so they are only known to hold a constant in the block the
+constant was written in. Calls are never removed, since the callee might not
+return.
+*/
+func Optimise(program *Program) {
+	for _, fn := range program.Functions {
+		for changed := true; changed; {
+			changed = foldConstants(fn)
+			changed = mergeBlocks(fn) || changed
+			changed = removeUnreachableBlocks(fn) || changed
+			changed = removeDeadInstructions(fn) || changed
+		}
+	}
+	removeUnusedFunctions(program)
+}
+
+// Returns the number of times each register is written, the parameters are
+// written once on entry
+func definitions(fn *Function) []int {
+	counts := make([]int, len(fn.Registers))
+	for _, param := range fn.Params {
+		counts[param]++
+	}
+	for _, block := range fn.Blocks {
+		for _, instruction := range block.Instructions {
+			if dest, ok := Defines(instruction); ok {
+				counts[dest]++
+			}
+		}
+	}
+	return counts
+}
+
+// Returns the value of an operator applied to two constants
+func evaluate(op Operator, lhs int64, rhs int64) int64 {
+	switch op {
+	case Add:
+		return lhs + rhs
+	case Sub:
+		return lhs - rhs
+	case LessThan:
+		if lhs < rhs {
+			return 1
+		}
+	case GreaterThan:
+		if lhs > rhs {
+			return 1
+		}
+	}
+	return 0
+}
+
+/*
+A register written once holds the same constant everywhere it is read.
+Within a block, a register written more than once holds the constant last
+written to it, until it is written again.
+*/
+func foldConstants(fn *Function) bool {
+	writes := definitions(fn)
+	constants := map[Register]int64{}
+	for _, block := range fn.Blocks {
+		for _, instruction := range block.Instructions {
+			if instruction, ok := instruction.(*Const); ok && writes[instruction.Dest] == 1 {
+				constants[instruction.Dest] = instruction.Value
+			}
+		}
+	}
+
+	changed := false
+	for _, block := range fn.Blocks {
+		known := map[Register]int64{}
+		lookup := func(register Register) (int64, bool) {
+			if value, ok := known[register]; ok {
+				return value, true
+			}
+			value, ok := constants[register]
+			return value, ok
+		}
+
+		for i, instruction := range block.Instructions {
+			value, folded := int64(0), false
+			switch instruction := instruction.(type) {
+			case *Copy:
+				value, folded = lookup(instruction.Src)
+			case *Binary:
+				lhs, lhsOk := lookup(instruction.Lhs)
+				rhs, rhsOk := lookup(instruction.Rhs)
+				if lhsOk && rhsOk {
+					value, folded = evaluate(instruction.Op, lhs, rhs), true
+				}
+			}
+
+			dest, ok := Defines(instruction)
+			if !ok {
+				continue
+			}
+			if folded {
+				block.Instructions[i] = &Const{Dest: dest, Value: value}
+				changed = true
+			}
+			if instruction, ok := block.Instructions[i].(*Const); ok {
+				known[dest] = instruction.Value
+				if writes[dest] == 1 {
+					constants[dest] = instruction.Value
+				}
+			} else {
+				delete(known, dest)
+			}
+		}
+
+		if branch, ok := block.Terminator.(*Branch); ok {
+			if cond, ok := lookup(branch.Cond); ok {
+				target := branch.Else
+				if cond != 0 {
+					target = branch.Then
+				}
+				block.Terminator = &Jump{Target: target}
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// The merged blocks are left unreachable, for removeUnreachableBlocks
+func mergeBlocks(fn *Function) bool {
+	predecessors := map[*Block]int{}
+	for _, block := range fn.Blocks {
+		for _, successor := range Successors(block) {
+			predecessors[successor]++
+		}
+	}
+
+	changed := false
+	for _, block := range fn.Blocks {
+		for {
+			jump, ok := block.Terminator.(*Jump)
+			if !ok || jump.Target == block || jump.Target == fn.Blocks[0] || predecessors[jump.Target] != 1 {
+				break
+			}
+			target := jump.Target
+			block.Instructions = append(block.Instructions, target.Instructions...)
+			block.Terminator = target.Terminator
+			// Nothing can reach the target any more, so it must not be
+			// merged into anything else
+			target.Instructions = []Instruction{}
+			target.Terminator = &Jump{Target: target}
+			predecessors[target] = 0
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Keeps the blocks that can be reached from the entry in the order they were
+// in, and numbers them again
+func removeUnreachableBlocks(fn *Function) bool {
+	reachable := map[*Block]bool{}
+	var visit func(block *Block)
+	visit = func(block *Block) {
+		if reachable[block] {
+			return
+		}
+		reachable[block] = true
+		for _, successor := range Successors(block) {
+			visit(successor)
+		}
+	}
+	visit(fn.Blocks[0])
+
+	if len(reachable) == len(fn.Blocks) {
+		return false
+	}
+	blocks := []*Block{}
+	for _, block := range fn.Blocks {
+		if reachable[block] {
+			block.Id = len(blocks)
+			blocks = append(blocks, block)
+		}
+	}
+	fn.Blocks = blocks
+	return true
+}
+
+// Removes the instructions other than calls whose result is never read
+func removeDeadInstructions(fn *Function) bool {
+	read := make([]bool, len(fn.Registers))
+	for _, block := range fn.Blocks {
+		for _, instruction := range block.Instructions {
+			for _, register := range Uses(instruction) {
+				read[register] = true
+			}
+		}
+		for _, register := range TerminatorUses(block.Terminator) {
+			read[register] = true
+		}
+	}
+
+	changed := false
+	for _, block := range fn.Blocks {
+		live := []Instruction{}
+		for _, instruction := range block.Instructions {
+			dest, _ := Defines(instruction)
+			if _, ok := instruction.(*Call); ok || read[dest] {
+				live = append(live, instruction)
+			} else {
+				changed = true
+			}
+		}
+		block.Instructions = live
+	}
+	return changed
+}
+
+// Removes the functions the top level can't reach, and numbers the rest again
+func removeUnusedFunctions(program *Program) {
+	used := make([]bool, len(program.Functions))
+	var visit func(index int)
+	visit = func(index int) {
+		if used[index] {
+			return
+		}
+		used[index] = true
+		for _, block := range program.Functions[index].Blocks {
+			for _, instruction := range block.Instructions {
+				if address, ok := instruction.(*FunctionAddress); ok {
+					visit(address.Function)
+				}
+			}
+		}
+	}
+	visit(0)
+
+	renumbered := make([]int, len(program.Functions))
+	functions := []*Function{}
+	for i, fn := range program.Functions {
+		if used[i] {
+			renumbered[i] = len(functions)
+			functions = append(functions, fn)
+		}
+	}
+	for _, fn := range functions {
+		for _, block := range fn.Blocks {
+			for _, instruction := range block.Instructions {
+				if address, ok := instruction.(*FunctionAddress); ok {
+					address.Function = renumbered[address.Function]
+				}
+			}
+		}
+	}
+	program.Functions = functions
+}
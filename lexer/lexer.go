@@ -28,6 +28,11 @@ func (lexer Lexer) inNextPosition() Lexer {
 }
 
 func (lexer Lexer) CurrentLine() string {
+	// The end of the input is on the last line
+	if lexer.Position >= len(*lexer.Input) {
+		lexer.Position = len(*lexer.Input) - 1
+	}
+
 	var start, end int = lexer.Position, lexer.Position + 1
 	for {
 		if start == 0 || (*lexer.Input)[start-1] == '\n' || (*lexer.Input)[start] == '\n' {
@@ -36,7 +41,7 @@ func (lexer Lexer) CurrentLine() string {
 		start--
 	}
 
-	for (*lexer.Input)[end] == '\n' {
+	for end < len(*lexer.Input) && (*lexer.Input)[end] == '\n' {
 		end++
 	}
 
@@ -81,11 +86,30 @@ var readWord reader = func(lexer Lexer) (Lexer, *string) {
 	})
 }
 
-// Reads a floating point number
+// Reads the characters of a number, stopping before a range operator like "0..5"
+func (lexer Lexer) readNumeric() (Lexer, *string) {
+	initialPosition := lexer.Position
+	for {
+		ch := lexer.currentChar()
+		if ch == '.' && lexer.inNextPosition().currentChar() == '.' {
+			break
+		}
+		if !isDigit(ch) && ch != '.' && ch != '-' {
+			break
+		}
+		lexer = lexer.inNextPosition()
+	}
+
+	if lexer.Position == initialPosition {
+		return lexer, nil
+	}
+	result := (*lexer.Input)[initialPosition:lexer.Position]
+	return lexer, &result
+}
+
+// Reads an integer
 var readInt reader = func(lexer Lexer) (Lexer, *string) {
-	lexer, lexeme := lexer.until(func(ch byte) bool {
-		return !isDigit(ch) && ch != '.' && ch != '-'
-	})
+	lexer, lexeme := lexer.readNumeric()
 
 	if lexeme == nil {
 		return lexer, lexeme
@@ -99,10 +123,9 @@ var readInt reader = func(lexer Lexer) (Lexer, *string) {
 	return lexer, lexeme
 }
 
+// Reads a floating point number
 var readFloat reader = func(lexer Lexer) (Lexer, *string) {
-	lexer, lexeme := lexer.until(func(ch byte) bool {
-		return !isDigit(ch) && ch != '.' && ch != '-'
-	})
+	lexer, lexeme := lexer.readNumeric()
 
 	if lexeme == nil {
 		return lexer, lexeme
@@ -168,6 +191,8 @@ func (lexer Lexer) Next() (Lexer, Token) {
 		return lexer, Token{Type: INT, Lexeme: *lit}
 	} else if lexer, lit := withBacktrack(readFloat)(lexer); lit != nil {
 		return lexer, Token{Type: FLOAT, Lexeme: *lit}
+	} else if lexer, tok := readToken(lexer, TripleCharOperators); tok != nil {
+		return lexer, *tok
 	} else if lexer, tok := readToken(lexer, DoubleCharOperators); tok != nil {
 		return lexer, *tok
 	} else if lexer, tok := readToken(lexer, Operators); tok != nil {
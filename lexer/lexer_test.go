@@ -115,7 +115,81 @@ func TestNexToken(t *testing.T) {
 		{Type: FLOAT, Lexeme: "8.2"},
 	})
 
-	// input9 := "let x: int = 5; def isMultipleof5And2(n: int) = {}"
+	input9 := "for i in 0..10 0..=n -1..x 1.5"
+	testCase(&input9, &[]Token{
+		{Type: FOR, Lexeme: "for"},
+		{Type: IDENT, Lexeme: "i"},
+		{Type: IN, Lexeme: "in"},
+		{Type: INT, Lexeme: "0"},
+		{Type: DOTDOT, Lexeme: ".."},
+		{Type: INT, Lexeme: "10"},
+		{Type: INT, Lexeme: "0"},
+		{Type: DOTDOTEQ, Lexeme: "..="},
+		{Type: IDENT, Lexeme: "n"},
+		{Type: INT, Lexeme: "-1"},
+		{Type: DOTDOT, Lexeme: ".."},
+		{Type: IDENT, Lexeme: "x"},
+		{Type: FLOAT, Lexeme: "1.5"},
+		{Type: EOF, Lexeme: ""},
+	})
+
+	input10 := "type P = { x: int } p.x"
+	testCase(&input10, &[]Token{
+		{Type: TYPE, Lexeme: "type"},
+		{Type: IDENT, Lexeme: "P"},
+		{Type: ASSIGN, Lexeme: "="},
+		{Type: LBRACE, Lexeme: "{"},
+		{Type: IDENT, Lexeme: "x"},
+		{Type: ASSIGN_T, Lexeme: ":"},
+		{Type: IDENT, Lexeme: "int"},
+		{Type: RBRACE, Lexeme: "}"},
+		{Type: IDENT, Lexeme: "p"},
+		{Type: DOT, Lexeme: "."},
+		{Type: IDENT, Lexeme: "x"},
+		{Type: EOF, Lexeme: ""},
+	})
+
+	input11 := "type S = A(int) | B match s { A(x) => x }"
+	testCase(&input11, &[]Token{
+		{Type: TYPE, Lexeme: "type"},
+		{Type: IDENT, Lexeme: "S"},
+		{Type: ASSIGN, Lexeme: "="},
+		{Type: IDENT, Lexeme: "A"},
+		{Type: LPAREN, Lexeme: "("},
+		{Type: IDENT, Lexeme: "int"},
+		{Type: RPAREN, Lexeme: ")"},
+		{Type: PIPE, Lexeme: "|"},
+		{Type: IDENT, Lexeme: "B"},
+		{Type: MATCH, Lexeme: "match"},
+		{Type: IDENT, Lexeme: "s"},
+		{Type: LBRACE, Lexeme: "{"},
+		{Type: IDENT, Lexeme: "A"},
+		{Type: LPAREN, Lexeme: "("},
+		{Type: IDENT, Lexeme: "x"},
+		{Type: RPAREN, Lexeme: ")"},
+		{Type: FATARROW, Lexeme: "=>"},
+		{Type: IDENT, Lexeme: "x"},
+		{Type: RBRACE, Lexeme: "}"},
+		{Type: EOF, Lexeme: ""},
+	})
+
+	input12 := "option<list<int>> list[1]"
+	testCase(&input12, &[]Token{
+		{Type: OPTION, Lexeme: "option"},
+		{Type: LT, Lexeme: "<"},
+		{Type: LIST, Lexeme: "list"},
+		{Type: LT, Lexeme: "<"},
+		{Type: IDENT, Lexeme: "int"},
+		{Type: GT, Lexeme: ">"},
+		{Type: GT, Lexeme: ">"},
+		{Type: LIST, Lexeme: "list"},
+		{Type: LBRACKET, Lexeme: "["},
+		{Type: INT, Lexeme: "1"},
+		{Type: RBRACKET, Lexeme: "]"},
+		{Type: EOF, Lexeme: ""},
+	})
+
+	// input10 := "let x: int = 5; def isMultipleof5And2(n: int) = {}"
 }
 
 func TestReadWord(t *testing.T) {
@@ -181,3 +255,19 @@ func TestBacktrack(t *testing.T) {
 	testCase("foo", "fob", 0)
 
 }
+
+func TestCurrentLine(t *testing.T) {
+	input := "let x = 1\n\nlet y = x +"
+	for position, expected := range map[int]string{
+		0:              "let x = 1",
+		14:             "let y = x +",
+		len(input):     "let y = x +",
+		len(input) + 4: "let y = x +",
+	} {
+		lexer := New(&input)
+		lexer.Position = position
+		if line := lexer.CurrentLine(); line != expected {
+			t.Fatalf("Expected the line at %d to be \"%s\", got \"%s\"", position, expected, line)
+		}
+	}
+}
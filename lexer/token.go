@@ -8,13 +8,27 @@ type Token struct {
 }
 
 var Keywords = map[string]TokenType{
-	"def":    FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"def":      FUNCTION,
+	"let":      LET,
+	"var":      VAR,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"for":      FOR,
+	"in":       IN,
+	"type":     TYPE,
+	"match":    MATCH,
+	"list":     LIST,
+	"option":   OPTION,
+}
+
+var TripleCharOperators = map[string]TokenType{
+	"..=": DOTDOTEQ,
 }
 
 var DoubleCharOperators = map[string]TokenType{
@@ -23,6 +37,8 @@ var DoubleCharOperators = map[string]TokenType{
 	"&&": AND,
 	"||": OR,
 	"->": ARROW,
+	"..": DOTDOT,
+	"=>": FATARROW,
 }
 
 var Operators = map[string]TokenType{
@@ -35,6 +51,8 @@ var Operators = map[string]TokenType{
 	"*": ASTERISK,
 	"<": LT,
 	">": GT,
+	".": DOT,
+	"|": PIPE,
 }
 
 var Delimiters = map[string]TokenType{
@@ -44,6 +62,8 @@ var Delimiters = map[string]TokenType{
 	")": RPAREN,
 	"{": LBRACE,
 	"}": RBRACE,
+	"[": LBRACKET,
+	"]": RBRACKET,
 }
 
 const (
@@ -57,6 +77,9 @@ const (
 	AND       TokenType = "&&"
 	OR        TokenType = "||"
 	ARROW     TokenType = "->"
+	DOTDOT    TokenType = ".."
+	DOTDOTEQ  TokenType = "..="
+	FATARROW  TokenType = "=>"
 	ASSIGN    TokenType = "="
 	PLUS      TokenType = "+"
 	COMMA     TokenType = ","
@@ -65,18 +88,32 @@ const (
 	RPAREN    TokenType = ")"
 	LBRACE    TokenType = "{"
 	RBRACE    TokenType = "}"
+	LBRACKET  TokenType = "["
+	RBRACKET  TokenType = "]"
 	MINUS     TokenType = "-"
 	BANG      TokenType = "!"
 	SLASH     TokenType = "/"
 	ASTERISK  TokenType = "*"
 	LT        TokenType = "<"
 	GT        TokenType = ">"
+	DOT       TokenType = "."
+	PIPE      TokenType = "|"
 	ASSIGN_T  TokenType = ":"
 	FUNCTION  TokenType = "FUNCTION"
 	LET       TokenType = "LET"
+	VAR       TokenType = "VAR"
 	TRUE      TokenType = "TRUE"
 	FALSE     TokenType = "FALSE"
 	IF        TokenType = "IF"
 	ELSE      TokenType = "ELSE"
 	RETURN    TokenType = "RETURN"
+	WHILE     TokenType = "WHILE"
+	BREAK     TokenType = "BREAK"
+	CONTINUE  TokenType = "CONTINUE"
+	FOR       TokenType = "FOR"
+	IN        TokenType = "IN"
+	TYPE      TokenType = "TYPE"
+	MATCH     TokenType = "MATCH"
+	LIST      TokenType = "LIST"
+	OPTION    TokenType = "OPTION"
 )
@@ -1,17 +1,160 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"monkey/checker"
 	"monkey/compiler"
+	"monkey/interpreter"
 	"monkey/lexer"
 	"monkey/parser"
+	"monkey/repl"
+	"monkey/vm"
 	"os"
+	"strings"
 )
 
+/*
+Usage:
+
+	main [flags] <input.thing> <output.s>    compile a program to assembly
+	main check <input.thing>                 only check a program for errors
+	main run <input.thing>                   interpret a program, exiting with its status
+	main repl                                read and evaluate a program interactively
+	main bytecode <input.thing> <output.thingc>
+	                                         compile a program to bytecode
+	main exec <input.thingc | input.thing>   run a program on the bytecode vm, exiting with its status
+
+Flags:
+
+	-gc-stats    the program writes garbage collector statistics to stderr when it exits
+	-O <level>   how much to optimise the program, see compiler.Options, 0 by default
+*/
 func main() {
-	fIn := os.Args[1]
-	fOut := os.Args[2]
+	gcStats := flag.Bool("gc-stats", false, "write garbage collector statistics to stderr at exit")
+	optimise := flag.Int("O", 0, "how much to optimise the program, from 0 to 2")
+	flag.Parse()
+	args := flag.Args()
+
+	// The same status the flag package exits with for a flag it can't parse
+	if *optimise < 0 || *optimise > 2 {
+		fmt.Fprintln(os.Stderr, "invalid value", *optimise, "for flag -O: the level must be 0, 1 or 2")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if args[0] == "check" {
+		check(args[1])
+		fmt.Println("ok")
+		return
+	}
+
+	if args[0] == "repl" {
+		repl.Start(os.Stdin, os.Stdout)
+		return
+	}
+
+	if args[0] == "bytecode" {
+		program, _ := compileBytecode(args[1])
+		os.WriteFile(args[2], vm.Encode(program), 0644)
+		return
+	}
+
+	if args[0] == "exec" {
+		runBytecode(args[1])
+		return
+	}
+
+	if args[0] == "run" {
+		run(args[1])
+		return
+	}
+
+	fIn := args[0]
+	fOut := args[1]
+
+	program, info, lexer := check(fIn)
+
+	compiled, compileErr := compiler.Compile(*program, info, compiler.Options{GCStats: *gcStats, Optimise: *optimise})
+	if compileErr != nil {
+		lexer.Position = compileErr.Position
+		log.Fatal(compileErr.ToError(lexer.CurrentLine()))
+	}
+	if *optimise > 0 {
+		compiled = compiler.Peephole(compiled)
+	}
+
+	output := compiler.Render(compiled)
+	outputBytes := []byte(output)
+
+	os.WriteFile(fOut, outputBytes, 0644)
+
+}
+
+// Interprets a program and exits with the status the compiled program would
+func run(fIn string) {
+	program, info, lexer := check(fIn)
 
+	result, runtimeErr := interpreter.Run(*program, info)
+	if runtimeErr != nil {
+		lexer.Position = runtimeErr.Position
+		fmt.Fprintln(os.Stderr, runtimeErr.ToError(lexer.CurrentLine()))
+		os.Exit(runtimeErr.ExitCode)
+	}
+
+	os.Exit(interpreter.ExitCode(result))
+}
+
+// Compiles a program to bytecode, exiting with the diagnostics if it is invalid
+func compileBytecode(fIn string) (*vm.Program, lexer.Lexer) {
+	program, info, lexer := check(fIn)
+
+	compiled, compileErr := vm.Compile(*program, info)
+	if compileErr != nil {
+		lexer.Position = compileErr.Position
+		log.Fatal(compileErr.ToError(lexer.CurrentLine()))
+	}
+	return compiled, lexer
+}
+
+// Runs a program on the bytecode vm and exits with its status. The source of
+// a program loaded from bytecode is not known, so its runtime errors have no
+// culprit.
+func runBytecode(fIn string) {
+	bytes, err := os.ReadFile(fIn)
+	if err != nil {
+		panic(err)
+	}
+
+	var program *vm.Program
+	var source *lexer.Lexer
+	if strings.HasSuffix(fIn, ".thingc") {
+		if program, err = vm.Decode(bytes); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		var lexer lexer.Lexer
+		program, lexer = compileBytecode(fIn)
+		source = &lexer
+	}
+
+	result, runtimeErr := vm.Run(program)
+	if runtimeErr != nil {
+		if source != nil {
+			source.Position = runtimeErr.Position
+			fmt.Fprintln(os.Stderr, runtimeErr.ToError(source.CurrentLine()))
+		} else {
+			fmt.Fprintln(os.Stderr, "[runtime err]:", runtimeErr.Error)
+		}
+		os.Exit(runtimeErr.ExitCode)
+	}
+
+	os.Exit(vm.ExitCode(result))
+}
+
+// Parses and checks a program, exiting with the diagnostics if it is invalid
+func check(fIn string) (*parser.Program, *checker.Info, lexer.Lexer) {
 	bytes, err := os.ReadFile(fIn)
 
 	if err != nil {
@@ -27,15 +170,11 @@ func main() {
 		log.Fatal(parseErr.ToError(lexer.CurrentLine()))
 	}
 
-	compiled, compileErr := compiler.Compile(*program)
-	if compileErr != nil {
-		lexer.Position = compileErr.Position
-		log.Fatal(compileErr.ToError(lexer.CurrentLine()))
+	info, checkErr := checker.Check(*program)
+	if checkErr != nil {
+		lexer.Position = checkErr.Position
+		log.Fatal(checkErr.ToError(lexer.CurrentLine()))
 	}
 
-	output := compiler.Render(compiled)
-	outputBytes := []byte(output)
-
-	os.WriteFile(fOut, outputBytes, 0644)
-
+	return program, info, lexer
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"monkey/checker"
+	"monkey/compiler"
+	"monkey/interpreter"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/toolchain"
+	"monkey/vm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+Runs every program in testdata with each backend and checks that they agree.
+The interpreter is the reference, so a failure shows how the other backends
+differ from it. The native backends are only used if toolchain.Available().
+*/
+
+// What running a program did
+type outcome struct {
+	exitCode int
+	stdout   string
+}
+
+type backend struct {
+	name string
+	run  func(t *testing.T, program *parser.Program, info *checker.Info) outcome
+}
+
+func runInterpreter(t *testing.T, program *parser.Program, info *checker.Info) outcome {
+	result, runtimeErr := interpreter.Run(*program, info)
+	if runtimeErr != nil {
+		return outcome{exitCode: runtimeErr.ExitCode}
+	}
+	return outcome{exitCode: interpreter.ExitCode(result)}
+}
+
+// The program goes through a .thingc file, so the encoding is tested as well
+func runVM(t *testing.T, program *parser.Program, info *checker.Info) outcome {
+	compiled, compileErr := vm.Compile(*program, info)
+	if compileErr != nil {
+		t.Fatalf("Failed to compile to bytecode: %s", compileErr.Error)
+	}
+	decoded, err := vm.Decode(vm.Encode(compiled))
+	if err != nil {
+		t.Fatalf("Failed to decode bytecode: %s", err)
+	}
+
+	result, runtimeErr := vm.Run(decoded)
+	if runtimeErr != nil {
+		return outcome{exitCode: runtimeErr.ExitCode}
+	}
+	return outcome{exitCode: vm.ExitCode(result)}
+}
+
+func runNative(t *testing.T, program *parser.Program, info *checker.Info) outcome {
+	return runCompiled(t, program, info, compiler.Options{})
+}
+
+// Programs that can't be lowered to IR are compiled from the AST, the same as
+// with runNative
+func runNativeO1(t *testing.T, program *parser.Program, info *checker.Info) outcome {
+	return runCompiled(t, program, info, compiler.Options{Optimise: 1})
+}
+
+func runNativeO2(t *testing.T, program *parser.Program, info *checker.Info) outcome {
+	return runCompiled(t, program, info, compiler.Options{Optimise: 2})
+}
+
+func runCompiled(t *testing.T, program *parser.Program, info *checker.Info, options compiler.Options) outcome {
+	compiled, compileErr := compiler.Compile(*program, info, options)
+	if compileErr != nil {
+		t.Fatalf("Failed to compile: %s", compileErr.Error)
+	}
+	if options.Optimise > 0 {
+		compiled = compiler.Peephole(compiled)
+	}
+	binary, err := toolchain.Build(compiler.Render(compiled), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exitCode, stdout, err := toolchain.Run(binary)
+	if err != nil {
+		t.Fatalf("Failed to run: %s", err)
+	}
+	return outcome{exitCode: exitCode, stdout: stdout}
+}
+
+func availableBackends() []backend {
+	backends := []backend{
+		{"interpreter", runInterpreter},
+		{"vm", runVM},
+	}
+
+	if toolchain.Available() {
+		backends = append(backends, backend{"native", runNative}, backend{"native -O1", runNativeO1}, backend{"native -O2", runNativeO2})
+	}
+	return backends
+}
+
+func TestBackendsAgree(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.thing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("Expected programs in testdata")
+	}
+
+	backends := availableBackends()
+	names := make([]string, 0, len(backends))
+	for _, backend := range backends {
+		names = append(names, backend.name)
+	}
+	t.Logf("Comparing %s", strings.Join(names, ", "))
+	if !toolchain.Available() {
+		t.Log("Skipping the native backends, which need nasm and ld on macOS")
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			bytes, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			input := string(bytes)
+			l := lexer.New(&input)
+			program, parseErr := parser.ParseProgram(l)
+			if parseErr != nil {
+				l.Position = parseErr.Position
+				t.Fatal(parseErr.ToError(l.CurrentLine()))
+			}
+			info, checkErr := checker.Check(*program)
+			if checkErr != nil {
+				l.Position = checkErr.Position
+				t.Fatal(checkErr.ToError(l.CurrentLine()))
+			}
+
+			outcomes := make([]outcome, len(backends))
+			for i, backend := range backends {
+				outcomes[i] = backend.run(t, program, info)
+			}
+			for i := 1; i < len(backends); i++ {
+				if outcomes[i] != outcomes[0] {
+					t.Errorf("%s disagrees with %s\n%s", backends[i].name, backends[0].name, diffOutcomes(backends, outcomes))
+				}
+			}
+		})
+	}
+}
+
+// Renders what each backend did, one line per backend
+func diffOutcomes(backends []backend, outcomes []outcome) string {
+	var b = strings.Builder{}
+	for i, backend := range backends {
+		marker := " "
+		if outcomes[i] != outcomes[0] {
+			marker = "!"
+		}
+		b.WriteString(fmt.Sprintf("%s %-12s exit %3d, stdout %q\n", marker, backend.name, outcomes[i].exitCode, outcomes[i].stdout))
+	}
+	return b.String()
+}
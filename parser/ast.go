@@ -1,6 +1,9 @@
 package parser
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 type (
 
@@ -81,6 +84,81 @@ type GreaterThanExpr struct {
 
 func (*GreaterThanExpr) isExpression() {}
 
+// Function call ----------------------
+type CallExpr struct {
+	Callee    Expression
+	Arguments []Expression
+}
+
+func (*CallExpr) isExpression() {}
+
+// Array literal ----------------------
+type ArrayExpr struct {
+	Elements []Expression
+}
+
+func (*ArrayExpr) isExpression() {}
+
+// List literal -----------------------
+type ListExpr struct {
+	Elements []Expression
+}
+
+func (*ListExpr) isExpression() {}
+
+// Indexing ---------------------------
+type IndexExpr struct {
+	Array Expression
+	Index Expression
+}
+
+func (*IndexExpr) isExpression() {}
+
+// Struct literal ---------------------
+type StructExpr struct {
+	Name   string
+	Fields []FieldInit
+}
+
+type FieldInit struct {
+	Name  string
+	Value Expression
+}
+
+func (*StructExpr) isExpression() {}
+
+// Field access -----------------------
+type FieldExpr struct {
+	Struct Expression
+	Field  string
+}
+
+func (*FieldExpr) isExpression() {}
+
+// Tuple literal ----------------------
+type TupleExpr struct {
+	Elements []Expression
+}
+
+func (*TupleExpr) isExpression() {}
+
+// Match expression -------------------
+type MatchExpr struct {
+	Value Expression
+	Arms  []MatchArm
+}
+
+// An arm matches a variant and binds its payload, or matches anything if
+// Variant is "_"
+type MatchArm struct {
+	Variant  string
+	Bindings []IdentExpr
+	Body     Expression
+}
+
+func (*MatchExpr) isExpression() {}
+
+// Tipe is nil when the type should be inferred
 type FunctionParameter struct {
 	Name IdentExpr
 	Tipe TypeExpression
@@ -93,6 +171,7 @@ type BlockBodyExpr struct {
 
 func (*BlockBodyExpr) isExpression() {}
 
+// Returns is nil when the type should be inferred
 type LambdaExpr struct {
 	Parameters []FunctionParameter
 	Returns    TypeExpression
@@ -102,11 +181,13 @@ type LambdaExpr struct {
 func (*LambdaExpr) isExpression() {}
 
 // Assignment -------------------------
+// Tipe is nil when the type should be inferred from the right hand side
 type AssignStmt struct {
-	Lhs  string
-	Tipe TypeExpression
-	Rhs  Expression
-	Pos  int
+	Lhs     string
+	Tipe    TypeExpression
+	Rhs     Expression
+	Pos     int
+	Mutable bool
 }
 
 func (*AssignStmt) isStatement() {}
@@ -114,6 +195,96 @@ func (s *AssignStmt) Position() int {
 	return s.Pos
 }
 
+// Reassignment -----------------------
+// Writes a new value to a binding declared with "var", or to an element of it
+type ReassignStmt struct {
+	Lhs Expression
+	Rhs Expression
+	Pos int
+}
+
+func (*ReassignStmt) isStatement() {}
+func (s *ReassignStmt) Position() int {
+	return s.Pos
+}
+
+// Destructuring assignment -----------
+// Binds each element of a tuple to a name, Tipe is nil when the type should
+// be inferred
+type DestructureStmt struct {
+	Lhs     []IdentExpr
+	Tipe    TypeExpression
+	Rhs     Expression
+	Pos     int
+	Mutable bool
+}
+
+func (*DestructureStmt) isStatement() {}
+func (s *DestructureStmt) Position() int {
+	return s.Pos
+}
+
+// Type declaration -------------------
+type TypeDeclStmt struct {
+	Name string
+	Tipe TypeExpression
+	Pos  int
+}
+
+func (*TypeDeclStmt) isStatement() {}
+func (s *TypeDeclStmt) Position() int {
+	return s.Pos
+}
+
+// While loop -------------------------
+type WhileStmt struct {
+	Cond Expression
+	Body []Statement
+	Pos  int
+}
+
+func (*WhileStmt) isStatement() {}
+func (s *WhileStmt) Position() int {
+	return s.Pos
+}
+
+// For loop ---------------------------
+// Iterates Var over the integers from From up to To, including To if Inclusive.
+// Without a range To is nil, and Var iterates over the elements of the list From.
+type ForStmt struct {
+	Var       string
+	From      Expression
+	To        Expression
+	Inclusive bool
+	Body      []Statement
+	Pos       int
+}
+
+func (*ForStmt) isStatement() {}
+func (s *ForStmt) Position() int {
+	return s.Pos
+}
+
+// Break ------------------------------
+type BreakStmt struct {
+	Pos int
+}
+
+func (*BreakStmt) isStatement() {}
+func (s *BreakStmt) Position() int {
+	return s.Pos
+}
+
+// Continue ---------------------------
+type ContinueStmt struct {
+	Pos int
+}
+
+func (*ContinueStmt) isStatement() {}
+func (s *ContinueStmt) Position() int {
+	return s.Pos
+}
+
 // Literal types ----------------------
 type LiteralType struct {
 	Name string
@@ -145,3 +316,111 @@ func (a *ArrowType) Render() string {
 	s.WriteString(a.Returns.Render())
 	return s.String()
 }
+
+// Tuple types ------------------------
+type TupleType struct {
+	Elems []TypeExpression
+}
+
+func (*TupleType) isTypeExpression() {}
+func (tt *TupleType) Render() string {
+	var s = strings.Builder{}
+	s.WriteString("(")
+	for i, te := range tt.Elems {
+		s.WriteString(te.Render())
+		if i < len(tt.Elems)-1 {
+			s.WriteString(", ")
+		}
+	}
+	s.WriteString(")")
+	return s.String()
+}
+
+// Array types ------------------------
+type ArrayType struct {
+	Elem   TypeExpression
+	Length int
+}
+
+func (*ArrayType) isTypeExpression() {}
+func (a *ArrayType) Render() string {
+	return fmt.Sprint("[", a.Elem.Render(), "; ", a.Length, "]")
+}
+
+// List types -------------------------
+type ListType struct {
+	Elem TypeExpression
+}
+
+func (*ListType) isTypeExpression() {}
+func (l *ListType) Render() string {
+	return fmt.Sprint("list<", l.Elem.Render(), ">")
+}
+
+// Option types -----------------------
+type OptionType struct {
+	Value TypeExpression
+}
+
+func (*OptionType) isTypeExpression() {}
+func (o *OptionType) Render() string {
+	return fmt.Sprint("option<", o.Value.Render(), ">")
+}
+
+// Struct types -----------------------
+type StructType struct {
+	Fields []StructField
+}
+
+type StructField struct {
+	Name string
+	Tipe TypeExpression
+}
+
+func (*StructType) isTypeExpression() {}
+func (st *StructType) Render() string {
+	var s = strings.Builder{}
+	s.WriteString("{ ")
+	for i, field := range st.Fields {
+		s.WriteString(field.Name)
+		s.WriteString(": ")
+		s.WriteString(field.Tipe.Render())
+		if i < len(st.Fields)-1 {
+			s.WriteString(", ")
+		}
+	}
+	s.WriteString(" }")
+	return s.String()
+}
+
+// Union types ------------------------
+type UnionType struct {
+	Variants []VariantType
+}
+
+type VariantType struct {
+	Name    string
+	Payload []TypeExpression
+}
+
+func (*UnionType) isTypeExpression() {}
+func (ut *UnionType) Render() string {
+	var s = strings.Builder{}
+	for i, variant := range ut.Variants {
+		s.WriteString(variant.Name)
+		if len(variant.Payload) > 0 {
+			s.WriteString("(")
+			for j, tipe := range variant.Payload {
+				s.WriteString(tipe.Render())
+				if j < len(variant.Payload)-1 {
+					s.WriteString(", ")
+				}
+			}
+			s.WriteString(")")
+		}
+		if i < len(ut.Variants)-1 {
+			s.WriteString(" | ")
+		}
+	}
+	return s.String()
+}
@@ -55,15 +55,46 @@ func parseExpression(l lexer.Lexer) (lexer.Lexer, Expression) {
 	return new, tree
 }
 
-// A start is a simple, non-recursive expression
-// start := enclosedExpression | ident | int | bool | lambdaExpr | blockExpr | Nothing
+// A start is a simple, non-recursive expression, optionally followed by calls,
+// indexing or field accesses
+// start := atom, {call | index | field}
 func parseExpressionStart(l lexer.Lexer) (lexer.Lexer, Expression) {
+	new, atom := parseAtom(l)
+	if atom == nil {
+		return l, nil
+	}
+
+	for {
+		if newer, call := parseCall(new, atom); call != nil {
+			new, atom = newer, call
+		} else if newer, index := parseIndex(new, atom); index != nil {
+			new, atom = newer, index
+		} else if newer, field := parseField(new, atom); field != nil {
+			new, atom = newer, field
+		} else {
+			return new, atom
+		}
+	}
+}
+
+// atom := enclosedExpression | tupleExpr | structExpr | ident | int | bool | arrayExpr | listExpr | lambdaExpr | matchExpr | blockExpr | Nothing
+func parseAtom(l lexer.Lexer) (lexer.Lexer, Expression) {
 
 	new, tree := parseEnclosedExpression(l)
 	if tree != nil {
 		return new, tree
 	}
 
+	new, tuple := parseTupleExpr(l)
+	if tuple != nil {
+		return new, tuple
+	}
+
+	new, structExpr := parseStructExpr(l)
+	if structExpr != nil {
+		return new, structExpr
+	}
+
 	new, ident := parseIdentifier(l)
 	if ident != nil {
 		return new, ident
@@ -79,11 +110,25 @@ func parseExpressionStart(l lexer.Lexer) (lexer.Lexer, Expression) {
 		return new, bool
 	}
 
+	new, array := parseArrayExpr(l)
+	if array != nil {
+		return new, array
+	}
+
+	new, list := parseListExpr(l)
+	if list != nil {
+		return new, list
+	}
 	new, lambda := parseLambdaExpr(l)
 	if lambda != nil {
 		return new, lambda
 	}
 
+	new, match := parseMatchExpr(l)
+	if match != nil {
+		return new, match
+	}
+
 	new, block := parseBlockBody(l)
 	if block != nil {
 		return new, block
@@ -140,6 +185,251 @@ func parseEnclosedExpression(l lexer.Lexer) (lexer.Lexer, Expression) {
 
 }
 
+// A tuple has at least two elements, so that it isn't confused with an
+// enclosed expression
+// tupleExpr := "(", list
+func parseTupleExpr(l lexer.Lexer) (lexer.Lexer, Expression) {
+	new, tok := l.Next()
+	if tok.Type != lexer.LPAREN {
+		return l, nil
+	}
+
+	new, elements := parseExpressionList(new, lexer.RPAREN)
+	if len(elements) < 2 {
+		return l, nil
+	}
+
+	return new, &TupleExpr{Elements: elements}
+}
+
+// Parses comma separated expressions up to the closing token, which is consumed
+// list := [expression, {",", expression}], close
+func parseExpressionList(l lexer.Lexer, close lexer.TokenType) (lexer.Lexer, []Expression) {
+	new := l
+	expressions := make([]Expression, 0)
+	for {
+		// If we reached the closing token, we're done
+		if newer, tok := new.Next(); tok.Type == close {
+			return newer, expressions
+		}
+
+		// Expect a comma between each expression
+		if len(expressions) > 0 {
+			var tok lexer.Token
+			new, tok = new.Next()
+			if tok.Type != lexer.COMMA {
+				return l, nil
+			}
+		}
+
+		var expression Expression
+		new, expression = parseExpression(new)
+		if expression == nil {
+			return l, nil
+		}
+		expressions = append(expressions, expression)
+	}
+}
+
+// call := "(", list
+func parseCall(l lexer.Lexer, callee Expression) (lexer.Lexer, Expression) {
+	new, tok := l.Next()
+	if tok.Type != lexer.LPAREN {
+		return l, nil
+	}
+
+	new, arguments := parseExpressionList(new, lexer.RPAREN)
+	if arguments == nil {
+		return l, nil
+	}
+
+	return new, &CallExpr{Callee: callee, Arguments: arguments}
+}
+
+// index := "[", expression, "]"
+func parseIndex(l lexer.Lexer, array Expression) (lexer.Lexer, Expression) {
+	new, tok := l.Next()
+	if tok.Type != lexer.LBRACKET {
+		return l, nil
+	}
+
+	new, index := parseExpression(new)
+	if index == nil {
+		return l, nil
+	}
+
+	if new, tok = new.Next(); tok.Type != lexer.RBRACKET {
+		return l, nil
+	}
+
+	return new, &IndexExpr{Array: array, Index: index}
+}
+
+// field := ".", IDENT
+func parseField(l lexer.Lexer, structExpr Expression) (lexer.Lexer, Expression) {
+	if new, toks := allOf(l, lexer.DOT, lexer.IDENT); toks != nil {
+		return new, &FieldExpr{Struct: structExpr, Field: toks[1].Lexeme}
+	}
+	return l, nil
+}
+
+// A struct literal needs at least one field, so that it can't be confused
+// with an identifier followed by a block
+// structExpr := IDENT, "{", IDENT, ":", expression, {",", IDENT, ":", expression}, "}"
+func parseStructExpr(l lexer.Lexer) (lexer.Lexer, Expression) {
+	new, toks := allOf(l, lexer.IDENT, lexer.LBRACE)
+	if toks == nil {
+		return l, nil
+	}
+
+	var fields []FieldInit
+	for {
+		if len(fields) > 0 {
+			if newer, tok := new.Next(); tok.Type == lexer.RBRACE {
+				new = newer
+				break
+			} else if tok.Type != lexer.COMMA {
+				return l, nil
+			} else {
+				new = newer
+			}
+		}
+
+		var name []lexer.Token
+		new, name = allOf(new, lexer.IDENT, lexer.ASSIGN_T)
+		if name == nil {
+			return l, nil
+		}
+
+		var value Expression
+		new, value = parseExpression(new)
+		if value == nil {
+			return l, nil
+		}
+		fields = append(fields, FieldInit{Name: name[0].Lexeme, Value: value})
+	}
+
+	return new, &StructExpr{Name: toks[0].Lexeme, Fields: fields}
+}
+
+// matchExpr := "match", expression, "{", arm, {",", arm}, [","], "}"
+func parseMatchExpr(l lexer.Lexer) (lexer.Lexer, Expression) {
+	new, tok := l.Next()
+	if tok.Type != lexer.MATCH {
+		return l, nil
+	}
+
+	new, value := parseExpression(new)
+	if value == nil {
+		return l, nil
+	}
+
+	new, tok = new.Next()
+	if tok.Type != lexer.LBRACE {
+		return l, nil
+	}
+
+	var arms []MatchArm
+	for {
+		if newer, tok := new.Next(); tok.Type == lexer.RBRACE && len(arms) > 0 {
+			new = newer
+			break
+		}
+
+		if len(arms) > 0 {
+			var tok lexer.Token
+			new, tok = new.Next()
+			if tok.Type != lexer.COMMA {
+				return l, nil
+			}
+			// A trailing comma is allowed
+			if newer, tok := new.Next(); tok.Type == lexer.RBRACE {
+				new = newer
+				break
+			}
+		}
+
+		var arm *MatchArm
+		new, arm = parseMatchArm(new)
+		if arm == nil {
+			return l, nil
+		}
+		arms = append(arms, *arm)
+	}
+
+	return new, &MatchExpr{Value: value, Arms: arms}
+}
+
+// arm := IDENT, ["(", IDENT, {",", IDENT}, ")"], "=>", expression
+func parseMatchArm(l lexer.Lexer) (lexer.Lexer, *MatchArm) {
+	new, variant := l.Next()
+	if variant.Type != lexer.IDENT {
+		return l, nil
+	}
+
+	var bindings []IdentExpr
+	if newer, tok := new.Next(); tok.Type == lexer.LPAREN {
+		new = newer
+		for {
+			var name lexer.Token
+			new, name = new.Next()
+			if name.Type != lexer.IDENT {
+				return l, nil
+			}
+			bindings = append(bindings, IdentExpr{Name: name.Lexeme})
+
+			new, tok = new.Next()
+			if tok.Type == lexer.RPAREN {
+				break
+			} else if tok.Type != lexer.COMMA {
+				return l, nil
+			}
+		}
+	}
+
+	new, tok := new.Next()
+	if tok.Type != lexer.FATARROW {
+		return l, nil
+	}
+
+	new, body := parseExpression(new)
+	if body == nil {
+		return l, nil
+	}
+
+	return new, &MatchArm{Variant: variant.Lexeme, Bindings: bindings, Body: body}
+}
+
+// arrayExpr := "[", list
+func parseArrayExpr(l lexer.Lexer) (lexer.Lexer, Expression) {
+	new, tok := l.Next()
+	if tok.Type != lexer.LBRACKET {
+		return l, nil
+	}
+
+	new, elements := parseExpressionList(new, lexer.RBRACKET)
+	if elements == nil {
+		return l, nil
+	}
+
+	return new, &ArrayExpr{Elements: elements}
+}
+
+// listExpr := "list", "[", list
+func parseListExpr(l lexer.Lexer) (lexer.Lexer, Expression) {
+	new, toks := allOf(l, lexer.LIST, lexer.LBRACKET)
+	if toks == nil {
+		return l, nil
+	}
+
+	new, elements := parseExpressionList(new, lexer.RBRACKET)
+	if elements == nil {
+		return l, nil
+	}
+
+	return new, &ListExpr{Elements: elements}
+}
+
 func parseInfix(l lexer.Lexer, lhs Expression, expectOp lexer.TokenType, buildExp func(Expression, Expression) Expression) (lexer.Lexer, Expression) {
 	new, operator := l.Next()
 
@@ -195,33 +485,305 @@ func allOf(l lexer.Lexer, types ...lexer.TokenType) (lexer.Lexer, []lexer.Token)
 	return l, tokens
 }
 
-// assignment := "let", IDENT, ":", typeExpr, "=", expression
+// Bindings declared with "var" can be reassigned
+// assignment := ("let" | "var"), (IDENT | names), [":", typeExpr], "=", expression
 func parseAssignment(l lexer.Lexer) (lexer.Lexer, Statement) {
 
-	if new, toks := allOf(l, lexer.LET, lexer.IDENT, lexer.ASSIGN_T); toks != nil {
-		if new, tipe := parseTypeExpr(new); tipe != nil {
-			if new, tok := new.Next(); tok.Type == lexer.ASSIGN {
-				if new, rhs := parseExpression(new); rhs != nil {
-					return new, &AssignStmt{Lhs: toks[1].Lexeme, Tipe: tipe, Rhs: rhs, Pos: l.Position}
+	new, keyword := l.Next()
+	if keyword.Type != lexer.LET && keyword.Type != lexer.VAR {
+		return l, nil
+	}
+
+	if new, names := parseNames(new); names != nil {
+		new, tipe := parseTypeAnnotation(new)
+		if new, tok := new.Next(); tok.Type == lexer.ASSIGN {
+			if new, rhs := parseExpression(new); rhs != nil {
+				return new, &DestructureStmt{
+					Lhs:     names,
+					Tipe:    tipe,
+					Rhs:     rhs,
+					Pos:     l.Position,
+					Mutable: keyword.Type == lexer.VAR,
 				}
 			}
 		}
 	}
+
+	if new, toks := allOf(new, lexer.IDENT); toks != nil {
+		new, tipe := parseTypeAnnotation(new)
+		if new, tok := new.Next(); tok.Type == lexer.ASSIGN {
+			if new, rhs := parseExpression(new); rhs != nil {
+				return new, &AssignStmt{
+					Lhs:     toks[0].Lexeme,
+					Tipe:    tipe,
+					Rhs:     rhs,
+					Pos:     l.Position,
+					Mutable: keyword.Type == lexer.VAR,
+				}
+			}
+		}
+	}
+	return l, nil
+}
+
+// The names a tuple is destructured into
+// names := "(", IDENT, ",", IDENT, {",", IDENT}, ")"
+func parseNames(l lexer.Lexer) (lexer.Lexer, []IdentExpr) {
+	new, tok := l.Next()
+	if tok.Type != lexer.LPAREN {
+		return l, nil
+	}
+
+	var names []IdentExpr
+	for {
+		var name lexer.Token
+		new, name = new.Next()
+		if name.Type != lexer.IDENT {
+			return l, nil
+		}
+		names = append(names, IdentExpr{Name: name.Lexeme})
+
+		new, tok = new.Next()
+		if tok.Type == lexer.RPAREN {
+			break
+		} else if tok.Type != lexer.COMMA {
+			return l, nil
+		}
+	}
+
+	if len(names) < 2 {
+		return l, nil
+	}
+	return new, names
+}
+
+// The target is checked to be assignable by the checker
+// reassignment := start, "=", expression
+func parseReassignment(l lexer.Lexer) (lexer.Lexer, Statement) {
+
+	if new, lhs := parseExpressionStart(l); lhs != nil {
+		if new, tok := new.Next(); tok.Type == lexer.ASSIGN {
+			if new, rhs := parseExpression(new); rhs != nil {
+				return new, &ReassignStmt{Lhs: lhs, Rhs: rhs, Pos: l.Position}
+			}
+		}
+	}
+	return l, nil
+}
+
+// An optional type annotation, the returned type is nil if there is none
+// annotation := [":", typeExpr]
+func parseTypeAnnotation(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
+	if new, tok := l.Next(); tok.Type == lexer.ASSIGN_T {
+		if new, tipe := parseTypeExpr(new); tipe != nil {
+			return new, tipe
+		}
+	}
+	return l, nil
+}
+
+// while := "while", expression, statementBlock
+func parseWhile(l lexer.Lexer) (lexer.Lexer, Statement) {
+	new, tok := l.Next()
+	if tok.Type != lexer.WHILE {
+		return l, nil
+	}
+
+	new, cond := parseExpression(new)
+	if cond == nil {
+		return l, nil
+	}
+
+	new, body := parseStatementBlock(new)
+	if body == nil {
+		return l, nil
+	}
+
+	return new, &WhileStmt{Cond: cond, Body: body, Pos: l.Position}
+}
+
+// for := "for", IDENT, "in", expression, [(".." | "..="), expression], statementBlock
+func parseFor(l lexer.Lexer) (lexer.Lexer, Statement) {
+	new, toks := allOf(l, lexer.FOR, lexer.IDENT, lexer.IN)
+	if toks == nil {
+		return l, nil
+	}
+
+	new, from := parseExpression(new)
+	if from == nil {
+		return l, nil
+	}
+
+	// Without a range the loop goes over the elements of a list
+	var to Expression
+	newer, rangeOp := new.Next()
+	if rangeOp.Type == lexer.DOTDOT || rangeOp.Type == lexer.DOTDOTEQ {
+		new, to = parseExpression(newer)
+		if to == nil {
+			return l, nil
+		}
+	}
+
+	new, body := parseStatementBlock(new)
+	if body == nil {
+		return l, nil
+	}
+
+	return new, &ForStmt{
+		Var:       toks[1].Lexeme,
+		From:      from,
+		To:        to,
+		Inclusive: rangeOp.Type == lexer.DOTDOTEQ,
+		Body:      body,
+		Pos:       l.Position,
+	}
+}
+
+// break := "break"
+// continue := "continue"
+func parseLoopControl(l lexer.Lexer) (lexer.Lexer, Statement) {
+	new, tok := l.Next()
+	switch tok.Type {
+	case lexer.BREAK:
+		return new, &BreakStmt{Pos: l.Position}
+	case lexer.CONTINUE:
+		return new, &ContinueStmt{Pos: l.Position}
+	}
 	return l, nil
 }
 
-// typeExpr := literalType | arrowType
+// Unlike a block expression, a statement block has no final expression
+// statementBlock := "{", {statement}, "}"
+func parseStatementBlock(l lexer.Lexer) (lexer.Lexer, []Statement) {
+	var statements []Statement = make([]Statement, 0)
+	new, tok := l.Next()
+
+	if tok.Type != lexer.LBRACE {
+		return l, nil
+	}
+
+	for {
+		newer, stmt, err := ParseStatement(new)
+		if err != nil {
+			break
+		}
+		new = newer
+		statements = append(statements, stmt)
+	}
+
+	new, rbrace := new.Next()
+	if rbrace.Type != lexer.RBRACE {
+		return l, nil
+	}
+	return new, statements
+}
+
+// Union types can only be declared, not written inline
+// typeDecl := "type", IDENT, "=", (unionType | typeExpr)
+func parseTypeDecl(l lexer.Lexer) (lexer.Lexer, Statement) {
+	new, toks := allOf(l, lexer.TYPE, lexer.IDENT, lexer.ASSIGN)
+	if toks == nil {
+		return l, nil
+	}
+
+	newer, tipe := parseUnionType(new)
+	if tipe == nil {
+		newer, tipe = parseTypeExpr(new)
+	}
+	if tipe == nil {
+		return l, nil
+	}
+	new = newer
+
+	return new, &TypeDeclStmt{Name: toks[1].Lexeme, Tipe: tipe, Pos: l.Position}
+}
+
+// A single variant without a payload would be an alias, so it isn't a union
+// unionType := variant, {"|", variant}
+// variant := IDENT, ["(", typeExpr, {",", typeExpr}, ")"]
+func parseUnionType(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
+	new := l
+	var variants []VariantType
+	for {
+		if len(variants) > 0 {
+			newer, tok := new.Next()
+			if tok.Type != lexer.PIPE {
+				break
+			}
+			new = newer
+		}
+
+		var name lexer.Token
+		new, name = new.Next()
+		if name.Type != lexer.IDENT {
+			return l, nil
+		}
+
+		var payload []TypeExpression
+		if newer, tok := new.Next(); tok.Type == lexer.LPAREN {
+			new = newer
+			for {
+				var tipe TypeExpression
+				new, tipe = parseTypeExpr(new)
+				if tipe == nil {
+					return l, nil
+				}
+				payload = append(payload, tipe)
+
+				new, tok = new.Next()
+				if tok.Type == lexer.RPAREN {
+					break
+				} else if tok.Type != lexer.COMMA {
+					return l, nil
+				}
+			}
+		}
+		variants = append(variants, VariantType{Name: name.Lexeme, Payload: payload})
+	}
+
+	if len(variants) == 1 && len(variants[0].Payload) == 0 {
+		return l, nil
+	}
+	return new, &UnionType{Variants: variants}
+}
+
+// typeExpr := literalType | listType | optionType | structType | arrayType | arrowType | tupleType
 func parseTypeExpr(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
 	new, ident := parseLiteralType(l)
 	if ident != nil {
 		return new, ident
 	}
 
+	new, list := parseListType(l)
+	if list != nil {
+		return new, list
+	}
+
+	new, option := parseOptionType(l)
+	if option != nil {
+		return new, option
+	}
+
+	new, structType := parseStructType(l)
+	if structType != nil {
+		return new, structType
+	}
+
+	new, array := parseArrayType(l)
+	if array != nil {
+		return new, array
+	}
+
 	new, arrow := parseArrowType(l)
 	if arrow != nil {
 		return new, arrow
 	}
 
+	new, tuple := parseTupleType(l)
+	if tuple != nil {
+		return new, tuple
+	}
+
 	return l, nil
 }
 
@@ -235,15 +797,126 @@ func parseLiteralType(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
 	return l, nil
 }
 
-// arrowType := "(", {typeExpr}, ")", "->", typeExpr
-func parseArrowType(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
+// listType := "list", "<", typeExpr, ">"
+func parseListType(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
+	new, elem := parseTypeArgument(l, lexer.LIST)
+	if elem == nil {
+		return l, nil
+	}
+	return new, &ListType{Elem: elem}
+}
+
+// optionType := "option", "<", typeExpr, ">"
+func parseOptionType(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
+	new, value := parseTypeArgument(l, lexer.OPTION)
+	if value == nil {
+		return l, nil
+	}
+	return new, &OptionType{Value: value}
+}
+
+// Parses the name of a builtin type that is generic over one type, and
+// returns the type it is applied to
+func parseTypeArgument(l lexer.Lexer, name lexer.TokenType) (lexer.Lexer, TypeExpression) {
+	new, toks := allOf(l, name, lexer.LT)
+	if toks == nil {
+		return l, nil
+	}
+
+	new, arg := parseTypeExpr(new)
+	if arg == nil {
+		return l, nil
+	}
+
+	new, tok := new.Next()
+	if tok.Type != lexer.GT {
+		return l, nil
+	}
+
+	return new, arg
+}
+
+// structType := "{", IDENT, ":", typeExpr, {",", IDENT, ":", typeExpr}, "}"
+func parseStructType(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
+	new, tok := l.Next()
+	if tok.Type != lexer.LBRACE {
+		return l, nil
+	}
+
+	var fields []StructField
+	for {
+		if len(fields) > 0 {
+			if newer, tok := new.Next(); tok.Type == lexer.RBRACE {
+				new = newer
+				break
+			} else if tok.Type != lexer.COMMA {
+				return l, nil
+			} else {
+				new = newer
+			}
+		}
+
+		var name []lexer.Token
+		new, name = allOf(new, lexer.IDENT, lexer.ASSIGN_T)
+		if name == nil {
+			return l, nil
+		}
+
+		var tipe TypeExpression
+		new, tipe = parseTypeExpr(new)
+		if tipe == nil {
+			return l, nil
+		}
+		fields = append(fields, StructField{Name: name[0].Lexeme, Tipe: tipe})
+	}
+
+	return new, &StructType{Fields: fields}
+}
+
+// arrayType := "[", typeExpr, ";", INT, "]"
+func parseArrayType(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
+	new, tok := l.Next()
+	if tok.Type != lexer.LBRACKET {
+		return l, nil
+	}
+
+	new, elem := parseTypeExpr(new)
+	if elem == nil {
+		return l, nil
+	}
+
+	new, toks := allOf(new, lexer.SEMICOLON, lexer.INT, lexer.RBRACKET)
+	if toks == nil {
+		return l, nil
+	}
+
+	length, err := strconv.Atoi(toks[1].Lexeme)
+	if err != nil || length < 0 {
+		return l, nil
+	}
+
+	return new, &ArrayType{Elem: elem, Length: length}
+}
+
+// A tuple has at least two elements, so that it isn't confused with a type in parentheses
+// tupleType := typeList
+func parseTupleType(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
+	new, types := parseTypeList(l)
+	if len(types) < 2 {
+		return l, nil
+	}
+	return new, &TupleType{types}
+}
+
+// typeList := "(", [typeExpr, {",", typeExpr}], ")"
+func parseTypeList(l lexer.Lexer) (lexer.Lexer, []TypeExpression) {
 	new, tok := l.Next()
 
 	if tok.Type != lexer.LPAREN {
 		return l, nil
 	}
 
-	var types []TypeExpression
+	types := make([]TypeExpression, 0)
 	for {
 		// If we reached an RPAREN, we're done
 		if _, tok := new.Next(); tok.Type == lexer.RPAREN {
@@ -251,7 +924,7 @@ func parseArrowType(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
 			break
 		}
 
-		// Expect a comma between each parameter
+		// Expect a comma between each type
 		if len(types) > 0 {
 			new, tok = new.Next()
 			if tok.Type != lexer.COMMA {
@@ -267,8 +940,18 @@ func parseArrowType(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
 		}
 		types = append(types, tipe)
 	}
+	return new, types
+}
 
-	if new, tok = new.Next(); tok.Type != lexer.ARROW {
+// arrowType := typeList, "->", typeExpr
+func parseArrowType(l lexer.Lexer) (lexer.Lexer, TypeExpression) {
+	new, types := parseTypeList(l)
+	if types == nil {
+		return l, nil
+	}
+
+	new, tok := new.Next()
+	if tok.Type != lexer.ARROW {
 		return l, nil
 	}
 
@@ -329,26 +1012,23 @@ func parseFuncParams(l lexer.Lexer) (lexer.Lexer, []FunctionParameter) {
 		case lexer.COMMA:
 			return action(new, params)
 		case lexer.IDENT:
-			new, assign_t := new.Next()
-			if assign_t.Type != lexer.ASSIGN_T {
-				return l, nil
-			}
-			new, tipe := parseTypeExpr(new)
-			if tipe == nil {
-				return l, nil
-			}
+			new, tipe := parseTypeAnnotation(new)
 			params = append(params, FunctionParameter{IdentExpr{tok.Lexeme}, tipe})
 			return action(new, params)
 		default:
 			return l, nil
 		}
 	}
-	var params []FunctionParameter
+	params := make([]FunctionParameter, 0)
 	new, params = action(new, params)
+	if params == nil {
+		return l, nil
+	}
 	return new, params
 }
 
-// lambda := "def" "(" {func_param} ")" "->" ident block
+// The return type of a lambda is optional
+// lambda := "def" "(" {func_param} ")" ["->" typeExpr] block
 func parseLambdaExpr(l lexer.Lexer) (lexer.Lexer, Expression) {
 	new, toks := allOf(l, lexer.FUNCTION)
 	if toks == nil {
@@ -358,13 +1038,13 @@ func parseLambdaExpr(l lexer.Lexer) (lexer.Lexer, Expression) {
 	if params == nil {
 		return l, nil
 	}
-	new, tok := new.Next()
-	if tok.Type != lexer.ARROW {
-		return l, nil
-	}
-	new, tipe := parseTypeExpr(new)
-	if tipe == nil {
-		return l, nil
+	var tipe TypeExpression
+	if newer, tok := new.Next(); tok.Type == lexer.ARROW {
+		newer, tipe = parseTypeExpr(newer)
+		if tipe == nil {
+			return l, nil
+		}
+		new = newer
 	}
 	new, block := parseBlockBody(new)
 	if block == nil {
@@ -383,10 +1063,44 @@ func ParseStatement(l lexer.Lexer) (lexer.Lexer, Statement, error) {
 		return l, assign, nil
 	}
 
+	l, reassign := parseReassignment(l)
+	if reassign != nil {
+		return l, reassign, nil
+	}
+
+	l, typeDecl := parseTypeDecl(l)
+	if typeDecl != nil {
+		return l, typeDecl, nil
+	}
+
+	l, while := parseWhile(l)
+	if while != nil {
+		return l, while, nil
+	}
+
+	l, forLoop := parseFor(l)
+	if forLoop != nil {
+		return l, forLoop, nil
+	}
+
+	l, control := parseLoopControl(l)
+	if control != nil {
+		return l, control, nil
+	}
+
 	errorMsg := fmt.Sprintln("unexpected statement type")
 	return l, nil, errors.New(errorMsg)
 }
 
+// Parses a single expression, for evaluating expressions outside of a program
+func ParseExpression(l lexer.Lexer) (lexer.Lexer, Expression, error) {
+	new, expression := parseExpression(l)
+	if expression == nil {
+		return l, nil, errors.New("expected an expression")
+	}
+	return new, expression, nil
+}
+
 type ParseError struct {
 	Position int
 	Error    error
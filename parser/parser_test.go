@@ -78,6 +78,23 @@ func TestParseExpression(t *testing.T) {
 	test("[1", nil)
 	test("true", &BoolExpr{true})
 	test("3 < 5", &LessThanExpr{&IntExpr{3}, &IntExpr{5}})
+	test("f()", &CallExpr{&IdentExpr{"f"}, []Expression{}})
+	test("f(1, x)(true)", &CallExpr{
+		&CallExpr{&IdentExpr{"f"}, []Expression{&IntExpr{1}, &IdentExpr{"x"}}},
+		[]Expression{&BoolExpr{true}},
+	})
+	test("1 + f(2)", &AddExpr{&IntExpr{1}, &CallExpr{&IdentExpr{"f"}, []Expression{&IntExpr{2}}}})
+	test("[]", &ArrayExpr{[]Expression{}})
+	test("[1, x][0]", &IndexExpr{&ArrayExpr{[]Expression{&IntExpr{1}, &IdentExpr{"x"}}}, &IntExpr{0}})
+	test("m[i][j + 1]", &IndexExpr{
+		&IndexExpr{&IdentExpr{"m"}, &IdentExpr{"i"}},
+		&AddExpr{&IdentExpr{"j"}, &IntExpr{1}},
+	})
+	test("def (x, y: int) { x }", &LambdaExpr{
+		Parameters: []FunctionParameter{{IdentExpr{"x"}, nil}, {IdentExpr{"y"}, &LiteralType{"int"}}},
+		Returns:    nil,
+		Body:       BlockBodyExpr{Statements: []Statement{}, Final: &IdentExpr{"x"}},
+	})
 
 }
 
@@ -111,8 +128,8 @@ func TestParseAssignment(t *testing.T) {
 		}
 	}
 
-	test("let foo: int = 123", &AssignStmt{"foo", &LiteralType{"int"}, &IntExpr{123}, 0})
-	test("let bar: long = -1928", &AssignStmt{"bar", &LiteralType{"long"}, &IntExpr{-1928}, 0})
+	test("let foo: int = 123", &AssignStmt{"foo", &LiteralType{"int"}, &IntExpr{123}, 0, false})
+	test("let bar: long = -1928", &AssignStmt{"bar", &LiteralType{"long"}, &IntExpr{-1928}, 0, false})
 
 	test("let bar: long = 3 - 4 + foo", &AssignStmt{
 		"bar",
@@ -122,21 +139,24 @@ func TestParseAssignment(t *testing.T) {
 			&IdentExpr{"foo"},
 		},
 		0,
+		false,
 	})
 
-	test("let x: bool = false", &AssignStmt{"x", &LiteralType{"bool"}, &BoolExpr{false}, 0})
-	test("let x: bool = 5 < 4", &AssignStmt{"x", &LiteralType{"bool"}, &LessThanExpr{&IntExpr{5}, &IntExpr{4}}, 0})
+	test("let x: bool = false", &AssignStmt{"x", &LiteralType{"bool"}, &BoolExpr{false}, 0, false})
+	test("let x = false", &AssignStmt{"x", nil, &BoolExpr{false}, 0, false})
+	test("let x = 5 < 4", &AssignStmt{"x", nil, &LessThanExpr{&IntExpr{5}, &IntExpr{4}}, 0, false})
+	test("let x: bool = 5 < 4", &AssignStmt{"x", &LiteralType{"bool"}, &LessThanExpr{&IntExpr{5}, &IntExpr{4}}, 0, false})
 	test("let x: (int) -> int = lambda", &AssignStmt{"x",
 		&ArrowType{
 			[]TypeExpression{&LiteralType{"int"}},
 			&LiteralType{"int"},
-		}, &IdentExpr{"lambda"}, 0})
+		}, &IdentExpr{"lambda"}, 0, false})
 
 	test("let x: (int, bool) -> int = lambda", &AssignStmt{"x",
 		&ArrowType{
 			[]TypeExpression{&LiteralType{"int"}, &LiteralType{"bool"}},
 			&LiteralType{"int"},
-		}, &IdentExpr{"lambda"}, 0})
+		}, &IdentExpr{"lambda"}, 0, false})
 
 	test("let x: (int) -> (int) -> bool = lambda", &AssignStmt{"x",
 		&ArrowType{
@@ -145,15 +165,254 @@ func TestParseAssignment(t *testing.T) {
 				[]TypeExpression{&LiteralType{"int"}},
 				&LiteralType{"bool"},
 			},
-		}, &IdentExpr{"lambda"}, 0})
+		}, &IdentExpr{"lambda"}, 0, false})
+
+	test("let x: [[int; 2]; 3] = m", &AssignStmt{"x",
+		&ArrayType{&ArrayType{&LiteralType{"int"}, 2}, 3},
+		&IdentExpr{"m"}, 0, false})
 
 	test("let x: ((int) -> bool) -> bool = lambda", &AssignStmt{"x",
 		&ArrowType{
 			[]TypeExpression{&ArrowType{[]TypeExpression{&LiteralType{"int"}}, &LiteralType{"bool"}}},
 			&LiteralType{"bool"},
 		},
-		&IdentExpr{"lambda"}, 0})
+		&IdentExpr{"lambda"}, 0, false})
+
+}
+
+func TestParseMutableAssignment(t *testing.T) {
+	input := `
+		var x: int = 1
+		x = x + 1
+		a[0] = 2
+	`
+	l := lexer.New(&input)
+	program, err := ParseProgram(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Statement{
+		&AssignStmt{Lhs: "x", Tipe: &LiteralType{"int"}, Rhs: &IntExpr{1}, Pos: 0, Mutable: true},
+		&ReassignStmt{Lhs: &IdentExpr{"x"}, Rhs: &AddExpr{&IdentExpr{"x"}, &IntExpr{1}}, Pos: 17},
+		&ReassignStmt{Lhs: &IndexExpr{&IdentExpr{"a"}, &IntExpr{0}}, Rhs: &IntExpr{2}, Pos: 29},
+	}
+	if !reflect.DeepEqual(program.Statements, expected) {
+		exp, _ := json.Marshal(expected)
+		res, _ := json.Marshal(program.Statements)
+		t.Fatalf("Expected \"%s\", got \"%s\"", exp, res)
+	}
+}
+
+func TestParseWhile(t *testing.T) {
+	input := `while x < 10 { x = x + 1 continue break }`
+	l := lexer.New(&input)
+	_, node, err := ParseStatement(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &WhileStmt{
+		Cond: &LessThanExpr{&IdentExpr{"x"}, &IntExpr{10}},
+		Body: []Statement{
+			&ReassignStmt{Lhs: &IdentExpr{"x"}, Rhs: &AddExpr{&IdentExpr{"x"}, &IntExpr{1}}, Pos: 14},
+			&ContinueStmt{Pos: 24},
+			&BreakStmt{Pos: 33},
+		},
+		Pos: 0,
+	}
+	if !reflect.DeepEqual(node, expected) {
+		exp, _ := json.Marshal(expected)
+		res, _ := json.Marshal(node)
+		t.Fatalf("Expected \"%s\", got \"%s\"", exp, res)
+	}
+}
+
+func TestParseFor(t *testing.T) {
+	var test = func(input string, expected Statement) {
+		l := lexer.New(&input)
+		_, node, err := ParseStatement(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(node, expected) {
+			exp, _ := json.Marshal(expected)
+			res, _ := json.Marshal(node)
+			t.Fatalf("Expected \"%s\", got \"%s\"", exp, res)
+		}
+	}
+
+	test("for i in 0..n { }", &ForStmt{
+		Var:  "i",
+		From: &IntExpr{0},
+		To:   &IdentExpr{"n"},
+		Body: []Statement{},
+	})
+	test("for i in x - 1..=x + 1 { break }", &ForStmt{
+		Var:       "i",
+		From:      &SubExpr{&IdentExpr{"x"}, &IntExpr{1}},
+		To:        &AddExpr{&IdentExpr{"x"}, &IntExpr{1}},
+		Inclusive: true,
+		Body:      []Statement{&BreakStmt{Pos: 24}},
+	})
+}
+
+func TestParseStructs(t *testing.T) {
+	var test = func(input string, expected Statement) {
+		l := lexer.New(&input)
+		_, node, err := ParseStatement(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(node, expected) {
+			exp, _ := json.Marshal(expected)
+			res, _ := json.Marshal(node)
+			t.Fatalf("Expected \"%s\", got \"%s\"", exp, res)
+		}
+	}
+
+	test("type Point = { x: int, y: [int; 2] }", &TypeDeclStmt{
+		Name: "Point",
+		Tipe: &StructType{Fields: []StructField{
+			{Name: "x", Tipe: &LiteralType{"int"}},
+			{Name: "y", Tipe: &ArrayType{Elem: &LiteralType{"int"}, Length: 2}},
+		}},
+	})
+	test("let p = Point { y: 1, x: a.b }", &AssignStmt{
+		Lhs: "p",
+		Rhs: &StructExpr{Name: "Point", Fields: []FieldInit{
+			{Name: "y", Value: &IntExpr{1}},
+			{Name: "x", Value: &FieldExpr{Struct: &IdentExpr{"a"}, Field: "b"}},
+		}},
+	})
+	test("p.x[0].y = 1", &ReassignStmt{
+		Lhs: &FieldExpr{
+			Struct: &IndexExpr{
+				Array: &FieldExpr{Struct: &IdentExpr{"p"}, Field: "x"},
+				Index: &IntExpr{0},
+			},
+			Field: "y",
+		},
+		Rhs: &IntExpr{1},
+	})
+}
+
+func TestParseUnions(t *testing.T) {
+	var test = func(input string, expected Statement) {
+		l := lexer.New(&input)
+		_, node, err := ParseStatement(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(node, expected) {
+			exp, _ := json.Marshal(expected)
+			res, _ := json.Marshal(node)
+			t.Fatalf("Expected \"%s\", got \"%s\"", exp, res)
+		}
+	}
+
+	test("type Shape = Circle(int) | Rect(int, int) | Empty", &TypeDeclStmt{
+		Name: "Shape",
+		Tipe: &UnionType{Variants: []VariantType{
+			{Name: "Circle", Payload: []TypeExpression{&LiteralType{"int"}}},
+			{Name: "Rect", Payload: []TypeExpression{&LiteralType{"int"}, &LiteralType{"int"}}},
+			{Name: "Empty"},
+		}},
+	})
+	// A single name without a payload is an alias
+	test("type Size = int", &TypeDeclStmt{Name: "Size", Tipe: &LiteralType{"int"}})
+	test("let a = match s { Rect(w, h) => w + h, _ => 0, }", &AssignStmt{
+		Lhs: "a",
+		Rhs: &MatchExpr{
+			Value: &IdentExpr{"s"},
+			Arms: []MatchArm{
+				{Variant: "Rect", Bindings: []IdentExpr{{"w"}, {"h"}}, Body: &AddExpr{&IdentExpr{"w"}, &IdentExpr{"h"}}},
+				{Variant: "_", Body: &IntExpr{0}},
+			},
+		},
+	})
+}
 
+func TestParseTuples(t *testing.T) {
+	var test = func(input string, expected Statement) {
+		l := lexer.New(&input)
+		_, node, err := ParseStatement(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(node, expected) {
+			exp, _ := json.Marshal(expected)
+			res, _ := json.Marshal(node)
+			t.Fatalf("Expected \"%s\", got \"%s\"", exp, res)
+		}
+	}
+
+	test("let (a, b): (int, (int) -> bool) = (1, (2))", &DestructureStmt{
+		Lhs: []IdentExpr{{"a"}, {"b"}},
+		Tipe: &TupleType{[]TypeExpression{
+			&LiteralType{"int"},
+			&ArrowType{[]TypeExpression{&LiteralType{"int"}}, &LiteralType{"bool"}},
+		}},
+		Rhs: &TupleExpr{[]Expression{&IntExpr{1}, &IntExpr{2}}},
+	})
+	test("var (x, y) = f()", &DestructureStmt{
+		Lhs:     []IdentExpr{{"x"}, {"y"}},
+		Rhs:     &CallExpr{Callee: &IdentExpr{"f"}, Arguments: []Expression{}},
+		Mutable: true,
+	})
+	test("let f: ((int, bool)) -> (bool, int) = g", &AssignStmt{
+		Lhs: "f",
+		Tipe: &ArrowType{
+			[]TypeExpression{&TupleType{[]TypeExpression{&LiteralType{"int"}, &LiteralType{"bool"}}}},
+			&TupleType{[]TypeExpression{&LiteralType{"bool"}, &LiteralType{"int"}}},
+		},
+		Rhs: &IdentExpr{"g"},
+	})
+}
+
+func TestParseLists(t *testing.T) {
+	var test = func(input string, expected Statement) {
+		l := lexer.New(&input)
+		_, node, err := ParseStatement(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(node, expected) {
+			exp, _ := json.Marshal(expected)
+			res, _ := json.Marshal(node)
+			t.Fatalf("Expected \"%s\", got \"%s\"", exp, res)
+		}
+	}
+
+	test("let xs: list<list<int>> = list[list[1], list[]]", &AssignStmt{
+		Lhs:  "xs",
+		Tipe: &ListType{&ListType{&LiteralType{"int"}}},
+		Rhs: &ListExpr{[]Expression{
+			&ListExpr{[]Expression{&IntExpr{1}}},
+			&ListExpr{[]Expression{}},
+		}},
+	})
+	test("let o: option<list<int>> = some(xs)", &AssignStmt{
+		Lhs:  "o",
+		Tipe: &OptionType{&ListType{&LiteralType{"int"}}},
+		Rhs:  &CallExpr{Callee: &IdentExpr{"some"}, Arguments: []Expression{&IdentExpr{"xs"}}},
+	})
+	test("let n = push(xs, len(xs))", &AssignStmt{
+		Lhs: "n",
+		Rhs: &CallExpr{Callee: &IdentExpr{"push"}, Arguments: []Expression{
+			&IdentExpr{"xs"},
+			&CallExpr{Callee: &IdentExpr{"len"}, Arguments: []Expression{&IdentExpr{"xs"}}},
+		}},
+	})
+	test("for x in xs { t = t + x }", &ForStmt{
+		Var:  "x",
+		From: &IdentExpr{"xs"},
+		Body: []Statement{&ReassignStmt{
+			Lhs: &IdentExpr{"t"},
+			Rhs: &AddExpr{&IdentExpr{"t"}, &IdentExpr{"x"}},
+			Pos: 13,
+		}},
+	})
 }
 
 func TestParseProgram(t *testing.T) {
@@ -289,6 +548,7 @@ func TestParseLambdaExpression(t *testing.T) {
 			},
 		},
 		0,
+		false,
 	}
 
 	difference, err := diff.Diff(expected, node)
@@ -303,3 +563,31 @@ func TestParseLambdaExpression(t *testing.T) {
 
 	}
 }
+
+func TestParseExpressionOnItsOwn(t *testing.T) {
+	input := "xs[0] + 1 let"
+	l, expression, err := ParseExpression(lexer.New(&input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &AddExpr{
+		Lhs: &IndexExpr{Array: &IdentExpr{"xs"}, Index: &IntExpr{0}},
+		Rhs: &IntExpr{1},
+	}
+	difference, err := diff.Diff(expected, expression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(difference) != 0 {
+		diff, _ := json.Marshal(difference)
+		t.Errorf("Failed to parse expression. Diff %s", diff)
+	}
+	if _, tok := l.Next(); tok.Type != lexer.LET {
+		t.Fatalf("Expected the expression to end before let, got %s", tok.Type)
+	}
+
+	input = "let x = 1"
+	if _, _, err := ParseExpression(lexer.New(&input)); err == nil {
+		t.Fatal("Expected a statement not to parse as an expression")
+	}
+}
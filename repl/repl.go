@@ -0,0 +1,130 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"monkey/checker"
+	"monkey/interpreter"
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+)
+
+/*
+Reads a program a piece at a time and runs each piece as soon as it is
+complete. A piece is either statements, whose bindings and types can be used
+by the pieces after it, or an expression, whose value and type are printed.
+A piece continues over several lines while it has unclosed braces, brackets
+or parentheses.
+
+Each piece is checked and run with the interpreter, a piece that fails to
+parse, check or run declares nothing and leaves the bindings before it as
+they were. Only the lists it pushed to before failing stay changed.
+*/
+
+const PROMPT = ">> "
+const CONTINUE_PROMPT = ".. "
+
+func Start(in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	checked := checker.NewSession()
+	evaluated := interpreter.NewSession(checked.Info)
+
+	input := ""
+	for {
+		if input == "" {
+			fmt.Fprint(out, PROMPT)
+		} else {
+			fmt.Fprint(out, CONTINUE_PROMPT)
+		}
+		if !scanner.Scan() {
+			return
+		}
+
+		input += scanner.Text() + "\n"
+		if unclosed(input) > 0 {
+			continue
+		}
+
+		source := strings.TrimSpace(input)
+		input = ""
+		if source != "" {
+			checked = eval(source, checked, evaluated, out)
+		}
+	}
+}
+
+// Returns how many delimiters are opened but not closed yet
+func unclosed(input string) int {
+	depth := 0
+	l := lexer.New(&input)
+	for {
+		var tok lexer.Token
+		l, tok = l.Next()
+		switch tok.Type {
+		case lexer.LBRACE, lexer.LBRACKET, lexer.LPAREN:
+			depth++
+		case lexer.RBRACE, lexer.RBRACKET, lexer.RPAREN:
+			depth--
+		case lexer.EOF:
+			return depth
+		}
+	}
+}
+
+// Runs a complete piece, and returns the session to check the next piece in
+func eval(source string, checked *checker.Session, evaluated *interpreter.Session, out io.Writer) *checker.Session {
+	l := lexer.New(&source)
+
+	if expression := parseExpression(l); expression != nil {
+		tipe, checkErr := checked.CheckExpression(expression)
+		if checkErr != nil {
+			report(out, l, checkErr.Position, checkErr.ToError)
+			return checked
+		}
+		value, runtimeErr := evaluated.Eval(expression)
+		if runtimeErr != nil {
+			report(out, l, runtimeErr.Position, runtimeErr.ToError)
+			return checked
+		}
+		fmt.Fprintln(out, value.Render(), ":", tipe.Render())
+		return checked
+	}
+
+	program, parseErr := parser.ParseProgram(l)
+	if parseErr != nil {
+		report(out, l, parseErr.Position, parseErr.ToError)
+		return checked
+	}
+	next, checkErr := checked.Check(program.Statements)
+	if checkErr != nil {
+		report(out, l, checkErr.Position, checkErr.ToError)
+		return checked
+	}
+	// The types the piece inferred for earlier bindings are kept, since it
+	// might have pushed to their lists before failing
+	if _, runtimeErr := evaluated.Run(program.Statements); runtimeErr != nil {
+		report(out, l, runtimeErr.Position, runtimeErr.ToError)
+		return checked
+	}
+	return next
+}
+
+// Returns the expression if it is the whole of the input
+func parseExpression(l lexer.Lexer) parser.Expression {
+	l, expression, err := parser.ParseExpression(l)
+	if err != nil {
+		return nil
+	}
+	if _, tok := l.Next(); tok.Type != lexer.EOF {
+		return nil
+	}
+	return expression
+}
+
+// Prints a diagnostic the same way the compiler does
+func report(out io.Writer, l lexer.Lexer, position int, toError func(string) error) {
+	l.Position = position
+	fmt.Fprintln(out, toError(l.CurrentLine()))
+}
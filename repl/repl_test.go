@@ -0,0 +1,93 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Returns the lines printed, without the prompts
+func replHelper(input string) []string {
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out)
+
+	lines := []string{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		for strings.HasPrefix(line, PROMPT) || strings.HasPrefix(line, CONTINUE_PROMPT) {
+			line = line[len(PROMPT):]
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestReplKeepsBindings(t *testing.T) {
+	lines := replHelper(`
+		let id = def (x) { x }
+		var total = 0
+		for i in 1..=3 {
+			total = total + id(i)
+		}
+		(total, id(true))
+		type Point = { x: int, y: int }
+		Point { x: 1, y: 2 }
+	`)
+	expected := []string{"(6, true) : (int, bool)", "Point { x: 1, y: 2 } : Point"}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
+
+func TestReplReportsErrors(t *testing.T) {
+	lines := replHelper(`
+		let xs = list[1]
+		let y = xs[1]
+		y
+		xs
+	`)
+	expected := []string{
+		"[runtime err]: index out of bounds", "Culprit:", ">>> let y = xs[1]",
+		// A piece that fails declares nothing
+		"[type err]: unbound variable y", "Culprit:", ">>> y",
+		"list[1] : list<int>",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
+
+func TestReplUndoesFailedPieces(t *testing.T) {
+	lines := replHelper(`
+		var xs = list[]
+		let y: bool = push(xs, true)
+		push(xs, 1)
+		var i = 0
+		i = 5 let z = xs[i]
+		i
+	`)
+	expected := []string{
+		"[type err]: type mismatch: expected bool, found int (cannot unify bool with int)", "Culprit:", ">>> let y: bool = push(xs, true)",
+		"1 : int",
+		"[runtime err]: index out of bounds", "Culprit:", ">>> i = 5 let z = xs[i]",
+		// The reassignment before the error is undone as well
+		"0 : int",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
+
+func TestUnclosed(t *testing.T) {
+	for input, expected := range map[string]int{
+		"let f = def (x) {":      1,
+		"let xs = [(1, 2), (3":   2,
+		"let x = { 1 }":          0,
+		"match x { Circle(r) =>": 1,
+	} {
+		if depth := unclosed(input); depth != expected {
+			t.Fatalf("Expected %d unclosed delimiters in \"%s\", got %d", expected, input, depth)
+		}
+	}
+}
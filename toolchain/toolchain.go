@@ -0,0 +1,64 @@
+package toolchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+/*
+Builds and runs the assembly emitted by the compiler, the same way as the
+run-test target of the Makefile. The compiler emits Mach-O system calls, so
+native programs can only be built and run on macOS with nasm and ld installed.
+*/
+
+// Whether native programs can be built and run here
+func Available() bool {
+	_, nasmErr := exec.LookPath("nasm")
+	_, ldErr := exec.LookPath("ld")
+	return runtime.GOOS == "darwin" && nasmErr == nil && ldErr == nil
+}
+
+// Assembles and links a program in dir, and returns the path of the executable
+func Build(assembly string, dir string) (string, error) {
+	source := filepath.Join(dir, "program.s")
+	object := filepath.Join(dir, "program.o")
+	binary := filepath.Join(dir, "program")
+	if err := os.WriteFile(source, []byte(assembly), 0644); err != nil {
+		return "", err
+	}
+
+	for _, command := range [][]string{
+		{"nasm", "-fmacho64", source, "-o", object},
+		{"ld", "-static", "-e", "_start", "-o", binary, object},
+	} {
+		if output, err := exec.Command(command[0], command[1:]...).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("%s failed: %s\n%s", command[0], err, output)
+		}
+	}
+	return binary, nil
+}
+
+// Runs an executable and returns its exit code and what it wrote to stdout
+func Run(binary string) (int, string, error) {
+	exitCode, stdout, _, err := RunWithStderr(binary)
+	return exitCode, stdout, err
+}
+
+// Like Run, but also returns what the executable wrote to stderr
+func RunWithStderr(binary string) (int, string, string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); err != nil && !errors.As(err, &exitErr) {
+		return 0, "", "", err
+	}
+	return cmd.ProcessState.ExitCode(), stdout.String(), stderr.String(), nil
+}
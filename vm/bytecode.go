@@ -0,0 +1,222 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+/*
+Bytecode for a stack machine. An instruction is a one byte opcode followed by
+its operands, which are unsigned and big endian. Functions can only see their
+own parameters, so every value a function works with is either on its stack
+or in one of its locals, and there are no globals or captured variables.
+
+Operands are evaluated in the same order as in the native program, so where
+the native program pushes the elements of a literal from last to first, the
+first element ends up on the top of the stack.
+*/
+
+type Opcode byte
+
+const (
+	// Pushes the integer constant with the given index
+	OpConst Opcode = iota
+	OpTrue
+	OpFalse
+	// Pushes the function with the given index
+	OpFunction
+	OpPop
+	OpDup
+
+	// Pops the right operand, then the left one
+	OpAdd
+	OpLessThan
+	OpGreaterThan
+	// Pops the left operand, then the right one, as the right operand of a
+	// subtraction is evaluated first
+	OpSub
+	OpNot
+
+	OpGetLocal
+	OpSetLocal
+
+	// Jumps to an offset in the code of the function
+	OpJump
+	// Pops a bool and jumps if it is false
+	OpJumpIfFalse
+
+	// Pops the callee then as many arguments as the operand, the last
+	// argument first. Pushes the result.
+	OpCall
+	OpReturn
+	// Stops the program, the value on the top of the stack is the result of
+	// the program if there is one
+	OpHalt
+
+	// Arrays, tuples and structs are all records of their elements. Pops as
+	// many elements as the operand, the first element first.
+	OpRecord
+	// Same as OpRecord, but makes a list
+	OpList
+	// Pops an index, then an array or list, and pushes the element
+	OpIndex
+	// Replaces the record on the top of the stack with the element at the
+	// index given by the operand
+	OpElement
+	// Pops a variant and pushes whether it is the one with the given tag
+	OpIsVariant
+	// Pops the elements of the payload, the first element first, and pushes
+	// a variant with the tag given by the first operand
+	OpVariant
+	// Replaces the variant on the top of the stack with the element of its
+	// payload at the given index
+	OpPayload
+	OpLen
+	// Pops a value, then a list, appends the value and pushes the new length
+	OpPush
+
+	// Checks the index on the top of the stack is less than the operand
+	OpBounds
+	// Checks the index on the top of the stack is in bounds of the list below it
+	OpListBounds
+	// Pops as many indices as the operand, the index into the innermost record
+	// first, then the value. Writes the value to the element of the local.
+	OpStoreLocal
+	// Like OpStoreLocal, but the path starts at an element of a list, which is
+	// popped after the indices: the index into the list, then the list
+	OpStoreList
+)
+
+type definition struct {
+	Name string
+
+	// The width in bytes of each operand
+	Operands []int
+}
+
+var definitions = map[Opcode]definition{
+	OpConst:       {"const", []int{2}},
+	OpTrue:        {"true", []int{}},
+	OpFalse:       {"false", []int{}},
+	OpFunction:    {"function", []int{2}},
+	OpPop:         {"pop", []int{}},
+	OpDup:         {"dup", []int{}},
+	OpAdd:         {"add", []int{}},
+	OpLessThan:    {"lt", []int{}},
+	OpGreaterThan: {"gt", []int{}},
+	OpSub:         {"sub", []int{}},
+	OpNot:         {"not", []int{}},
+	OpGetLocal:    {"get", []int{2}},
+	OpSetLocal:    {"set", []int{2}},
+	OpJump:        {"jump", []int{2}},
+	OpJumpIfFalse: {"jumpf", []int{2}},
+	OpCall:        {"call", []int{1}},
+	OpReturn:      {"return", []int{}},
+	OpHalt:        {"halt", []int{}},
+	OpRecord:      {"record", []int{2}},
+	OpList:        {"list", []int{2}},
+	OpIndex:       {"index", []int{}},
+	OpElement:     {"element", []int{2}},
+	OpIsVariant:   {"isvariant", []int{1}},
+	OpVariant:     {"variant", []int{1, 1}},
+	OpPayload:     {"payload", []int{1}},
+	OpLen:         {"len", []int{}},
+	OpPush:        {"push", []int{}},
+	OpBounds:      {"bounds", []int{2}},
+	OpListBounds:  {"listbounds", []int{}},
+	OpStoreLocal:  {"storelocal", []int{2, 1}},
+	OpStoreList:   {"storelist", []int{1}},
+}
+
+// A compiled program, the first function is the top level of the program
+type Program struct {
+	Constants []int64
+	Functions []*Function
+}
+
+type Function struct {
+	Parameters int
+	Locals     int
+	Code       []byte
+
+	// The position in the source of the statement each part of the code was
+	// compiled from, ordered by offset
+	Positions []Position
+}
+
+type Position struct {
+	Offset   int
+	Position int
+}
+
+// Returns the source position of the statement the code at the offset is in,
+// or false if it is in none, like the body of a lambda that is an expression
+func (f *Function) positionAt(offset int) (int, bool) {
+	position, found := 0, false
+	for _, p := range f.Positions {
+		if p.Offset > offset {
+			break
+		}
+		position, found = p.Position, true
+	}
+	return position, found
+}
+
+// Encodes an instruction, returns false if an operand does not fit its width
+func makeInstruction(op Opcode, operands ...int) ([]byte, bool) {
+	def := definitions[op]
+	instruction := []byte{byte(op)}
+	for i, width := range def.Operands {
+		operand := operands[i]
+		if operand < 0 || operand >= 1<<(8*width) {
+			return nil, false
+		}
+		switch width {
+		case 1:
+			instruction = append(instruction, byte(operand))
+		case 2:
+			instruction = binary.BigEndian.AppendUint16(instruction, uint16(operand))
+		}
+	}
+	return instruction, true
+}
+
+// Decodes the operands of the instruction at the offset, and returns them
+// with the offset of the next instruction
+func readOperands(code []byte, offset int) ([]int, int) {
+	def := definitions[Opcode(code[offset])]
+	operands := make([]int, len(def.Operands))
+	offset++
+	for i, width := range def.Operands {
+		switch width {
+		case 1:
+			operands[i] = int(code[offset])
+		case 2:
+			operands[i] = int(binary.BigEndian.Uint16(code[offset:]))
+		}
+		offset += width
+	}
+	return operands, offset
+}
+
+// Renders the code of a function, one instruction per line
+func Disassemble(code []byte) string {
+	var b = strings.Builder{}
+	for offset := 0; offset < len(code); {
+		def, ok := definitions[Opcode(code[offset])]
+		if !ok {
+			b.WriteString(fmt.Sprintf("%04d unknown opcode %d\n", offset, code[offset]))
+			offset++
+			continue
+		}
+		operands, next := readOperands(code, offset)
+		b.WriteString(fmt.Sprintf("%04d %s", offset, def.Name))
+		for _, operand := range operands {
+			b.WriteString(fmt.Sprint(" ", operand))
+		}
+		b.WriteString("\n")
+		offset = next
+	}
+	return b.String()
+}
@@ -0,0 +1,574 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"monkey/checker"
+	"monkey/parser"
+)
+
+type CompilerError struct {
+	Error    error
+	Position int
+}
+
+func (e *CompilerError) ToError(source string) error {
+	return errors.New(fmt.Sprint("[compiler err]: ", e.Error, "\nCulprit:\n>>> ", source))
+}
+
+type compiler struct {
+	info    *checker.Info
+	program *Program
+
+	// The index of each constant in the program
+	constants map[int64]int
+
+	// The position of the statement being compiled
+	position int
+
+	// Whether an operand did not fit in its instruction
+	tooLarge bool
+}
+
+// The state of a function while it is compiled
+type function struct {
+	*compiler
+	*Function
+
+	// The local each binding of the function is stored in
+	locals map[*checker.Symbol]int
+
+	// The loops around the code being compiled, innermost last
+	loops []*loop
+}
+
+// The jumps out of a loop, which are patched once the end of the loop and the
+// start of the next iteration are known
+type loop struct {
+	breaks    []int
+	continues []int
+}
+
+// Compiles a program to bytecode. The program must already have been checked,
+// info is the result of checker.Check for that program.
+func Compile(program parser.Program, info *checker.Info) (*Program, *CompilerError) {
+	c := &compiler{info: info, program: &Program{}, constants: map[int64]int{}}
+	main := c.newFunction()
+
+	for _, statement := range program.Statements {
+		if err := main.compileStatement(statement); err != nil {
+			return nil, &CompilerError{err, c.position}
+		}
+	}
+
+	// The result of the program is the value bound by its last statement
+	if len(program.Statements) > 0 {
+		if assign, ok := program.Statements[len(program.Statements)-1].(*parser.AssignStmt); ok {
+			main.emit(OpGetLocal, main.local(info.Defs[assign]))
+		}
+	}
+	main.emit(OpHalt)
+
+	if c.tooLarge {
+		return nil, &CompilerError{errors.New("the program is too large for the bytecode format"), 0}
+	}
+	return c.program, nil
+}
+
+// Adds a new function to the program
+func (c *compiler) newFunction() *function {
+	fn := &function{compiler: c, Function: &Function{}, locals: map[*checker.Symbol]int{}}
+	c.program.Functions = append(c.program.Functions, fn.Function)
+	return fn
+}
+
+func (c *compiler) constant(value int64) int {
+	if index, ok := c.constants[value]; ok {
+		return index
+	}
+	c.constants[value] = len(c.program.Constants)
+	c.program.Constants = append(c.program.Constants, value)
+	return c.constants[value]
+}
+
+// Appends an instruction and returns its offset
+func (fn *function) emit(op Opcode, operands ...int) int {
+	instruction, ok := makeInstruction(op, operands...)
+	if !ok {
+		fn.tooLarge = true
+		instruction, _ = makeInstruction(op, make([]int, len(operands))...)
+	}
+	offset := len(fn.Code)
+	fn.Code = append(fn.Code, instruction...)
+	return offset
+}
+
+// Makes the jump at the offset go to the end of the code
+func (fn *function) patch(offset int) {
+	fn.patchTo(offset, len(fn.Code))
+}
+
+func (fn *function) patchTo(offset int, target int) {
+	if target >= 1<<16 {
+		fn.tooLarge = true
+	}
+	binary.BigEndian.PutUint16(fn.Code[offset+1:], uint16(target))
+}
+
+// Returns the local of a binding, which is allocated the first time the
+// binding is seen
+func (fn *function) local(symbol *checker.Symbol) int {
+	if local, ok := fn.locals[symbol]; ok {
+		return local
+	}
+	fn.locals[symbol] = fn.temp()
+	return fn.locals[symbol]
+}
+
+// Allocates a local that is not bound to a name
+func (fn *function) temp() int {
+	fn.Locals++
+	return fn.Locals - 1
+}
+
+func (fn *function) compileStatement(statement parser.Statement) error {
+	fn.position = statement.Position()
+	fn.Positions = append(fn.Positions, Position{Offset: len(fn.Code), Position: statement.Position()})
+
+	switch statement := statement.(type) {
+	case *parser.AssignStmt:
+		if err := fn.compileExpression(statement.Rhs); err != nil {
+			return err
+		}
+		fn.emit(OpSetLocal, fn.local(fn.info.Defs[statement]))
+		return nil
+	case *parser.DestructureStmt:
+		if err := fn.compileExpression(statement.Rhs); err != nil {
+			return err
+		}
+		for i := range statement.Lhs {
+			fn.emit(OpDup)
+			fn.emit(OpElement, i)
+			fn.emit(OpSetLocal, fn.local(fn.info.Defs[&statement.Lhs[i]]))
+		}
+		fn.emit(OpPop)
+		return nil
+	case *parser.ReassignStmt:
+		return fn.compileAssignment(statement.Lhs, statement.Rhs)
+	case *parser.TypeDeclStmt:
+		// Types only exist at compile time
+		return nil
+	case *parser.WhileStmt:
+		return fn.compileWhile(statement)
+	case *parser.ForStmt:
+		return fn.compileFor(statement)
+	case *parser.BreakStmt:
+		loop := fn.loops[len(fn.loops)-1]
+		loop.breaks = append(loop.breaks, fn.emit(OpJump, 0))
+		return nil
+	case *parser.ContinueStmt:
+		loop := fn.loops[len(fn.loops)-1]
+		loop.continues = append(loop.continues, fn.emit(OpJump, 0))
+		return nil
+	}
+	return errors.New("unexpected statement type")
+}
+
+func (fn *function) compileStatements(statements []parser.Statement) error {
+	for _, statement := range statements {
+		if err := fn.compileStatement(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compiles the body of a loop, then patches break to jump to the end of the
+// loop and continue to jump to next, which is emitted after the body
+func (fn *function) compileLoopBody(body []parser.Statement, next func()) error {
+	loop := &loop{}
+	fn.loops = append(fn.loops, loop)
+	err := fn.compileStatements(body)
+	fn.loops = fn.loops[:len(fn.loops)-1]
+	if err != nil {
+		return err
+	}
+
+	for _, offset := range loop.continues {
+		fn.patch(offset)
+	}
+	next()
+	for _, offset := range loop.breaks {
+		fn.patch(offset)
+	}
+	return nil
+}
+
+func (fn *function) compileWhile(statement *parser.WhileStmt) error {
+	start := len(fn.Code)
+	if err := fn.compileExpression(statement.Cond); err != nil {
+		return err
+	}
+	end := fn.emit(OpJumpIfFalse, 0)
+
+	return fn.compileLoopBody(statement.Body, func() {
+		fn.emit(OpJump, start)
+		fn.patch(end)
+	})
+}
+
+/*
+A range keeps the next value of the loop variable and the upper bound in
+locals of its own, so the body can not change them. A loop over a list keeps
+the list and the index of the next element, and looks at the length of the
+list before each iteration.
+*/
+func (fn *function) compileFor(statement *parser.ForStmt) error {
+	if err := fn.compileExpression(statement.From); err != nil {
+		return err
+	}
+	variable := fn.local(fn.info.Defs[statement])
+	counter := fn.temp()
+
+	var start, end int
+	if statement.To == nil {
+		list := fn.temp()
+		fn.emit(OpSetLocal, list)
+		fn.emit(OpConst, fn.constant(0))
+		fn.emit(OpSetLocal, counter)
+
+		start = len(fn.Code)
+		fn.emit(OpGetLocal, counter)
+		fn.emit(OpGetLocal, list)
+		fn.emit(OpLen)
+		fn.emit(OpLessThan)
+		end = fn.emit(OpJumpIfFalse, 0)
+		fn.emit(OpGetLocal, list)
+		fn.emit(OpGetLocal, counter)
+		fn.emit(OpIndex)
+		fn.emit(OpSetLocal, variable)
+	} else {
+		fn.emit(OpSetLocal, counter)
+		if err := fn.compileExpression(statement.To); err != nil {
+			return err
+		}
+		limit := fn.temp()
+		fn.emit(OpSetLocal, limit)
+
+		start = len(fn.Code)
+		fn.emit(OpGetLocal, counter)
+		fn.emit(OpGetLocal, limit)
+		if statement.Inclusive {
+			fn.emit(OpGreaterThan)
+			fn.emit(OpNot)
+		} else {
+			fn.emit(OpLessThan)
+		}
+		end = fn.emit(OpJumpIfFalse, 0)
+		fn.emit(OpGetLocal, counter)
+		fn.emit(OpSetLocal, variable)
+	}
+
+	return fn.compileLoopBody(statement.Body, func() {
+		fn.emit(OpGetLocal, counter)
+		fn.emit(OpConst, fn.constant(1))
+		fn.emit(OpAdd)
+		fn.emit(OpSetLocal, counter)
+		fn.emit(OpJump, start)
+		fn.patch(end)
+	})
+}
+
+/*
+Writes a value to a binding, or to an element of it. The value is evaluated
+first, then the indices from the root to the element, each of which is checked
+to be in bounds as soon as it is known. A list is changed in place, so the path
+starts from the innermost list, like in the native program.
+*/
+func (fn *function) compileAssignment(target parser.Expression, value parser.Expression) error {
+	if err := fn.compileExpression(value); err != nil {
+		return err
+	}
+
+	// Find the binding or list at the root and the element at each level
+	var path []parser.Expression
+	var root parser.Expression = target
+	var list *parser.IndexExpr
+	for list == nil {
+		if index, ok := root.(*parser.IndexExpr); ok {
+			path = append([]parser.Expression{index}, path...)
+			root = index.Array
+			if isList(fn.info.TypeOf(index.Array)) {
+				list = index
+			}
+		} else if field, ok := root.(*parser.FieldExpr); ok {
+			path = append([]parser.Expression{field}, path...)
+			root = field.Struct
+		} else {
+			break
+		}
+	}
+
+	if list != nil {
+		if err := fn.compileExpression(list.Array); err != nil {
+			return err
+		}
+		if err := fn.compileExpression(list.Index); err != nil {
+			return err
+		}
+		fn.emit(OpListBounds)
+		path = path[1:]
+	}
+
+	for _, element := range path {
+		switch element := element.(type) {
+		case *parser.IndexExpr:
+			if err := fn.compileExpression(element.Index); err != nil {
+				return err
+			}
+			fn.emit(OpBounds, fn.info.TypeOf(element.Array).(*checker.TArray).Length)
+		case *parser.FieldExpr:
+			tipe := fn.info.TypeOf(element.Struct).(*checker.TStruct)
+			fn.emit(OpConst, fn.constant(int64(fieldIndex(tipe, element.Field))))
+		}
+	}
+
+	if list != nil {
+		fn.emit(OpStoreList, len(path))
+		return nil
+	}
+	local := fn.local(fn.info.Uses[root.(*parser.IdentExpr)])
+	if len(path) == 0 {
+		fn.emit(OpSetLocal, local)
+	} else {
+		fn.emit(OpStoreLocal, local, len(path))
+	}
+	return nil
+}
+
+func isList(t checker.Type) bool {
+	tipe, ok := checker.Resolve(t).(*checker.TCon)
+	return ok && tipe.Name == "list"
+}
+
+func fieldIndex(tipe *checker.TStruct, name string) int {
+	for i, field := range tipe.Fields {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the index of a variant in the union or option it is part of
+func variantTag(t checker.Type, name string) int {
+	for i, variant := range checker.VariantsOf(checker.Resolve(t)) {
+		if variant.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Leaves the value of the expression on the top of the stack
+func (fn *function) compileExpression(expression parser.Expression) error {
+	// Constructors look like identifiers or calls
+	if name, ok := fn.info.Variants[expression]; ok {
+		var arguments []parser.Expression
+		if call, ok := expression.(*parser.CallExpr); ok {
+			arguments = call.Arguments
+		}
+		if err := fn.compileBackwards(arguments); err != nil {
+			return err
+		}
+		fn.emit(OpVariant, variantTag(fn.info.TypeOf(expression), name), len(arguments))
+		return nil
+	}
+
+	switch expression := expression.(type) {
+	case *parser.IntExpr:
+		fn.emit(OpConst, fn.constant(int64(expression.Value)))
+		return nil
+	case *parser.BoolExpr:
+		if expression.Value {
+			fn.emit(OpTrue)
+		} else {
+			fn.emit(OpFalse)
+		}
+		return nil
+	case *parser.IdentExpr:
+		fn.emit(OpGetLocal, fn.local(fn.info.Uses[expression]))
+		return nil
+	case *parser.AddExpr:
+		return fn.compileOperands(expression.Lhs, expression.Rhs, OpAdd)
+	case *parser.SubExpr:
+		return fn.compileOperands(expression.Rhs, expression.Lhs, OpSub)
+	case *parser.LessThanExpr:
+		return fn.compileOperands(expression.Lhs, expression.Rhs, OpLessThan)
+	case *parser.GreaterThanExpr:
+		return fn.compileOperands(expression.Lhs, expression.Rhs, OpGreaterThan)
+	case *parser.BlockBodyExpr:
+		if err := fn.compileStatements(expression.Statements); err != nil {
+			return err
+		}
+		return fn.compileExpression(expression.Final)
+	case *parser.LambdaExpr:
+		return fn.compileLambda(expression)
+	case *parser.CallExpr:
+		return fn.compileCall(expression)
+	case *parser.ArrayExpr:
+		return fn.compileElements(expression.Elements, OpRecord)
+	case *parser.TupleExpr:
+		return fn.compileElements(expression.Elements, OpRecord)
+	case *parser.ListExpr:
+		return fn.compileElements(expression.Elements, OpList)
+	case *parser.IndexExpr:
+		return fn.compileOperands(expression.Array, expression.Index, OpIndex)
+	case *parser.StructExpr:
+		return fn.compileStruct(expression)
+	case *parser.FieldExpr:
+		if err := fn.compileExpression(expression.Struct); err != nil {
+			return err
+		}
+		tipe := fn.info.TypeOf(expression.Struct).(*checker.TStruct)
+		fn.emit(OpElement, fieldIndex(tipe, expression.Field))
+		return nil
+	case *parser.MatchExpr:
+		return fn.compileMatch(expression)
+	}
+	return errors.New("unexpected expression type")
+}
+
+func (fn *function) compileOperands(first parser.Expression, second parser.Expression, op Opcode) error {
+	if err := fn.compileExpression(first); err != nil {
+		return err
+	}
+	if err := fn.compileExpression(second); err != nil {
+		return err
+	}
+	fn.emit(op)
+	return nil
+}
+
+// Evaluates from the last expression to the first, the order in which the
+// native program pushes them
+func (fn *function) compileBackwards(expressions []parser.Expression) error {
+	for i := len(expressions) - 1; i >= 0; i-- {
+		if err := fn.compileExpression(expressions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fn *function) compileElements(elements []parser.Expression, op Opcode) error {
+	if err := fn.compileBackwards(elements); err != nil {
+		return err
+	}
+	fn.emit(op, len(elements))
+	return nil
+}
+
+// The fields are evaluated from the last declared to the first, whatever order
+// they are written in
+func (fn *function) compileStruct(expression *parser.StructExpr) error {
+	tipe := fn.info.TypeOf(expression).(*checker.TStruct)
+	values := map[string]parser.Expression{}
+	for _, init := range expression.Fields {
+		values[init.Name] = init.Value
+	}
+
+	fields := make([]parser.Expression, 0, len(tipe.Fields))
+	for _, field := range tipe.Fields {
+		fields = append(fields, values[field.Name])
+	}
+	return fn.compileElements(fields, OpRecord)
+}
+
+// Each lambda is compiled to a function of its own, its parameters are its
+// first locals
+func (fn *function) compileLambda(expression *parser.LambdaExpr) error {
+	lambda := fn.newFunction()
+	index := len(fn.program.Functions) - 1
+	lambda.Parameters = len(expression.Parameters)
+	for i := range expression.Parameters {
+		lambda.local(fn.info.Defs[&expression.Parameters[i]])
+	}
+
+	if err := lambda.compileExpression(&expression.Body); err != nil {
+		return err
+	}
+	lambda.emit(OpReturn)
+
+	fn.emit(OpFunction, index)
+	return nil
+}
+
+// The arguments are evaluated before the callee
+func (fn *function) compileCall(expression *parser.CallExpr) error {
+	for _, argument := range expression.Arguments {
+		if err := fn.compileExpression(argument); err != nil {
+			return err
+		}
+	}
+
+	switch fn.info.Builtins[expression] {
+	case "len":
+		fn.emit(OpLen)
+		return nil
+	case "push":
+		fn.emit(OpPush)
+		return nil
+	}
+
+	if err := fn.compileExpression(expression.Callee); err != nil {
+		return err
+	}
+	fn.emit(OpCall, len(expression.Arguments))
+	return nil
+}
+
+// The value is kept in a local while the arms are tried in order. The checker
+// made sure the arms are exhaustive, so the last arm is taken without a check.
+func (fn *function) compileMatch(expression *parser.MatchExpr) error {
+	if err := fn.compileExpression(expression.Value); err != nil {
+		return err
+	}
+	value := fn.temp()
+	fn.emit(OpSetLocal, value)
+
+	tipe := fn.info.TypeOf(expression.Value)
+	ends := []int{}
+	for i := range expression.Arms {
+		arm := &expression.Arms[i]
+		last := i == len(expression.Arms)-1
+
+		next := -1
+		if arm.Variant != "_" && !last {
+			fn.emit(OpGetLocal, value)
+			fn.emit(OpIsVariant, variantTag(tipe, arm.Variant))
+			next = fn.emit(OpJumpIfFalse, 0)
+		}
+		for j := range arm.Bindings {
+			fn.emit(OpGetLocal, value)
+			fn.emit(OpPayload, j)
+			fn.emit(OpSetLocal, fn.local(fn.info.Defs[&arm.Bindings[j]]))
+		}
+		if err := fn.compileExpression(arm.Body); err != nil {
+			return err
+		}
+
+		if !last {
+			ends = append(ends, fn.emit(OpJump, 0))
+		}
+		if next >= 0 {
+			fn.patch(next)
+		}
+	}
+
+	for _, offset := range ends {
+		fn.patch(offset)
+	}
+	return nil
+}
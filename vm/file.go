@@ -0,0 +1,161 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+A compiled program is saved to a .thingc file as the magic bytes and the
+version of the format, followed by varints:
+
+	number of constants, then each constant
+	number of functions, then for each function:
+		number of parameters
+		number of locals
+		length of the code, then the code
+		number of positions, then the offset and position of each
+*/
+
+var magic = []byte("THINGC")
+
+// Changes whenever the meaning of the bytecode changes
+const VERSION = 1
+
+func Encode(program *Program) []byte {
+	output := append([]byte{}, magic...)
+	output = binary.AppendUvarint(output, VERSION)
+
+	output = binary.AppendUvarint(output, uint64(len(program.Constants)))
+	for _, constant := range program.Constants {
+		output = binary.AppendVarint(output, constant)
+	}
+
+	output = binary.AppendUvarint(output, uint64(len(program.Functions)))
+	for _, function := range program.Functions {
+		output = binary.AppendUvarint(output, uint64(function.Parameters))
+		output = binary.AppendUvarint(output, uint64(function.Locals))
+		output = binary.AppendUvarint(output, uint64(len(function.Code)))
+		output = append(output, function.Code...)
+		output = binary.AppendUvarint(output, uint64(len(function.Positions)))
+		for _, position := range function.Positions {
+			output = binary.AppendUvarint(output, uint64(position.Offset))
+			output = binary.AppendUvarint(output, uint64(position.Position))
+		}
+	}
+	return output
+}
+
+// Reads a program saved by Encode, and checks that its instructions only refer
+// to code, locals, constants and functions that exist
+func Decode(input []byte) (*Program, error) {
+	if !bytes.HasPrefix(input, magic) {
+		return nil, errors.New("not a compiled program")
+	}
+	r := bytes.NewReader(input[len(magic):])
+
+	var err error
+	// Reads a number, after an error it only returns 0
+	uvarint := func() int {
+		if err != nil {
+			return 0
+		}
+		var n uint64
+		if n, err = binary.ReadUvarint(r); err == nil && n > uint64(r.Len()+1<<16) {
+			err = errors.New("number out of range")
+		}
+		return int(n)
+	}
+
+	if version := uvarint(); err == nil && version != VERSION {
+		return nil, fmt.Errorf("compiled with version %d of the format, expected %d", version, VERSION)
+	}
+
+	program := &Program{}
+	for i := uvarint(); i > 0 && err == nil; i-- {
+		var constant int64
+		constant, err = binary.ReadVarint(r)
+		program.Constants = append(program.Constants, constant)
+	}
+
+	for i := uvarint(); i > 0 && err == nil; i-- {
+		function := &Function{Parameters: uvarint(), Locals: uvarint()}
+		function.Code = make([]byte, uvarint())
+		if err == nil {
+			_, err = io.ReadFull(r, function.Code)
+		}
+		for j := uvarint(); j > 0 && err == nil; j-- {
+			function.Positions = append(function.Positions, Position{Offset: uvarint(), Position: uvarint()})
+		}
+		program.Functions = append(program.Functions, function)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("truncated or corrupt program: %w", err)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("unexpected data after the program")
+	}
+	if err := verify(program); err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+func verify(program *Program) error {
+	if len(program.Functions) == 0 {
+		return errors.New("the program has no functions")
+	}
+
+	for i, function := range program.Functions {
+		invalid := func(offset int, reason string) error {
+			return fmt.Errorf("invalid instruction at %d in function %d: %s", offset, i, reason)
+		}
+		if function.Parameters > function.Locals {
+			return fmt.Errorf("function %d has more parameters than locals", i)
+		}
+
+		// Find where each instruction starts, so jumps can only go there
+		starts := map[int]bool{}
+		var last Opcode
+		for offset := 0; offset < len(function.Code); {
+			last = Opcode(function.Code[offset])
+			def, ok := definitions[last]
+			if !ok {
+				return invalid(offset, "unknown opcode")
+			}
+			width := 0
+			for _, w := range def.Operands {
+				width += w
+			}
+			if offset+1+width > len(function.Code) {
+				return invalid(offset, "truncated operands")
+			}
+			starts[offset] = true
+			offset += 1 + width
+		}
+		if len(function.Code) == 0 || (last != OpReturn && last != OpHalt && last != OpJump) {
+			return fmt.Errorf("function %d does not end with a return, halt or jump", i)
+		}
+
+		for offset := 0; offset < len(function.Code); {
+			op := Opcode(function.Code[offset])
+			operands, next := readOperands(function.Code, offset)
+			switch {
+			case op == OpConst && operands[0] >= len(program.Constants):
+				return invalid(offset, "no such constant")
+			case op == OpFunction && operands[0] >= len(program.Functions):
+				return invalid(offset, "no such function")
+			case (op == OpGetLocal || op == OpSetLocal || op == OpStoreLocal) && operands[0] >= function.Locals:
+				return invalid(offset, "no such local")
+			case (op == OpJump || op == OpJumpIfFalse) && !starts[operands[0]]:
+				return invalid(offset, "jump to a place that is not an instruction")
+			}
+			offset = next
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// Records and variants are never modified once they have been made, so
+	// they can be shared where the native program would copy them. Lists are
+	// shared by reference, like on the heap of the native program.
+	Value interface {
+		isValue()
+		Render() string
+	}
+)
+
+// Integers wrap around like machine words
+type Int int64
+
+func (Int) isValue() {}
+func (i Int) Render() string {
+	return fmt.Sprint(int64(i))
+}
+
+type Bool bool
+
+func (Bool) isValue() {}
+func (b Bool) Render() string {
+	return fmt.Sprint(bool(b))
+}
+
+// The index of a function in the program
+type Func int
+
+func (Func) isValue() {}
+func (f Func) Render() string {
+	return fmt.Sprint("<function ", int(f), ">")
+}
+
+// An array, a tuple or a struct, the fields of a struct are in the order they
+// were declared in
+type Record struct {
+	Elements []Value
+}
+
+func (*Record) isValue() {}
+func (r *Record) Render() string {
+	return fmt.Sprint("(", renderAll(r.Elements), ")")
+}
+
+// The tag of a variant is its index in the union it is part of
+type Variant struct {
+	Tag     int
+	Payload []Value
+}
+
+func (*Variant) isValue() {}
+func (v *Variant) Render() string {
+	return fmt.Sprint("#", v.Tag, "(", renderAll(v.Payload), ")")
+}
+
+type List struct {
+	Elements []Value
+}
+
+func (*List) isValue() {}
+func (l *List) Render() string {
+	return fmt.Sprint("list[", renderAll(l.Elements), "]")
+}
+
+func renderAll(values []Value) string {
+	rendered := make([]string, 0, len(values))
+	for _, value := range values {
+		rendered = append(rendered, value.Render())
+	}
+	return strings.Join(rendered, ", ")
+}
+
+// Returns the status a program exits with from its result. Only ints and
+// bools give one, which is the rule checker.Info.ExitBinding defines.
+func ExitCode(result Value) int {
+	switch result := result.(type) {
+	case Int:
+		return int(uint8(result))
+	case Bool:
+		if result {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,268 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The exit code of a program that indexed out of bounds, the same as for the
+// native program
+const EXIT_OUT_OF_BOUNDS = 101
+
+var errOutOfBounds = errors.New("index out of bounds")
+
+type RuntimeError struct {
+	Error    error
+	Position int
+
+	// The status the program exits with
+	ExitCode int
+}
+
+func (e *RuntimeError) ToError(source string) error {
+	return errors.New(fmt.Sprint("[runtime err]: ", e.Error, "\nCulprit:\n>>> ", source))
+}
+
+type frame struct {
+	function *Function
+	locals   []Value
+
+	// The offset of the next instruction
+	ip int
+}
+
+// Reads an operand of the instruction being executed
+func (f *frame) operand(width int) int {
+	var operand int
+	switch width {
+	case 1:
+		operand = int(f.function.Code[f.ip])
+	case 2:
+		operand = int(binary.BigEndian.Uint16(f.function.Code[f.ip:]))
+	}
+	f.ip += width
+	return operand
+}
+
+type machine struct {
+	program *Program
+	stack   []Value
+	frames  []*frame
+}
+
+// Runs a program and returns its result, which is the value bound by its last
+// statement or nil if it bound nothing
+func Run(program *Program) (result Value, runtimeErr *RuntimeError) {
+	main := program.Functions[0]
+	vm := &machine{
+		program: program,
+		frames:  []*frame{{function: main, locals: make([]Value, main.Locals)}},
+	}
+
+	// Programs that were not compiled from a checked program, or that were
+	// corrupted on disk, may use values of the wrong type
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("invalid bytecode: %v", r)
+			result, runtimeErr = nil, &RuntimeError{Error: err, ExitCode: 1}
+		}
+	}()
+
+	result, err := vm.run()
+	if err != nil {
+		exitCode := 1
+		if err == errOutOfBounds {
+			exitCode = EXIT_OUT_OF_BOUNDS
+		}
+		return nil, &RuntimeError{Error: err, Position: vm.position(), ExitCode: exitCode}
+	}
+	return result, nil
+}
+
+// Returns the position of the statement being run. Code of a function that is
+// not in any of its statements is part of the statement that called it.
+func (vm *machine) position() int {
+	for i := len(vm.frames) - 1; i >= 0; i-- {
+		f := vm.frames[i]
+		if position, ok := f.function.positionAt(f.ip - 1); ok {
+			return position
+		}
+	}
+	return 0
+}
+
+func (vm *machine) push(value Value) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *machine) pop() Value {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+// Pops n values, the value that was on the top of the stack is the first one
+func (vm *machine) popReversed(n int) []Value {
+	values := make([]Value, n)
+	for i := 0; i < n; i++ {
+		values[i] = vm.pop()
+	}
+	return values
+}
+
+// Pops n values, the value that was on the top of the stack is the last one
+func (vm *machine) popInOrder(n int) []Value {
+	values := make([]Value, n)
+	copy(values, vm.stack[len(vm.stack)-n:])
+	vm.stack = vm.stack[:len(vm.stack)-n]
+	return values
+}
+
+func (vm *machine) popInts(n int) []int {
+	values := vm.popInOrder(n)
+	ints := make([]int, n)
+	for i, value := range values {
+		ints[i] = int(value.(Int))
+	}
+	return ints
+}
+
+func (vm *machine) run() (Value, error) {
+	for {
+		f := vm.frames[len(vm.frames)-1]
+		op := Opcode(f.function.Code[f.ip])
+		f.ip++
+
+		switch op {
+		case OpConst:
+			vm.push(Int(vm.program.Constants[f.operand(2)]))
+		case OpTrue:
+			vm.push(Bool(true))
+		case OpFalse:
+			vm.push(Bool(false))
+		case OpFunction:
+			vm.push(Func(f.operand(2)))
+		case OpPop:
+			vm.pop()
+		case OpDup:
+			vm.push(vm.stack[len(vm.stack)-1])
+
+		case OpAdd:
+			rhs, lhs := vm.pop().(Int), vm.pop().(Int)
+			vm.push(lhs + rhs)
+		case OpLessThan:
+			rhs, lhs := vm.pop().(Int), vm.pop().(Int)
+			vm.push(Bool(lhs < rhs))
+		case OpGreaterThan:
+			rhs, lhs := vm.pop().(Int), vm.pop().(Int)
+			vm.push(Bool(lhs > rhs))
+		case OpSub:
+			lhs, rhs := vm.pop().(Int), vm.pop().(Int)
+			vm.push(lhs - rhs)
+		case OpNot:
+			vm.push(!vm.pop().(Bool))
+
+		case OpGetLocal:
+			vm.push(f.locals[f.operand(2)])
+		case OpSetLocal:
+			f.locals[f.operand(2)] = vm.pop()
+
+		case OpJump:
+			f.ip = f.operand(2)
+		case OpJumpIfFalse:
+			target := f.operand(2)
+			if !vm.pop().(Bool) {
+				f.ip = target
+			}
+
+		case OpCall:
+			n := f.operand(1)
+			callee := vm.program.Functions[vm.pop().(Func)]
+			locals := make([]Value, callee.Locals)
+			copy(locals, vm.popInOrder(n))
+			vm.frames = append(vm.frames, &frame{function: callee, locals: locals})
+		case OpReturn:
+			vm.frames = vm.frames[:len(vm.frames)-1]
+		case OpHalt:
+			if len(vm.stack) == 0 {
+				return nil, nil
+			}
+			return vm.pop(), nil
+
+		case OpRecord:
+			vm.push(&Record{Elements: vm.popReversed(f.operand(2))})
+		case OpList:
+			vm.push(&List{Elements: vm.popReversed(f.operand(2))})
+		case OpIndex:
+			index := vm.pop().(Int)
+			elements := elementsOf(vm.pop())
+			if index < 0 || index >= Int(len(elements)) {
+				return nil, errOutOfBounds
+			}
+			vm.push(elements[index])
+		case OpElement:
+			vm.push(vm.pop().(*Record).Elements[f.operand(2)])
+		case OpIsVariant:
+			vm.push(Bool(vm.pop().(*Variant).Tag == f.operand(1)))
+		case OpVariant:
+			tag := f.operand(1)
+			vm.push(&Variant{Tag: tag, Payload: vm.popReversed(f.operand(1))})
+		case OpPayload:
+			vm.push(vm.pop().(*Variant).Payload[f.operand(1)])
+		case OpLen:
+			vm.push(Int(len(vm.pop().(*List).Elements)))
+		case OpPush:
+			value := vm.pop()
+			list := vm.pop().(*List)
+			list.Elements = append(list.Elements, value)
+			vm.push(Int(len(list.Elements)))
+
+		case OpBounds:
+			index := vm.stack[len(vm.stack)-1].(Int)
+			if index < 0 || index >= Int(f.operand(2)) {
+				return nil, errOutOfBounds
+			}
+		case OpListBounds:
+			index := vm.stack[len(vm.stack)-1].(Int)
+			list := vm.stack[len(vm.stack)-2].(*List)
+			if index < 0 || index >= Int(len(list.Elements)) {
+				return nil, errOutOfBounds
+			}
+		case OpStoreLocal:
+			local := f.operand(2)
+			keys := vm.popInts(f.operand(1))
+			f.locals[local] = update(f.locals[local], keys, vm.pop())
+		case OpStoreList:
+			keys := vm.popInts(f.operand(1))
+			index := vm.pop().(Int)
+			list := vm.pop().(*List)
+			list.Elements[index] = update(list.Elements[index], keys, vm.pop())
+
+		default:
+			return nil, fmt.Errorf("unknown opcode %d", op)
+		}
+	}
+}
+
+func elementsOf(value Value) []Value {
+	switch value := value.(type) {
+	case *Record:
+		return value.Elements
+	case *List:
+		return value.Elements
+	}
+	return nil
+}
+
+// Returns a copy of a record with the element at the end of the path replaced
+func update(container Value, keys []int, value Value) Value {
+	if len(keys) == 0 {
+		return value
+	}
+	record := container.(*Record)
+	elements := make([]Value, len(record.Elements))
+	copy(elements, record.Elements)
+	elements[keys[0]] = update(elements[keys[0]], keys[1:], value)
+	return &Record{Elements: elements}
+}
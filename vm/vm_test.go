@@ -0,0 +1,214 @@
+package vm
+
+import (
+	"monkey/checker"
+	"monkey/interpreter"
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func compileHelper(t *testing.T, s string) *Program {
+	lexer := lexer.New(&s)
+	program, error := parser.ParseProgram(lexer)
+	if error != nil {
+		t.Fatal("Could not parse program: ", error)
+	}
+	info, checkErr := checker.Check(*program)
+	if checkErr != nil {
+		t.Fatalf("Failed to check: %s", checkErr.ToError("foo"))
+	}
+	compiled, err := Compile(*program, info)
+	if err != nil {
+		t.Fatalf("Failed to compile: %s", err.ToError("foo"))
+	}
+	return compiled
+}
+
+func expectResult(t *testing.T, s string, expected string) {
+	result, err := Run(compileHelper(t, s))
+	if err != nil {
+		t.Fatalf("Failed to run: %s", err.ToError("foo"))
+	}
+	if result.Render() != expected {
+		t.Fatalf("Expected the program to produce %s, got %s", expected, result.Render())
+	}
+}
+
+func TestCompileBytecode(t *testing.T) {
+	program := compileHelper(t, `
+		let add = def (a, b) { a + b }
+		var xs = [1, 2]
+		xs[1] = add(xs[0], 3)
+	`)
+	expected := []string{
+		"0000 function 1\n0003 set 0\n",
+		// The elements are pushed last first
+		"0006 const 0\n0009 const 1\n0012 record 2\n0015 set 1\n",
+		// The value is evaluated before the index it is stored at, and the
+		// arguments before the callee
+		"0018 get 1\n0021 const 2\n0024 index\n0025 const 3\n0028 get 0\n0031 call 2\n",
+		"0033 const 1\n0036 bounds 2\n0039 storelocal 1 1\n0043 halt\n",
+	}
+	if main := Disassemble(program.Functions[0].Code); main != strings.Join(expected, "") {
+		t.Fatalf("Expected the program to compile to\n%s\ngot\n%s", strings.Join(expected, ""), main)
+	}
+	if add := Disassemble(program.Functions[1].Code); add != "0000 get 0\n0003 get 1\n0006 add\n0007 return\n" {
+		t.Fatalf("Expected add to compile to a single add, got\n%s", add)
+	}
+	if constants := program.Constants; len(constants) != 4 || constants[0] != 2 || constants[3] != 3 {
+		t.Fatalf("Expected the constants 2, 1, 0 and 3, got %v", constants)
+	}
+}
+
+func TestRunBytecode(t *testing.T) {
+	expectResult(t, "let x = 10 - 3 let y = x + 2", "9")
+	expectResult(t, "let id = def (x) { x } let a = id(4) let b = id(true) let y = (a, b)", "(4, true)")
+
+	expectResult(t, `
+		var total = 0
+		for i in 1..=10 {
+			total = total + i
+		}
+		for i in 0..10 {
+			total = total + 1
+			break
+		}
+		var i = 0
+		while i < 5 {
+			i = i + 1
+			continue
+			total = 0
+		}
+		let result = total + i
+	`, "61")
+
+	expectResult(t, `
+		let xs = list[1, 2]
+		var ys = xs
+		var total = 0
+		for x in xs {
+			total = total + x
+			while len(xs) < 4 {
+				let n = push(ys, 10)
+			}
+		}
+		ys[0] = 5
+		let result = (xs, total)
+	`, "(list[5, 2, 10, 10], 23)")
+
+	expectResult(t, `
+		type Point = { x: int, y: int }
+		var ps = [Point { y: 2, x: 1 }, Point { x: 3, y: 4 }]
+		let qs = ps
+		ps[1].y = 7
+		let (a, b) = (ps[1].y, qs[1].y)
+		let result = (a, b, ps[0])
+	`, "(7, 4, (1, 2))")
+
+	expectResult(t, `
+		type Shape = Circle(int) | Rect(int, int) | Empty
+		let area = def (s: Shape) {
+			match s {
+				Circle(r) => r + r,
+				Rect(w, h) => w - h,
+				_ => 0,
+			}
+		}
+		let get = def (o) {
+			match o {
+				some(x) => x,
+				none => 0,
+			}
+		}
+		let result = (area(Circle(3)), area(Rect(5, 2)), area(Empty), get(some(4)), get(none), Rect(1, 2))
+	`, "(6, 3, 0, 4, 0, #1(1, 2))")
+}
+
+func TestRunBytecodeOutOfBounds(t *testing.T) {
+	_, err := Run(compileHelper(t, `
+		let xs = [1, 2, 3]
+		var ys = list[xs]
+		ys[0][3] = 3
+	`))
+	if err == nil || err.Error != errOutOfBounds || err.ExitCode != EXIT_OUT_OF_BOUNDS {
+		t.Fatalf("Expected indexing out of bounds, got %v", err)
+	}
+	if err.Position != 41 {
+		t.Fatalf("Expected the error at position 41, got %d", err.Position)
+	}
+}
+
+func TestRunBytecodeErrorPositions(t *testing.T) {
+	programs := []string{
+		// The body of get is an expression, so the error is in the statement
+		// that called it
+		"let get = def (ys: list<int>, i: int) -> int { ys[i] } let xs = list[1] let a = get(xs, 0) let b = get(xs, 3)",
+		"let get = def (ys: list<int>) -> int { ys[5] } let call = def (g: (list<int>) -> int, ys: list<int>) -> int { g(ys) } let b = call(get, list[1])",
+		"let get = def (ys: list<int>) -> int { let n = len(ys) ys[n] } let xs = list[1] let b = get(xs)",
+		// After a call returns the error is in the statement that made it
+		"let one = def () -> int { let a = 1 a } let xs = list[1] let b = xs[one() + one()]",
+	}
+
+	for _, s := range programs {
+		lexer := lexer.New(&s)
+		program, parseErr := parser.ParseProgram(lexer)
+		if parseErr != nil {
+			t.Fatal("Could not parse program: ", parseErr)
+		}
+		info, checkErr := checker.Check(*program)
+		if checkErr != nil {
+			t.Fatalf("Failed to check: %s", checkErr.ToError("foo"))
+		}
+		compiled, compileErr := Compile(*program, info)
+		if compileErr != nil {
+			t.Fatalf("Failed to compile: %s", compileErr.ToError("foo"))
+		}
+
+		_, expected := interpreter.Run(*program, info)
+		_, err := Run(compiled)
+		if expected == nil || err == nil {
+			t.Fatalf("Expected \"%s\" to fail on both the interpreter and the vm", s)
+		}
+		if err.Position != expected.Position {
+			t.Fatalf("Expected the error in \"%s\" at position %d like the interpreter, got %d", s, expected.Position, err.Position)
+		}
+	}
+}
+
+func TestEncodeBytecode(t *testing.T) {
+	program := compileHelper(t, `
+		let f = def (x) { 300 - x }
+		let y = f(1)
+	`)
+
+	decoded, err := Decode(Encode(program))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, runtimeErr := Run(decoded)
+	if runtimeErr != nil || ExitCode(result) != 299-256 {
+		t.Fatalf("Expected the decoded program to exit with 43, got %v", result)
+	}
+	if len(decoded.Functions) != 2 || decoded.Functions[1].Parameters != 1 || len(decoded.Functions[0].Positions) != 2 {
+		t.Fatal("Expected the decoded program to have the same functions")
+	}
+
+	encoded := Encode(program)
+	for _, corrupt := range [][]byte{
+		[]byte("hello"),
+		encoded[:len(encoded)-3],
+		append(append([]byte{}, encoded...), 0),
+	} {
+		if _, err := Decode(corrupt); err == nil {
+			t.Fatalf("Expected %v not to decode", corrupt)
+		}
+	}
+
+	// A jump into the middle of an instruction
+	invalid := &Program{Functions: []*Function{{Code: []byte{byte(OpJump), 0, 1, byte(OpHalt)}}}}
+	if _, err := Decode(Encode(invalid)); err == nil || !strings.Contains(err.Error(), "not an instruction") {
+		t.Fatalf("Expected the jump to be rejected, got %v", err)
+	}
+}